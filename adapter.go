@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// rfkillDir is where the kernel exposes rfkill switches.
+const rfkillDir = "/sys/class/rfkill"
+
+// bringUpAdapter gets a controller ready to scan: it clears an rfkill soft
+// block, powers the controller on and opens a scanner on it. On a fresh boot
+// the controller can take a while to appear or accept power, so failures are
+// retried with backoff until wait has passed.
+func bringUpAdapter(ctx context.Context, conn *dbus.Conn, id string, wait time.Duration) (*leScanner, error) {
+	deadline := time.Now().Add(wait)
+	delay := time.Second
+	for {
+		scanner, err := tryBringUpAdapter(conn, id)
+		if err == nil {
+			return scanner, nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+		logWarn("Adapter %s not ready (%v), retrying in %s", id, err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 10*time.Second)
+	}
+}
+
+func tryBringUpAdapter(conn *dbus.Conn, id string) (*leScanner, error) {
+	if err := rfkillUnblock(id); err != nil {
+		return nil, err
+	}
+	scanner, err := newLEScanner(id)
+	if err != nil {
+		return nil, err
+	}
+	powered, err := getProperty(scanner.adapter, "org.bluez.Adapter1.Powered")
+	if err != nil {
+		scanner.Close()
+		return nil, err
+	}
+	if on, _ := powered.Value().(bool); !on {
+		logInfo("Powering on %s", id)
+		if err := setPowered(conn, id, true); err != nil {
+			scanner.Close()
+			return nil, fmt.Errorf("power on: %w", err)
+		}
+	}
+	return scanner, nil
+}
+
+// rfkillUnblock clears the soft block on a controller's rfkill switch, if
+// it has one. A hard block means a physical switch and can't be cleared.
+func rfkillUnblock(id string) error {
+	switches, err := filepath.Glob(filepath.Join(rfkillDir, "rfkill*"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range switches {
+		if readSysfs(dir, "name") != id {
+			continue
+		}
+		if readSysfs(dir, "hard") == "1" {
+			return fmt.Errorf("%s is hard-blocked by rfkill", id)
+		}
+		if readSysfs(dir, "soft") == "1" {
+			logInfo("Clearing rfkill soft block on %s", id)
+			if err := os.WriteFile(filepath.Join(dir, "soft"), []byte("0"), 0644); err != nil {
+				return fmt.Errorf("rfkill unblock: %w", err)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func readSysfs(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,44 @@
+package main
+
+// BLE address types. Random addresses are subdivided by the top two bits of
+// their most significant byte (Core Spec Vol 6, Part B, 1.3).
+const (
+	addressPublic = "public"
+	addressStatic = "static" // random static, stable until power cycle
+	addressRPA    = "RPA"    // resolvable private, rotates every ~15 minutes
+	addressNRPA   = "NRPA"   // non-resolvable private
+	addressLocal  = "local"  // WiFi BSSID with the locally administered bit set
+)
+
+// leAddressType classifies a BLE address from BlueZ's AddressType and the
+// address's most significant byte.
+func leAddressType(random bool, msb byte) string {
+	if !random {
+		return addressPublic
+	}
+	switch msb >> 6 {
+	case 0b11:
+		return addressStatic
+	case 0b01:
+		return addressRPA
+	case 0b00:
+		return addressNRPA
+	default:
+		// 0b10 is reserved; treat it as the most ephemeral kind.
+		return addressNRPA
+	}
+}
+
+// addressTypeTag returns the capabilities suffix for an address type. Public
+// addresses get no tag so existing output is unchanged.
+func addressTypeTag(addrType string) string {
+	switch addrType {
+	case addressStatic:
+		return "[Static]"
+	case addressRPA:
+		return "[RPA]"
+	case addressNRPA:
+		return "[NRPA]"
+	}
+	return ""
+}
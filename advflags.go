@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Bits of the Flags AD structure (Core Specification Supplement, 1.3).
+var advFlagNames = []struct {
+	bit  byte
+	name string
+}{
+	{0x01, "limited"},         // LE Limited Discoverable Mode
+	{0x02, "general"},         // LE General Discoverable Mode
+	{0x04, "le-only"},         // BR/EDR Not Supported
+	{0x08, "dual-controller"}, // simultaneous LE and BR/EDR, controller
+	{0x10, "dual-host"},       // simultaneous LE and BR/EDR, host
+}
+
+// advertisingFlags returns the Flags a device advertises, as BlueZ keeps
+// them, and whether it advertises any.
+func advertisingFlags(props map[string]dbus.Variant) (byte, bool) {
+	flags, _ := props["AdvertisingFlags"].Value().([]byte)
+	if len(flags) == 0 {
+		return 0, false
+	}
+	return flags[0], true
+}
+
+// connectable guesses whether a device accepts connections. BlueZ passes on
+// neither the advertising PDU type nor whether it was scannable or
+// directed, but a device advertising a discoverable mode in its Flags is a
+// peripheral expecting connections; beacons mostly advertise none, or no
+// Flags at all.
+func connectable(props map[string]dbus.Variant) bool {
+	flags, ok := advertisingFlags(props)
+	return ok && flags&0x03 != 0
+}
+
+// describeFlags lists the names of the bits set in flags, e.g.
+// "general,le-only".
+func describeFlags(flags byte) string {
+	var names []string
+	for _, f := range advFlagNames {
+		if flags&f.bit != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+func TestConnectable(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		flags       []byte // AdvertisingFlags property, nil if absent
+		connectable bool
+		describe    string
+	}{
+		{"beacon without Flags", nil, false, ""},
+		{"beacon, BR/EDR not supported", []byte{0x04}, false, "le-only"},
+		{"empty Flags", []byte{}, false, ""},
+		{"peripheral, general discoverable", []byte{0x06}, true, "general,le-only"},
+		{"peripheral, limited discoverable", []byte{0x05}, true, "limited,le-only"},
+		{"dual-mode phone", []byte{0x1A}, true, "general,dual-controller,dual-host"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			props := map[string]dbus.Variant{"Address": dbus.MakeVariant("00:11:22:33:44:55")}
+			if tt.flags != nil {
+				props["AdvertisingFlags"] = dbus.MakeVariant(tt.flags)
+			}
+			if got := connectable(props); got != tt.connectable {
+				t.Errorf("connectable = %v, want %v", got, tt.connectable)
+			}
+			flags, ok := advertisingFlags(props)
+			if ok != (len(tt.flags) > 0) {
+				t.Errorf("advertisingFlags reports Flags %v, want %v", ok, len(tt.flags) > 0)
+			}
+			if got := describeFlags(flags); got != tt.describe {
+				t.Errorf("describeFlags(%#02x) = %q, want %q", flags, got, tt.describe)
+			}
+		})
+	}
+}
+
+// TestConnectableRows checks what reaches the row for a beacon frame and a
+// connectable peripheral's.
+func TestConnectableRows(t *testing.T) {
+	ibeacon := []byte{
+		0x02, 0x15, // iBeacon, 21 bytes
+		0xE2, 0xC5, 0x6D, 0xB5, 0xDF, 0xFB, 0x48, 0xD2, 0xB0, 0x60, 0xD0, 0xF5, 0xA7, 0x10, 0x96, 0xE0,
+		0x00, 0x01, 0x00, 0x02, // major, minor
+		0xC5, // measured power
+	}
+	for _, tt := range []struct {
+		name        string
+		flags       []byte
+		mfgr        bluetooth.ManufacturerDataElement
+		connectable bool
+		advFlags    string
+	}{
+		{"iBeacon", []byte{0x04}, bluetooth.ManufacturerDataElement{CompanyID: appleCompanyID, Data: ibeacon}, false, "le-only"},
+		{"peripheral", []byte{0x06}, bluetooth.ManufacturerDataElement{CompanyID: 0x0075, Data: []byte{0x42, 0x04}}, true, "general,le-only"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := &mockLocation{}
+			loc.Set(testFix(1, 2))
+			p, sink := newTestPipeline(t, testConfig(t), loc)
+			p.props.Update("hci0", map[string]dbus.Variant{
+				"Address":          dbus.MakeVariant("C0:11:22:33:44:55"),
+				"AdvertisingFlags": dbus.MakeVariant(tt.flags),
+			})
+			p.advertisement(Advertisement{
+				Adapter:          "hci0",
+				Address:          "C0:11:22:33:44:55",
+				RSSI:             -60,
+				ManufacturerData: []bluetooth.ManufacturerDataElement{tt.mfgr},
+			})
+
+			rows := sink.rows()
+			if len(rows) != 1 {
+				t.Fatalf("wrote %d rows, want 1", len(rows))
+			}
+			s := rows[0]
+			if s.Connectable != tt.connectable || s.AdvFlags != tt.advFlags {
+				t.Errorf("Connectable %v, AdvFlags %q, want %v, %q", s.Connectable, s.AdvFlags, tt.connectable, tt.advFlags)
+			}
+			if tagged := strings.Contains(s.Capabilities, "[conn]"); tagged != tt.connectable {
+				t.Errorf("Capabilities %q, want [conn] %v", s.Capabilities, tt.connectable)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import "github.com/godbus/dbus/v5"
+
+// appearanceLegend returns a capabilities legend for a BLE device from its
+// GAP Appearance, or failing that from the Icon BlueZ picked for it. Most
+// BLE devices have no Class of Device, so without this nearly every one
+// would be "Misc". The names are those of deviceTypeLegend. It returns ""
+// when nothing fits.
+func appearanceLegend(props map[string]dbus.Variant) string {
+	if appearance, ok := props["Appearance"].Value().(uint16); ok {
+		if legend := appearanceName(appearance); legend != "" {
+			return legend
+		}
+	}
+	icon, _ := props["Icon"].Value().(string)
+	return iconLegends[icon]
+}
+
+// appearanceName maps a GAP Appearance value, from the Bluetooth SIG
+// Assigned Numbers, to a legend name. The top ten bits are the category and
+// the low six the subcategory; an unlisted subcategory falls back to its
+// category.
+func appearanceName(appearance uint16) string {
+	if legend, ok := appearanceLegends[appearance]; ok {
+		return legend
+	}
+	return appearanceLegends[appearance&^0x3F]
+}
+
+// appearanceLegends holds categories (subcategory 0) and the subcategories
+// that have a closer legend of their own.
+var appearanceLegends = map[uint16]string{
+	0x0040: "Phone",
+	0x0080: "Computer",
+	0x0081: "Desktop",
+	0x0082: "Server",
+	0x0083: "Laptop",
+	0x0084: "PDA",
+	0x0085: "Palm",
+	0x0086: "Wearable Computer",
+	0x00C0: "Watch",
+	0x0140: "Monitor",
+	0x0180: "Controller", // remote control
+	0x01C0: "Glasses",
+	0x0280: "Portable Audio", // media player
+	0x0300: "Thermometer",
+	0x0340: "Pulse", // heart rate sensor
+	0x0380: "Blood Pressure",
+	0x03C1: "Keyboard",
+	0x03C2: "Pointer",    // mouse
+	0x03C3: "Controller", // joystick
+	0x03C4: "Controller", // gamepad
+	0x03C5: "Pointer",    // digitizer tablet
+	0x03C7: "Pointer",    // digital pen
+	0x0400: "Glucose",
+	0x0440: "Health", // running and walking sensor
+	0x0480: "Health", // cycling sensor
+	0x0500: "Modem/GW",
+	0x0840: "Speaker",
+	0x0880: "A/V", // audio source
+	0x0881: "Mic",
+	0x08C0: "Vehicle",
+	0x0940: "Headphones", // wearable audio device
+	0x0941: "Headphones", // earbud
+	0x0942: "Headset",
+	0x09C0: "A/V",
+	0x09C1: "HiFi", // amplifier
+	0x09C2: "HiFi", // receiver
+	0x09C3: "HiFi", // radio
+	0x09C4: "HiFi", // tuner
+	0x09CA: "Settop",
+	0x0A00: "Monitor", // display equipment
+	0x0A01: "Display/Speaker",
+	0x0A40: "Health", // hearing aid
+	0x0A80: "Game",
+	0x0C40: "PulseOxy",
+	0x0C80: "Scale",
+	0x0D00: "Glucose", // continuous glucose monitor
+	0x0D40: "Health",  // insulin pump
+	0x0D80: "Health",  // medication delivery
+	0x0DC0: "Health",  // spirometer
+}
+
+// iconLegends maps the freedesktop icon names BlueZ uses to legend names.
+var iconLegends = map[string]string{
+	"computer":          "Computer",
+	"phone":             "Phone",
+	"modem":             "Modem/GW",
+	"network-wireless":  "Modem/GW",
+	"audio-headset":     "Headset",
+	"audio-headphones":  "Headphones",
+	"audio-card":        "A/V",
+	"camera-photo":      "Camera",
+	"camera-video":      "Camcorder",
+	"video-display":     "Monitor",
+	"multimedia-player": "Portable Audio",
+	"input-keyboard":    "Keyboard",
+	"input-mouse":       "Pointer",
+	"input-tablet":      "Pointer",
+	"input-gaming":      "Controller",
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestAppearanceLegend(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		appearance uint16 // Appearance property, 0 if absent
+		icon       string // Icon property, "" if absent
+		want       string
+	}{
+		{"category", 0x0040, "", "Phone"},
+		{"listed subcategory", 0x0083, "", "Laptop"},
+		{"unlisted subcategory", 0x00C5, "", "Watch"},
+		{"earbud", 0x0941, "", "Headphones"},
+		{"Appearance wins over Icon", 0x03C1, "input-mouse", "Keyboard"},
+		{"unknown Appearance, Icon", 0x0FC0, "audio-headset", "Headset"},
+		{"Icon only", 0, "input-gaming", "Controller"},
+		{"unknown Appearance", 0x0FC0, "", ""},
+		{"unknown Icon", 0, "weird-gadget", ""},
+		{"neither", 0, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			props := map[string]dbus.Variant{}
+			if tt.appearance != 0 {
+				props["Appearance"] = dbus.MakeVariant(tt.appearance)
+			}
+			if tt.icon != "" {
+				props["Icon"] = dbus.MakeVariant(tt.icon)
+			}
+			if got := appearanceLegend(props); got != tt.want {
+				t.Errorf("appearanceLegend = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAppearanceLabel checks the legend on BLE rows: a Class of Device wins
+// over the Appearance when a device has both, as in WiGLE Android.
+func TestAppearanceLabel(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		class      uint32
+		appearance uint16
+		want       string
+	}{
+		{"Class and Appearance", 0x5A020C, 0x00C0, "Smartphone [LE]"},
+		{"Appearance only", 0, 0x00C0, "Watch [LE]"},
+		{"Class only", 0x240418, 0, "Headphones [LE]"},
+		{"neither", 0, 0, "Misc [LE]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := &mockLocation{}
+			loc.Set(testFix(1, 2))
+			p, sink := newTestPipeline(t, testConfig(t), loc)
+			props := map[string]dbus.Variant{"Address": dbus.MakeVariant("00:11:22:33:44:55")}
+			if tt.class != 0 {
+				props["Class"] = dbus.MakeVariant(tt.class)
+			}
+			if tt.appearance != 0 {
+				props["Appearance"] = dbus.MakeVariant(tt.appearance)
+			}
+			p.props.Update("hci0", props)
+			p.advertisement(Advertisement{Adapter: "hci0", Address: "00:11:22:33:44:55", RSSI: -60})
+
+			rows := sink.rows()
+			if len(rows) != 1 {
+				t.Fatalf("wrote %d rows, want 1", len(rows))
+			}
+			if got := rows[0].Capabilities; !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Capabilities %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
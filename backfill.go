@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// backfillLimit bounds how many sightings are held while waiting for a fix.
+const backfillLimit = 10000
+
+// backfillDrift is the speed, in m/s, assumed when widening the accuracy of
+// a back-filled sighting: the longer it waited for the fix, the further
+// from the fix's position it may have been heard.
+const backfillDrift = 5.0
+
+// backfillBuffer holds sightings made without a GPS fix so they can be
+// written with the position of the next good fix instead of being dropped.
+type backfillBuffer struct {
+	window time.Duration // oldest sighting still worth back-filling
+
+	mu      sync.Mutex
+	pending []Sighting
+	dropped uint64
+}
+
+func newBackfillBuffer(window time.Duration) *backfillBuffer {
+	return &backfillBuffer{window: window}
+}
+
+// Add holds s until the next fix. It returns false when the buffer is full.
+func (b *backfillBuffer) Add(s Sighting) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) >= backfillLimit {
+		return false
+	}
+	b.pending = append(b.pending, s)
+	return true
+}
+
+// Take empties the buffer, returning the sightings made within the window
+// before now and discarding older ones.
+func (b *backfillBuffer) Take(now time.Time) []Sighting {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	var fresh []Sighting
+	for _, s := range b.pending {
+		if now.Sub(s.Timestamp) <= b.window {
+			fresh = append(fresh, s)
+		} else {
+			b.dropped++
+		}
+	}
+	b.pending = nil
+	return fresh
+}
+
+// Dropped returns how many held sightings were discarded because the fix
+// came too late, counting any still waiting.
+func (b *backfillBuffer) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped + uint64(len(b.pending))
+}
+
+// backfillLocation returns the fix to stamp on a sighting made age before
+// it, with the error estimate widened to match.
+func backfillLocation(loc LocationData, age time.Duration) LocationData {
+	loc.Error += max(age.Seconds(), 0) * backfillDrift
+	return loc
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// appleCompanyID is Apple's Bluetooth SIG company identifier.
+const appleCompanyID = 0x004C
+
+// iBeacon is a decoded Apple iBeacon advertisement.
+type iBeacon struct {
+	UUID    string
+	Major   uint16
+	Minor   uint16
+	TxPower int8 // calibrated RSSI at 1 m
+}
+
+func (b iBeacon) String() string {
+	return fmt.Sprintf("%s/%d/%d", b.UUID, b.Major, b.Minor)
+}
+
+// parseIBeacon decodes Apple manufacturer data carrying an iBeacon frame:
+// type 0x02, length 0x15, then the proximity UUID, major, minor and TX
+// power. Short or foreign frames are rejected rather than read past.
+func parseIBeacon(data []byte) (iBeacon, bool) {
+	if len(data) < 23 || data[0] != 0x02 || data[1] != 0x15 {
+		return iBeacon{}, false
+	}
+	u := data[2:18]
+	return iBeacon{
+		UUID:    fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]),
+		Major:   binary.BigEndian.Uint16(data[18:20]),
+		Minor:   binary.BigEndian.Uint16(data[20:22]),
+		TxPower: int8(data[22]),
+	}, true
+}
+
+// eddystoneUUID is the 16-bit service UUID Eddystone frames are sent under.
+const eddystoneUUID = 0xFEAA
+
+// Eddystone frame types.
+const (
+	eddystoneUID = 0x00
+	eddystoneURL = 0x10
+	eddystoneTLM = 0x20
+)
+
+// eddystoneFrame is a decoded Eddystone service data frame. UID and URL
+// frames carry an ID; TLM frames carry telemetry.
+type eddystoneFrame struct {
+	Type        byte
+	ID          string
+	BatteryMV   uint16
+	Temperature float64 // °C
+}
+
+var eddystoneSchemes = []string{"http://www.", "https://www.", "http://", "https://"}
+
+var eddystoneExpansions = []string{
+	".com/", ".org/", ".edu/", ".net/", ".info/", ".biz/", ".gov/",
+	".com", ".org", ".edu", ".net", ".info", ".biz", ".gov",
+}
+
+// parseEddystone decodes Eddystone service data. Unknown frame types and
+// truncated frames are rejected.
+func parseEddystone(data []byte) (eddystoneFrame, bool) {
+	if len(data) < 2 {
+		return eddystoneFrame{}, false
+	}
+	f := eddystoneFrame{Type: data[0]}
+	switch f.Type {
+	case eddystoneUID:
+		// type, TX power, 10-byte namespace, 6-byte instance
+		if len(data) < 18 {
+			return eddystoneFrame{}, false
+		}
+		f.ID = fmt.Sprintf("%X:%X", data[2:12], data[12:18])
+	case eddystoneURL:
+		// type, TX power, scheme prefix, encoded URL
+		if len(data) < 3 || int(data[2]) >= len(eddystoneSchemes) {
+			return eddystoneFrame{}, false
+		}
+		url := eddystoneSchemes[data[2]]
+		for _, c := range data[3:] {
+			if int(c) < len(eddystoneExpansions) {
+				url += eddystoneExpansions[c]
+			} else if c > 0x20 && c < 0x7F {
+				url += string(rune(c))
+			}
+		}
+		f.ID = url
+	case eddystoneTLM:
+		// type, version, battery mV, temperature (signed 8.8 fixed point),
+		// then advertisement and uptime counters we don't use.
+		if len(data) < 6 || data[1] != 0 {
+			return eddystoneFrame{}, false
+		}
+		f.BatteryMV = binary.BigEndian.Uint16(data[2:4])
+		f.Temperature = float64(int16(binary.BigEndian.Uint16(data[4:6]))) / 256
+	default:
+		return eddystoneFrame{}, false
+	}
+	return f, true
+}
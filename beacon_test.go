@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseEddystone(t *testing.T) {
+	uid := []byte{
+		0x00, 0xEE, // UID, TX power -18 dBm
+		0x8B, 0x0C, 0xA7, 0x50, 0xE7, 0xA7, 0x4E, 0x14, 0xBD, 0x99, // namespace
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x2A, // instance
+		0x00, 0x00, // reserved
+	}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		ok   bool
+		want eddystoneFrame
+	}{
+		{"UID", uid, true, eddystoneFrame{Type: eddystoneUID, ID: "8B0CA750E7A74E14BD99:00000000002A"}},
+		{"UID without reserved bytes", uid[:18], true, eddystoneFrame{Type: eddystoneUID, ID: "8B0CA750E7A74E14BD99:00000000002A"}},
+		{"URL", append([]byte{0x10, 0xEE, 0x01}, "example\x00"...), true,
+			eddystoneFrame{Type: eddystoneURL, ID: "https://www.example.com/"}},
+		{"URL with a path", append([]byte{0x10, 0xEE, 0x02}, "goo.gl/S6zT6P"...), true,
+			eddystoneFrame{Type: eddystoneURL, ID: "http://goo.gl/S6zT6P"}},
+		{"URL, expansion mid-way", append([]byte{0x10, 0xEE, 0x03}, "wigle\x08x"...), true,
+			eddystoneFrame{Type: eddystoneURL, ID: "https://wigle.orgx"}},
+		{"URL, control characters dropped", append([]byte{0x10, 0xEE, 0x02}, "a\x20\x7F\x0Eb"...), true,
+			eddystoneFrame{Type: eddystoneURL, ID: "http://ab"}},
+		{"TLM", []byte{0x20, 0x00, 0x0B, 0xB8, 0x15, 0x80, 0, 0, 0x01, 0x00, 0, 0, 0x10, 0x00}, true,
+			eddystoneFrame{Type: eddystoneTLM, BatteryMV: 3000, Temperature: 21.5}},
+		{"TLM below freezing", []byte{0x20, 0x00, 0x0C, 0xE4, 0xFE, 0xC0}, true,
+			eddystoneFrame{Type: eddystoneTLM, BatteryMV: 3300, Temperature: -1.25}},
+
+		{"UID truncated", uid[:17], false, eddystoneFrame{}},
+		{"URL without a scheme", []byte{0x10, 0xEE}, false, eddystoneFrame{}},
+		{"URL with an unknown scheme", []byte{0x10, 0xEE, 0x04, 'a'}, false, eddystoneFrame{}},
+		{"TLM truncated", []byte{0x20, 0x00, 0x0B, 0xB8, 0x15}, false, eddystoneFrame{}},
+		{"encrypted TLM", []byte{0x20, 0x01, 0x0B, 0xB8, 0x15, 0x80}, false, eddystoneFrame{}},
+		{"EID", []byte{0x30, 0xEE, 1, 2, 3, 4, 5, 6, 7, 8}, false, eddystoneFrame{}},
+		{"type only", []byte{0x00}, false, eddystoneFrame{}},
+		{"empty", nil, false, eddystoneFrame{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseEddystone(tt.data)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseEddystone(% X) = %+v, %v, want %+v, %v", tt.data, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
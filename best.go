@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// bestCSV keeps the strongest sighting of every device and writes them as a
+// WiGLE CSV with one row per device when closed, and at each rotation of
+// the raw CSV. WiGLE places a device best when the reported position is
+// where it was heard loudest.
+type bestCSV struct {
+	base      string
+	compress  bool
+	precision wigle.Precision
+
+	mu   sync.Mutex
+	best map[string]Sighting // keyed by transport and address
+	path string
+	seq  int // files written so far
+	rows uint64
+}
+
+func newBestCSV(base string, compress bool, precision wigle.Precision) *bestCSV {
+	return &bestCSV{
+		base:      base,
+		compress:  compress,
+		precision: precision,
+		best:      make(map[string]Sighting),
+	}
+}
+
+func (b *bestCSV) Write(s Sighting) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := s.Type + " " + s.Address
+	if prev, ok := b.best[key]; ok && prev.RSSI >= s.RSSI {
+		return nil
+	}
+	b.best[key] = s
+	return nil
+}
+
+// Path returns the file last written, or "" before then.
+func (b *bestCSV) Path() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.path
+}
+
+// Rows returns the number of rows written, over all files.
+func (b *bestCSV) Rows() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rows
+}
+
+// Rotate writes the devices seen since the last file and starts over, so
+// that each file of a rotating raw CSV has a best file of its own. Nothing
+// is written if no device was seen.
+func (b *bestCSV) Rotate() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.best) == 0 {
+		return nil
+	}
+	if err := b.writeFile(); err != nil {
+		return err
+	}
+	logInfo("Wrote %d devices to %s", len(b.best), b.path)
+	b.best = make(map[string]Sighting)
+	b.seq++
+	return nil
+}
+
+// Close writes the devices seen since the last file.
+func (b *bestCSV) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeFile()
+}
+
+// writeFile writes one row per device, in the order they were first seen,
+// numbering the files after the first like wigleCSV does. b.mu must be
+// held.
+func (b *bestCSV) writeFile() error {
+	sightings := make([]Sighting, 0, len(b.best))
+	for _, s := range b.best {
+		sightings = append(sightings, s)
+	}
+	sort.Slice(sightings, func(i, j int) bool {
+		return sightings[i].FirstSeen.Before(sightings[j].FirstSeen)
+	})
+
+	base := b.base
+	if b.seq > 0 {
+		base += fmt.Sprintf("-%03d", b.seq)
+	}
+	out, err := newWigleCSV(base, b.compress, b.precision, 0, 0)
+	if err != nil {
+		return err
+	}
+	b.path = out.Path()
+	for _, s := range sightings {
+		if err := out.Write(s); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	err = out.Close()
+	b.rows += out.Rows()
+	return err
+}
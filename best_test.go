@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// readBest returns the address and RSSI of each row of a best CSV.
+func readBest(t *testing.T, path string) map[string]int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := wigle.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]int)
+	for _, r := range records {
+		rows[r.MAC] = r.RSSI
+	}
+	return rows
+}
+
+func TestBestCSVRotate(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "capture-best")
+	b := newBestCSV(base, false, wigle.DefaultPrecision)
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	sight := func(addr string, rssi int16) {
+		b.Write(Sighting{Address: addr, Type: "BLE", RSSI: rssi, FirstSeen: start, Timestamp: start,
+			Location: testFix(1, 2)})
+	}
+
+	sight("00:11:22:33:44:01", -70)
+	sight("00:11:22:33:44:01", -50)
+	sight("00:11:22:33:44:01", -60)
+	sight("00:11:22:33:44:02", -80)
+	if err := b.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Path() != base+".csv" {
+		t.Errorf("first file %s, want %s.csv", b.Path(), base)
+	}
+	// Nothing seen since, so no file.
+	if err := b.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sight("00:11:22:33:44:02", -90)
+	sight("00:11:22:33:44:03", -40)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Path() != base+"-001.csv" {
+		t.Errorf("second file %s, want %s-001.csv", b.Path(), base)
+	}
+	if n := b.Rows(); n != 4 {
+		t.Errorf("Rows = %d, want 4", n)
+	}
+
+	for path, want := range map[string]map[string]int{
+		base + ".csv":     {"00:11:22:33:44:01": -50, "00:11:22:33:44:02": -80},
+		base + "-001.csv": {"00:11:22:33:44:02": -90, "00:11:22:33:44:03": -40},
+	} {
+		if got := readBest(t, path); !maps.Equal(got, want) {
+			t.Errorf("%s holds %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+	if _, err := os.Stat(base + "-002.csv"); !os.IsNotExist(err) {
+		t.Errorf("empty rotation wrote a file: %v", err)
+	}
+}
+
+func TestBestCSVRotateQueued(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "capture-best")
+	b := newBestCSV(base, false, wigle.DefaultPrecision)
+	sinks := newTeeSink()
+	sinks.Add("best CSV", b)
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 100 {
+		sinks.Write(Sighting{Address: fmt.Sprintf("00:11:22:33:44:%02x", i), Type: "BLE", RSSI: -60,
+			FirstSeen: start, Timestamp: start, Location: testFix(1, 2)})
+	}
+
+	// As when the raw CSV rotates: what was queued before goes in the
+	// finished file.
+	if err := sinks.FlushSink(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBest(t, base+".csv"); len(got) != 100 {
+		t.Errorf("rotated file holds %d devices, want 100", len(got))
+	}
+	if err := sinks.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBest(t, base+"-001.csv"); len(got) != 0 {
+		t.Errorf("%d queued sightings spilled into the next file", len(got))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ownAddresses returns the addresses of the host's Bluetooth adapters and of
+// every device paired, bonded or connected to them, e.g. the car's handsfree
+// kit. These are the host's own gear rather than anything encountered.
+func ownAddresses(conn *dbus.Conn) ([]string, error) {
+	objects, err := managedObjects(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, ifaces := range objects {
+		if props, ok := ifaces["org.bluez.Adapter1"]; ok {
+			if addr, ok := props["Address"].Value().(string); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+		if props, ok := ifaces["org.bluez.Device1"]; ok {
+			paired, _ := props["Paired"].Value().(bool)
+			bonded, _ := props["Bonded"].Value().(bool)
+			connected, _ := props["Connected"].Value().(bool)
+			if !paired && !bonded && !connected {
+				continue
+			}
+			if addr, ok := props["Address"].Value().(string); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// setPowered switches a BlueZ controller on or off.
+func setPowered(conn *dbus.Conn, id string, on bool) error {
+	return setProperty(conn.Object("org.bluez", adapterPath(id)), "org.bluez.Adapter1.Powered", on)
+}
+
+// adapterIDs lists the BlueZ controllers present, such as "hci0", sorted.
+func adapterIDs(conn *dbus.Conn) ([]string, error) {
+	objects, err := managedObjects(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for path, ifaces := range objects {
+		if _, ok := ifaces["org.bluez.Adapter1"]; ok {
+			ids = append(ids, strings.TrimPrefix(string(path), "/org/bluez/"))
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// bluezStorage is where bluetoothd keeps what it has learnt about devices
+// across restarts.
+const bluezStorage = "/var/lib/bluetooth"
+
+// knownDevices returns the Device1 properties of every device BlueZ knows on
+// the controller id, including those remembered from earlier sessions.
+func knownDevices(conn *dbus.Conn, id string) ([]map[string]dbus.Variant, error) {
+	objects, err := managedObjects(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []map[string]dbus.Variant
+	for path, ifaces := range objects {
+		if props, ok := ifaces["org.bluez.Device1"]; ok && onAdapter(path, id) {
+			devices = append(devices, props)
+		}
+	}
+	return devices, nil
+}
+
+// storedDeviceTimes returns, per device address, the oldest modification
+// time of what bluetoothd stored about it for the controller id: its cache
+// file and, for paired devices, its info file. BlueZ records no first-seen
+// time of its own, so this is the best estimate there is of when a device
+// was last met before this session.
+func storedDeviceTimes(conn *dbus.Conn, id string) (map[string]time.Time, error) {
+	v, err := getProperty(conn.Object("org.bluez", adapterPath(id)), "org.bluez.Adapter1.Address")
+	if err != nil {
+		return nil, err
+	}
+	adapterAddr, _ := v.Value().(string)
+	dir := filepath.Join(bluezStorage, adapterAddr)
+
+	times := make(map[string]time.Time)
+	note := func(addr, path string) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if t, ok := times[addr]; !ok || info.ModTime().Before(t) {
+			times[addr] = info.ModTime()
+		}
+	}
+	cached, err := os.ReadDir(filepath.Join(dir, "cache"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range cached {
+		note(e.Name(), filepath.Join(dir, "cache", e.Name()))
+	}
+	paired, _ := os.ReadDir(dir)
+	for _, e := range paired {
+		// Device directories are named by address; skip "cache" and
+		// the adapter's own "settings".
+		if e.IsDir() && strings.Count(e.Name(), ":") == 5 {
+			note(e.Name(), filepath.Join(dir, e.Name(), "info"))
+		}
+	}
+	return times, nil
+}
+
+// bluezName returns the name BlueZ has for a device: its Name, or else an
+// Alias set by the user. When a device has no name BlueZ makes up an Alias
+// from the address, such as "AA-BB-CC-DD-EE-FF"; that is left out so a
+// missing name stays blank rather than becoming the MAC.
+func bluezName(props map[string]dbus.Variant) string {
+	if name, _ := props["Name"].Value().(string); name != "" {
+		return name
+	}
+	alias, _ := props["Alias"].Value().(string)
+	addr, _ := props["Address"].Value().(string)
+	if strings.EqualFold(alias, strings.ReplaceAll(addr, ":", "-")) || strings.EqualFold(alias, addr) {
+		return ""
+	}
+	return alias
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// classicScanner discovers classic (BR/EDR) devices through BlueZ inquiry.
+// The BLE scanner only asks BlueZ for LE results, so this runs a second
+// discovery session on its own D-Bus connection; BlueZ merges the filters of
+// both sessions and interleaves inquiry with LE scanning.
+type classicScanner struct {
+	id      string
+	conn    *dbus.Conn
+	adapter dbus.BusObject
+	pause   *pauseSwitch
+}
+
+func newClassicScanner(id string, pause *pauseSwitch) (*classicScanner, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	return &classicScanner{
+		id:      id,
+		conn:    conn,
+		adapter: conn.Object("org.bluez", adapterPath(id)),
+		pause:   pause,
+	}, nil
+}
+
+// Run scans until ctx is cancelled or the scan fails, stopping while
+// scanning is paused. Losing the bus or BlueZ isn't a failure: the scan is
+// retried with backoff until they are back.
+func (c *classicScanner) Run(ctx context.Context, found func(addr, name string, class uint32, rssi int16)) error {
+	var backoff time.Duration
+	for {
+		if !c.pause.Wait(ctx) {
+			return nil
+		}
+		scanCtx, endScan := c.pause.Context(ctx)
+		err := c.Scan(scanCtx, found)
+		paused := scanCtx.Err() != nil
+		endScan()
+		if ctx.Err() != nil {
+			return err
+		}
+		if err == nil && paused {
+			continue
+		}
+		if !busUnavailable(err) {
+			return err
+		}
+		backoff = min(max(2*backoff, time.Second), dbusMaxBackoff)
+		logWarn("classic discovery on %s interrupted (%v), retrying in %s", c.id, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// Scan runs inquiry until ctx is cancelled, calling found for every inquiry
+// result. Only devices reporting a Class of Device are passed on, since LE
+// advertisements never carry one. Like leScanner.Scan, it reconnects first
+// if the bus connection has dropped, and errors caused by losing the bus
+// wrap errBusLost.
+func (c *classicScanner) Scan(ctx context.Context, found func(addr, name string, class uint32, rssi int16)) (err error) {
+	if !c.conn.Connected() {
+		conn, err := dbus.ConnectSystemBus()
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBusLost, err)
+		}
+		c.conn = conn
+		c.adapter = conn.Object("org.bluez", adapterPath(c.id))
+	}
+	defer c.conn.Close()
+	defer func() {
+		if err != nil && !c.conn.Connected() && !errors.Is(err, errBusLost) {
+			err = fmt.Errorf("%w: %v", errBusLost, err)
+		}
+	}()
+
+	filter := map[string]dbus.Variant{"Transport": dbus.MakeVariant("bredr")}
+	if err := callTimeout(c.adapter, dbusTimeout, "org.bluez.Adapter1.SetDiscoveryFilter", filter).Err; err != nil {
+		return err
+	}
+
+	if err := c.conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus.ObjectManager"),
+		dbus.WithMatchMember("InterfacesAdded"),
+	); err != nil {
+		return err
+	}
+	if err := c.conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchPathNamespace(adapterPath(c.id)),
+	); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 64)
+	c.conn.Signal(signals)
+
+	if err := callTimeout(c.adapter, dbusSlowTimeout, "org.bluez.Adapter1.StartDiscovery").Err; err != nil {
+		return err
+	}
+	defer callTimeout(c.adapter, dbusSlowTimeout, "org.bluez.Adapter1.StopDiscovery")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig, ok := <-signals:
+			if !ok {
+				return errBusLost
+			}
+			var props map[string]dbus.Variant
+			switch sig.Name {
+			case "org.freedesktop.DBus.ObjectManager.InterfacesAdded":
+				var path dbus.ObjectPath
+				var ifaces map[string]map[string]dbus.Variant
+				if dbus.Store(sig.Body, &path, &ifaces) != nil || !onAdapter(path, c.id) {
+					continue
+				}
+				props = ifaces["org.bluez.Device1"]
+			case "org.freedesktop.DBus.Properties.PropertiesChanged":
+				var changed map[string]dbus.Variant
+				if len(sig.Body) < 2 || sig.Body[0] != "org.bluez.Device1" || dbus.Store(sig.Body[1:2], &changed) != nil {
+					continue
+				}
+				// A new RSSI means a fresh inquiry result; fetch the rest.
+				if _, ok := changed["RSSI"]; !ok {
+					continue
+				}
+				props = c.deviceProperties(sig.Path)
+			}
+			c.report(props, found)
+		}
+	}
+}
+
+func (c *classicScanner) deviceProperties(path dbus.ObjectPath) map[string]dbus.Variant {
+	var props map[string]dbus.Variant
+	err := callTimeout(c.conn.Object("org.bluez", path), dbusTimeout,
+		"org.freedesktop.DBus.Properties.GetAll", "org.bluez.Device1").Store(&props)
+	if err != nil {
+		logDebug("failed to read properties of %s: %v", path, err)
+		return nil
+	}
+	return props
+}
+
+func (c *classicScanner) report(props map[string]dbus.Variant, found func(addr, name string, class uint32, rssi int16)) {
+	addr, _ := props["Address"].Value().(string)
+	class, hasClass := props["Class"].Value().(uint32)
+	rssi, hasRSSI := props["RSSI"].Value().(int16)
+	if addr == "" || !hasClass || !hasRSSI {
+		return
+	}
+	found(addr, bluezName(props), class, rssi)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// clockSkewWarn is how far the system clock may be off GPS time before a
+// warning is printed.
+const clockSkewWarn = 5 * time.Second
+
+// gpsTimeWait is how long startup waits for GPS time to name the outputs.
+const gpsTimeWait = 5 * time.Second
+
+// gpsTimeMaxAge is how long after the last GPS time report the clock keeps
+// using its offset before falling back to the system clock.
+const gpsTimeMaxAge = time.Minute
+
+// gpsClock corrects the system clock with the time in gpsd's reports. The
+// Pager's RTC drifts, and WiGLE rejects or misorders observations stamped
+// minutes off.
+type gpsClock struct {
+	mu     sync.Mutex
+	offset time.Duration // GPS time minus system time
+	synced time.Time     // system time of the last report
+	warned bool
+
+	ready     chan struct{} // closed on the first report
+	readyOnce sync.Once
+}
+
+func newGPSClock() *gpsClock {
+	return &gpsClock{ready: make(chan struct{})}
+}
+
+// Sync records the GPS time of a report that has just arrived.
+func (c *gpsClock) Sync(gpsTime time.Time) {
+	now := time.Now()
+	offset := gpsTime.Sub(now)
+
+	c.mu.Lock()
+	c.offset = offset
+	c.synced = now
+	warn := !c.warned && offset.Abs() > clockSkewWarn
+	if warn {
+		c.warned = true
+	}
+	c.mu.Unlock()
+
+	if warn {
+		logWarn("system clock is %s off GPS time; using GPS time", offset.Round(time.Second).Abs())
+	}
+	c.readyOnce.Do(func() { close(c.ready) })
+}
+
+// Now returns the current time in UTC, corrected by GPS time if there has
+// been a recent report, and whether it was.
+func (c *gpsClock) Now() (time.Time, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.synced.IsZero() || now.Sub(c.synced) > gpsTimeMaxAge {
+		return now.UTC(), false
+	}
+	return now.Add(c.offset).UTC(), true
+}
+
+// Wait blocks until the first GPS time report or until timeout has passed.
+func (c *gpsClock) Wait(ctx context.Context, timeout time.Duration) {
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+	case <-time.After(timeout):
+	}
+}
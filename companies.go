@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// companiesURL is the Bluetooth SIG's published company identifier list.
+const companiesURL = "https://bitbucket.org/bluetooth-SIG/public/raw/main/assigned_numbers/company_identifiers/company_identifiers.yaml"
+
+// defaultCompaniesPath is where update-companies saves the list. If present
+// it is used instead of the copy built into the binary.
+const defaultCompaniesPath = "/etc/wigle-bt/company_identifiers.yaml"
+
+// embeddedCompanies is the SIG list the binary is built with, gzipped like
+// embeddedOUI. To refresh it, run update-companies with -out pointing here
+// and gzip the result.
+//
+//go:embed company_identifiers.yaml.gz
+var embeddedCompanies []byte
+
+var (
+	companiesOnce sync.Once
+	companyNames  map[uint16]string
+)
+
+// companyName resolves a Bluetooth SIG company identifier, falling back to
+// the decimal number for unknown IDs.
+func companyName(id uint16) string {
+	companiesOnce.Do(loadCompanies)
+	if name, ok := companyNames[id]; ok {
+		return name
+	}
+	return strconv.Itoa(int(id))
+}
+
+// loadCompanies reads the on-disk list if update-companies has written one,
+// falling back to the embedded copy.
+func loadCompanies() {
+	if f, err := os.Open(defaultCompaniesPath); err == nil {
+		defer f.Close()
+		names, err := parseCompanies(f)
+		if err == nil {
+			companyNames = names
+			return
+		}
+		logWarn("ignoring %s: %v", defaultCompaniesPath, err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(embeddedCompanies))
+	if err == nil {
+		companyNames, err = parseCompanies(zr)
+	}
+	if err != nil {
+		logWarn("failed to load built-in company list: %v", err)
+	}
+}
+
+// parseCompanies reads the SIG's company_identifiers.yaml. The file is a
+// flat list of value/name pairs, so it is read line by line rather than
+// pulling in a YAML parser.
+func parseCompanies(r io.Reader) (map[uint16]string, error) {
+	names := make(map[uint16]string)
+	var value int64 = -1
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		line = strings.TrimPrefix(line, "- ")
+		key, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+
+		switch key {
+		case "value":
+			n, err := strconv.ParseUint(v, 0, 16)
+			if err != nil {
+				value = -1
+				continue
+			}
+			value = int64(n)
+		case "name":
+			if value < 0 {
+				continue
+			}
+			names[uint16(value)] = unquoteYAML(v)
+			value = -1
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no company identifiers found")
+	}
+	return names, nil
+}
+
+// unquoteYAML strips YAML single or double quotes from a scalar.
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	return s
+}
+
+// runUpdateCompanies implements the update-companies subcommand: it downloads
+// the SIG company list and installs it as the on-disk override.
+func runUpdateCompanies(args []string) int {
+	fs := flag.NewFlagSet("update-companies", flag.ContinueOnError)
+	url := fs.String("url", companiesURL, "company list to download")
+	out := fs.String("out", defaultCompaniesPath, "where to save it")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	n, err := downloadRegistry(*url, *out, func(r io.Reader) (int, error) {
+		names, err := parseCompanies(r)
+		return len(names), err
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "update-companies:", err)
+		return 1
+	}
+	fmt.Printf("Saved %d company identifiers to %s\n", n, *out)
+	return 0
+}
+
+// manufacturerIDs returns the distinct company IDs in an advertisement,
+// sorted. BlueZ already merges the advertisement with its scan response but
+// hands the entries over as an unordered map, so sorting keeps the "first" ID
+// stable from one sighting to the next.
+func manufacturerIDs(md []bluetooth.ManufacturerDataElement) []uint16 {
+	var ids []uint16
+	for _, m := range md {
+		if !slices.Contains(ids, m.CompanyID) {
+			ids = append(ids, m.CompanyID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
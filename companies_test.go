@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+// TestEmbeddedCompanies checks the list built into the binary, as
+// companyName falls back to it without an on-disk override.
+func TestEmbeddedCompanies(t *testing.T) {
+	zr, err := gzip.NewReader(bytes.NewReader(embeddedCompanies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := parseCompanies(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[uint16]string{
+		0x004C: "Apple, Inc.",
+		0x0075: "Samsung Electronics Co. Ltd.",
+	} {
+		if got := names[id]; got != want {
+			t.Errorf("company %#04x is %q, want %q", id, got, want)
+		}
+	}
+}
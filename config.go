@@ -0,0 +1,751 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+// envPrefix is prepended to a flag's upper-cased name to form the environment
+// variable that can also set it, e.g. --output-dir is WBT_OUTPUT_DIR.
+const envPrefix = "WBT_"
+
+// defaultConfigPath is read if it exists and --config isn't given.
+const defaultConfigPath = "/etc/wigle-bt.toml"
+
+// config holds every runtime setting. Command-line flags take precedence over
+// WBT_ environment variables, then the config file, then the defaults.
+type config struct {
+	ConfigFile string
+
+	OutputDir          string
+	FilenameTemplate   string
+	FilenameTimeFormat string
+	GPSD               string
+	GPSDRetries        int
+	GPSDBackoff        time.Duration
+	GPSDTimeout        time.Duration
+	FixedLocation      string
+	FixedAccuracy      float64
+	NMEA               string
+	Replay             string
+	ReplaySpeed        float64
+	GeofenceInclude    geoBoxes
+	GeofenceExclude    geoCircles
+	FixMaxAge          time.Duration
+	MaxAccuracy        float64
+	MinSatellites      int
+	MaxHDOP            float64
+	BackfillWindow     time.Duration
+	Interpolate        bool
+	OnlyMoving         bool
+	OnlyStationary     bool
+	MovingSpeed        float64
+	Adapter            string
+	AdapterWait        time.Duration
+	Verbose            bool
+	LogLevel           string
+	LogFormat          string
+	Quiet              bool
+	StatusInterval     time.Duration
+	TUI                bool
+	HTTP               string
+	HTTPToken          string
+	ExitOnPanic        bool
+	PIDFile            string
+	Force              bool
+	SeedFirstSeen      bool
+	DeviceMaxAge       time.Duration
+	DeviceRetention    time.Duration
+	MaxTracked         int
+	Duration           time.Duration
+	MaxDevices         int
+	MaxRows            uint64
+	ResolveNames       bool
+	ResolveWorkers     int
+	ResolveCooldown    time.Duration
+	ResolveInterval    time.Duration
+	MinRSSI            int
+	ScanMode           string
+	ScanInterval       float64
+	ScanWindow         float64
+	ScanTransport      string
+	ScanDuplicates     bool
+	ScanRSSI           int
+	ScanPathloss       int
+	ScanUUIDs          string
+	DedupInterval      time.Duration
+	DedupRSSI          int
+	RSSIAlpha          float64
+	DedupeOutput       string
+	IgnoreMACs         string
+	OnlyMACs           string
+	IncludeSelf        bool
+	PathLossExponent   float64
+	DefaultTxPower     int
+	Follow             string
+	FollowOnly         bool
+	Classic            bool
+	WiFi               string
+	WiFiInterval       time.Duration
+	ScanWatchdog       time.Duration
+	DutyCycle          string
+	WatchdogPower      bool
+	SkipRandom         bool
+	DropEN             bool
+	OUITag             bool
+
+	Compress       bool
+	CoordPrecision int
+	RotateSize     byteSize
+	RotateInterval time.Duration
+	MinFreeSpace   byteSize
+	LowSpaceAction string
+
+	KML              bool
+	GeoJSON          bool
+	GPX              bool
+	SQLite           string
+	Track            string
+	TrackMinDistance float64
+	JSONL            string
+	RawLog           string
+	Fresh            bool
+	DryRun           bool
+	Privacy          bool
+	PrivacyDecimals  int
+
+	MQTTBroker string
+	MQTTTopic  string
+	MQTTUser   string
+	MQTTPass   string
+
+	PostURL   string
+	PostAuth  string
+	PostSpool string
+
+	WigleUpload   bool
+	WigleAPIName  string
+	WigleAPIToken string
+}
+
+// parseConfig parses the command line, WBT_ environment variables and the
+// config file and validates the result.
+func parseConfig(args []string) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("wiglebluetooth", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.ConfigFile, "config", defaultConfigPath, "TOML config file; keys are flag names, optionally grouped in tables ([mqtt] broker = ...)")
+	fs.StringVar(&cfg.OutputDir, "output-dir", "/root/loot/wigle-bluetooth", "directory captures are written to")
+	fs.StringVar(&cfg.FilenameTemplate, "filename-template", defaultFilenameTemplate,
+		"capture file name without extension; supports {hostname}, {date}, {time} and {adapter}")
+	fs.StringVar(&cfg.FilenameTimeFormat, "filename-time-format", defaultFilenameTimeFormat,
+		"Go time layout of {time} in --filename-template; layouts with a zone (-0700, MST) use local time, others UTC")
+	fs.StringVar(&cfg.GPSD, "gpsd", "localhost:2947", "gpsd address as host:port; may be on another machine")
+	fs.IntVar(&cfg.GPSDRetries, "gpsd-retries", 0, "give up after this many failed attempts to reach gpsd in a row (0 retries forever)")
+	fs.DurationVar(&cfg.GPSDBackoff, "gpsd-backoff", 5*time.Second, "wait this long between attempts to reach gpsd")
+	fs.DurationVar(&cfg.GPSDTimeout, "gpsd-timeout", 30*time.Second,
+		"reconnect to gpsd when it sends no position reports for this long (0 disables)")
+	fs.StringVar(&cfg.FixedLocation, "fixed-location", "",
+		"for fixed installations: log every sighting at \"lat,lon[,alt]\" and don't use gpsd at all")
+	fs.Float64Var(&cfg.FixedAccuracy, "fixed-accuracy", 10, "accuracy in metres recorded with --fixed-location")
+	fs.Var(&cfg.GeofenceInclude, "geofence-include",
+		"only log sightings inside the box \"lat1,lon1,lat2,lon2\"; repeat for several boxes")
+	fs.Var(&cfg.GeofenceExclude, "geofence-exclude-radius",
+		"never log sightings within \"lat,lon,metres\" of a point; repeat for several places")
+	fs.StringVar(&cfg.NMEA, "nmea", "",
+		"read NMEA from a serial GPS as \"device[@baud]\" (e.g. /dev/ttyACM0@9600) instead of using gpsd")
+	fs.StringVar(&cfg.Replay, "replay", "",
+		"replay sightings and their positions from a --jsonl capture instead of scanning and using the GPS, e.g. to test on a laptop")
+	fs.Float64Var(&cfg.ReplaySpeed, "replay-speed", 1,
+		"replay at this multiple of the recorded pace (0 replays as fast as possible)")
+	fs.DurationVar(&cfg.FixMaxAge, "fix-max-age", 30*time.Second,
+		"treat the GPS fix as lost once it is older than this, rather than reusing old coordinates (0 disables)")
+	fs.Float64Var(&cfg.MaxAccuracy, "max-accuracy", 0,
+		"only log while the GPS horizontal error is known and at most this many metres, e.g. 25 (0 disables)")
+	fs.IntVar(&cfg.MinSatellites, "min-satellites", 4, "warn when fewer satellites than this are used for the fix (0 disables)")
+	fs.Float64Var(&cfg.MaxHDOP, "max-hdop", 5, "warn when the horizontal dilution of precision rises above this (0 disables)")
+	fs.BoolVar(&cfg.Interpolate, "interpolate", true,
+		"estimate each sighting's position from the GPS fixes before and after it, holding rows made while moving until the next fix")
+	fs.BoolVar(&cfg.OnlyMoving, "only-moving", false, "only log while moving at --moving-speed or faster")
+	fs.BoolVar(&cfg.OnlyStationary, "only-stationary", false, "only log while slower than --moving-speed")
+	fs.Float64Var(&cfg.MovingSpeed, "moving-speed", 2, "GPS speed in m/s that counts as moving for --only-moving and --only-stationary")
+	fs.DurationVar(&cfg.BackfillWindow, "backfill-window", 0,
+		"hold sightings made without a fix and write those up to this old with the next fix's position, e.g. 60s (0 drops them)")
+	fs.StringVar(&cfg.Adapter, "adapter", "hci0",
+		"Bluetooth controller to scan with, e.g. hci1 for a USB dongle; a comma-separated list scans on each of them")
+	fs.DurationVar(&cfg.AdapterWait, "adapter-wait", 30*time.Second,
+		"keep trying to unblock and power on the adapter for this long at startup")
+	fs.BoolVar(&cfg.Verbose, "verbose", false, "print every GPS update and skipped sighting; short for --log-level debug")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "lowest level of log message printed: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "log as plain text or as JSON objects, one per line: text or json")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "don't print every device found; the status line still shows progress")
+	fs.DurationVar(&cfg.StatusInterval, "status-interval", 30*time.Second,
+		"print a status line with totals, the discovery rate and GPS accuracy this often (0 disables)")
+	fs.BoolVar(&cfg.TUI, "tui", false,
+		"show a live device table instead of printing every sighting; needs an interactive terminal")
+	fs.StringVar(&cfg.HTTP, "http", "",
+		"serve a live map of sightings on this address, e.g. :8080, plus /stream, /status and /devices for other programs")
+	fs.StringVar(&cfg.HTTPToken, "http-token", "",
+		"bearer token that allows pausing scanning from the web page; without it the page is read-only")
+	fs.BoolVar(&cfg.SeedFirstSeen, "seed-first-seen", false,
+		"take FirstSeen of devices BlueZ remembers from earlier sessions from its storage rather than from this session")
+	fs.DurationVar(&cfg.DeviceRetention, "device-retention", 24*time.Hour,
+		"forget devices unseen for this long, so memory stays bounded on long runs; one seen again gets a new FirstSeen (0 keeps them)")
+	fs.IntVar(&cfg.MaxTracked, "max-tracked-devices", 100000,
+		"forget the longest unseen devices beyond this many (0 means no limit)")
+	fs.DurationVar(&cfg.Duration, "duration", 0,
+		"stop after this long, e.g. 2h (0 runs until interrupted)")
+	fs.IntVar(&cfg.MaxDevices, "max-devices", 0,
+		"stop once this many unique devices have been logged (0 means no limit)")
+	fs.Uint64Var(&cfg.MaxRows, "max-rows", 0,
+		"stop once this many rows have been written (0 means no limit)")
+	fs.DurationVar(&cfg.DeviceMaxAge, "device-max-age", 10*time.Minute,
+		"remove unpaired devices from BlueZ once unseen for this long so its device list stays small (0 disables)")
+	fs.BoolVar(&cfg.ResolveNames, "resolve-names", false,
+		"connect to connectable BLE devices that advertise no name and read it, or their make and model, over GATT")
+	fs.IntVar(&cfg.ResolveWorkers, "resolve-concurrency", 1, "connections --resolve-names may have open at once")
+	fs.DurationVar(&cfg.ResolveCooldown, "resolve-cooldown", 30*time.Minute,
+		"wait this long before connecting to the same device again")
+	fs.DurationVar(&cfg.ResolveInterval, "resolve-interval", 5*time.Second,
+		"start at most one --resolve-names connection per this interval")
+	fs.BoolVar(&cfg.ExitOnPanic, "exit-on-panic", false,
+		"shut down cleanly after a panic in a scan or GPS callback instead of logging it and carrying on")
+	fs.StringVar(&cfg.PIDFile, "pidfile", "",
+		"write the process ID to this file while running, for init systems such as OpenWrt's procd")
+	fs.BoolVar(&cfg.Force, "force", false,
+		"run even if another copy is already writing to --output-dir")
+	fs.BoolVar(&cfg.Classic, "classic", false, "also discover classic (BR/EDR) devices, logged with Type BT")
+	fs.StringVar(&cfg.WiFi, "wifi", "",
+		"also scan for WiFi access points with iw on this interface, e.g. wlan1, logged with Type WIFI in the same files")
+	fs.DurationVar(&cfg.WiFiInterval, "wifi-interval", 30*time.Second, "time between WiFi scans")
+	fs.DurationVar(&cfg.ScanWatchdog, "scan-watchdog", 2*time.Minute,
+		"restart the scan when no results arrive for this long while there is a GPS fix (0 disables)")
+	fs.StringVar(&cfg.DutyCycle, "duty-cycle", "",
+		"scan/rest periods to save power, e.g. 30s/90s scans for 30s then rests for 90s; SIGUSR2 starts a scan period early")
+	fs.BoolVar(&cfg.WatchdogPower, "watchdog-power-cycle", false, "also power-cycle the adapter when the watchdog restarts the scan")
+	fs.BoolVar(&cfg.SkipRandom, "skip-random", false, "ignore BLE devices using private (RPA/NRPA) addresses that rotate")
+	fs.BoolVar(&cfg.DropEN, "drop-en", false, "count Exposure Notification beacons but leave them out of every output")
+	fs.BoolVar(&cfg.OUITag, "oui-tag", false, "append the OUI vendor of public addresses to the capabilities string")
+	fs.IntVar(&cfg.MinRSSI, "min-rssi", 0, "ignore sightings weaker than this RSSI in dBm, e.g. -85 (0 logs everything)")
+	fs.StringVar(&cfg.ScanMode, "scan-mode", "active",
+		"active requests scan responses, where many devices put their name, at the cost of more power and transmitting; "+
+			"passive only listens")
+	fs.Float64Var(&cfg.ScanInterval, "scan-interval", 0,
+		"LE scan interval in ms, 2.5 to 10240, set with --scan-window (0 keeps the kernel default; lasts until the controller resets)")
+	fs.Float64Var(&cfg.ScanWindow, "scan-window", 0,
+		"LE scan window in ms, how much of each --scan-interval the radio listens; a smaller share saves power but misses advertisements")
+	fs.StringVar(&cfg.ScanTransport, "scan-transport", "le",
+		"BlueZ discovery transport for the BLE scan: le, bredr or auto (--classic runs its own bredr discovery)")
+	fs.BoolVar(&cfg.ScanDuplicates, "scan-duplicates", true,
+		"have BlueZ report every advertisement rather than only changes; turning this off cuts wakeups but also rows")
+	fs.IntVar(&cfg.ScanRSSI, "scan-rssi", 0,
+		"have BlueZ drop devices weaker than this RSSI in dBm before they reach us (0 disables); unlike --min-rssi, "+
+			"this also hides them from --follow")
+	fs.IntVar(&cfg.ScanPathloss, "scan-pathloss", 0,
+		"have BlueZ drop devices whose advertised TX power minus RSSI exceeds this many dB (0 disables)")
+	fs.StringVar(&cfg.ScanUUIDs, "scan-uuids", "", "have BlueZ only report devices advertising one of these comma-separated service UUIDs")
+
+	fs.DurationVar(&cfg.DedupInterval, "dedup-interval", 0, "write at most one row per device per interval, e.g. 60s (0 writes every advertisement)")
+	fs.IntVar(&cfg.DedupRSSI, "dedup-rssi", 10, "within --dedup-interval, still write a row when RSSI improves by more than this many dB")
+	fs.Float64Var(&cfg.RSSIAlpha, "rssi-alpha", 0.3,
+		"weight of each new reading in the per-device smoothed RSSI used for dedup and --follow (1 disables smoothing)")
+	fs.StringVar(&cfg.DedupeOutput, "dedupe-output", "raw",
+		"raw writes every sighting, best writes one row per device at its strongest position at shutdown and CSV rotation, both writes both")
+	fs.StringVar(&cfg.IgnoreMACs, "ignore-macs", "",
+		"skip these MAC addresses or prefixes: a comma-separated list or a file with one per line (files are re-read on SIGHUP)")
+	fs.StringVar(&cfg.OnlyMACs, "only-macs", "", "log only these MAC addresses or prefixes, in the same form as --ignore-macs")
+	fs.BoolVar(&cfg.IncludeSelf, "include-self", false, "log the host's own adapters and paired or connected devices too")
+	fs.Float64Var(&cfg.PathLossExponent, "path-loss-exponent", 2.0, "path loss exponent for distance estimates (2 in free space, 3-4 indoors)")
+	fs.IntVar(&cfg.DefaultTxPower, "default-tx-power", -59,
+		"RSSI at 1 m assumed for distance estimates when a device doesn't advertise its TX power (0 leaves the distance empty)")
+	fs.StringVar(&cfg.Follow, "follow", "", "show a live signal line for this MAC address, for locating the device")
+	fs.BoolVar(&cfg.FollowOnly, "follow-only", false, "with --follow, log only the followed device")
+
+	fs.BoolVar(&cfg.Compress, "compress", false, "gzip the CSV (written as .csv.gz)")
+	fs.IntVar(&cfg.CoordPrecision, "coord-precision", wigle.DefaultPrecision.Coordinates,
+		"decimals of latitude and longitude in the CSV; fewer blur the positions, e.g. 3 is about 100 m")
+	fs.Var(&cfg.RotateSize, "rotate-size", "start a new CSV once the current one reaches this size, e.g. 5MB (0 disables)")
+	fs.DurationVar(&cfg.RotateInterval, "rotate-interval", 0, "start a new CSV on each interval boundary, e.g. 1h or 24h (0 disables)")
+	cfg.MinFreeSpace = 10 << 20
+	fs.Var(&cfg.MinFreeSpace, "min-free-space",
+		"act on --low-space-action when the output directory has less than this free, e.g. 10MB (0 disables)")
+	fs.StringVar(&cfg.LowSpaceAction, "low-space-action", lowSpaceStop,
+		"when space runs low: stop writing until it is freed, delete this session's oldest rotated CSVs, or compress the CSV from then on; delete and compress stop once they can't help")
+
+	fs.BoolVar(&cfg.KML, "kml", false, "also write a KML file of sightings next to the CSV")
+	fs.BoolVar(&cfg.GeoJSON, "geojson", false, "also write a GeoJSON file of unique devices next to the CSV")
+	fs.BoolVar(&cfg.GPX, "gpx", false, "also write a GPX file of the drive track and device waypoints next to the CSV")
+	fs.StringVar(&cfg.Track, "track", "", "also log the route driven to a \"gpx\" or \"csv\" track file next to the CSV")
+	fs.Float64Var(&cfg.TrackMinDistance, "track-min-distance", 5, "leave out track points closer than this many metres to the previous one")
+	fs.StringVar(&cfg.SQLite, "sqlite", "", "also write sightings to the SQLite database at this path")
+	fs.StringVar(&cfg.JSONL, "jsonl", "", "also write sightings as JSON Lines to this path")
+	fs.StringVar(&cfg.RawLog, "raw-log", "", "also write each sighting's full advertisement as hex to this path")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false,
+		"scan and print sightings as usual but create no files at all; --log-level debug shows the rows that would have been written")
+	fs.BoolVar(&cfg.Fresh, "fresh", false,
+		"don't load the devices saved by the previous run, so every device gets a new FirstSeen")
+	fs.BoolVar(&cfg.Privacy, "privacy", false,
+		"anonymise every output for sharing: hash MACs with a per-session salt, replace names by device type and truncate coordinates")
+	fs.IntVar(&cfg.PrivacyDecimals, "privacy-decimals", 3, "decimal places coordinates are truncated to with --privacy (3 is about 100 m)")
+
+	fs.StringVar(&cfg.MQTTBroker, "mqtt-broker", "", "publish sightings to this MQTT broker (host:port or URL)")
+	fs.StringVar(&cfg.MQTTTopic, "mqtt-topic", "wigle-bluetooth/sightings", "MQTT topic to publish sightings on")
+	fs.StringVar(&cfg.MQTTUser, "mqtt-user", "", "MQTT username")
+	fs.StringVar(&cfg.MQTTPass, "mqtt-pass", "", "MQTT password")
+
+	fs.StringVar(&cfg.PostURL, "post-url", "", "POST batches of sightings as JSON to this URL")
+	fs.StringVar(&cfg.PostAuth, "post-auth", "", "Authorization header value sent with each POST")
+	fs.StringVar(&cfg.PostSpool, "post-spool", "", "directory for batches that could not be POSTed yet (default <output-dir>/spool)")
+
+	fs.BoolVar(&cfg.WigleUpload, "wigle-upload", false, "upload finished captures to WiGLE, retrying failed uploads on the next run")
+	fs.StringVar(&cfg.WigleAPIName, "wigle-api-name", "", "WiGLE API name (default $WIGLE_API_NAME)")
+	fs.StringVar(&cfg.WigleAPIToken, "wigle-api-token", "", "WiGLE API token (default $WIGLE_API_TOKEN)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n       %[1]s update-oui [-url URL] [-out PATH]\n       %[1]s update-companies [-url URL] [-out PATH]\n       %[1]s validate FILE...\n       %[1]s merge -out FILE INPUT...\n       %[1]s convert -to kml|gpx|geojson IN.csv OUT\n       %[1]s stats [-json] FILE...\n\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set with a %s environment variable, e.g. %s.\n",
+			envPrefix, envName("output-dir"))
+		fmt.Fprintf(fs.Output(), "Settings can also be given in the TOML file named by --config (default %s).\n",
+			defaultConfigPath)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	if err := applyEnv(fs, given); err != nil {
+		return nil, err
+	}
+	if err := applyConfigFile(fs, given, cfg.ConfigFile, given["config"]); err != nil {
+		return nil, err
+	}
+
+	if cfg.WigleAPIName == "" {
+		cfg.WigleAPIName = os.Getenv("WIGLE_API_NAME")
+	}
+	if cfg.WigleAPIToken == "" {
+		cfg.WigleAPIToken = os.Getenv("WIGLE_API_TOKEN")
+	}
+	if cfg.PostSpool == "" {
+		cfg.PostSpool = filepath.Join(cfg.OutputDir, "spool")
+	}
+
+	return cfg, cfg.validate()
+}
+
+// envName returns the environment variable for a flag name.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag not already given from its WBT_ environment
+// variable, if present, and marks it as given.
+func applyEnv(fs *flag.FlagSet, given map[string]bool) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+			given[f.Name] = true
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// applyConfigFile sets every flag not already given from the TOML file at
+// path. A missing file is only an error if it was asked for explicitly.
+// Unknown keys are reported but otherwise ignored.
+func applyConfigFile(fs *flag.FlagSet, given map[string]bool, path string, explicit bool) error {
+	var doc map[string]any
+	_, err := toml.DecodeFile(path, &doc)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	values := make(map[string]any)
+	flattenConfig("", doc, values)
+
+	var unknown, errs []string
+	for key, value := range values {
+		if key == "config" || fs.Lookup(key) == nil {
+			unknown = append(unknown, key)
+			continue
+		}
+		if given[key] {
+			continue
+		}
+		// Arrays set repeatable flags once per element.
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if err := fs.Set(key, fmt.Sprint(item)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			}
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		fmt.Fprintf(os.Stderr, "warning: unknown keys in %s: %s\n", path, strings.Join(unknown, ", "))
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("config file %s: %s", path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// flattenConfig turns nested TOML tables into flag names, so [mqtt] broker
+// becomes mqtt-broker.
+func flattenConfig(prefix string, table map[string]any, out map[string]any) {
+	for key, value := range table {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+		if sub, ok := value.(map[string]any); ok {
+			flattenConfig(key, sub, out)
+			continue
+		}
+		out[key] = value
+	}
+}
+
+// validate rejects invalid settings and combinations before any hardware is
+// touched.
+func (c *config) validate() error {
+	var errs []error
+	if c.OutputDir == "" {
+		errs = append(errs, errors.New("--output-dir must not be empty"))
+	}
+	if c.FilenameTemplate == "" {
+		errs = append(errs, errors.New("--filename-template must not be empty"))
+	}
+	if sample := time.Now().Format(c.FilenameTimeFormat); sample == "" || strings.Contains(sample, "/") {
+		errs = append(errs, fmt.Errorf("--filename-time-format %q must give a non-empty time without a /", c.FilenameTimeFormat))
+	}
+	for _, id := range strings.Split(c.Adapter, ",") {
+		if id == "" || strings.ContainsAny(id, "/ ") {
+			errs = append(errs, fmt.Errorf("--adapter %q is not a controller name like hci0", id))
+		}
+	}
+	if c.GPSDRetries < 0 {
+		errs = append(errs, errors.New("--gpsd-retries must not be negative"))
+	}
+	if c.GPSDBackoff <= 0 {
+		errs = append(errs, errors.New("--gpsd-backoff must be positive"))
+	}
+	if c.AdapterWait < 0 {
+		errs = append(errs, errors.New("--adapter-wait must not be negative"))
+	}
+	if err := validGPSDAddress(c.GPSD); err != nil {
+		errs = append(errs, fmt.Errorf("--gpsd %q is not a host:port address: %v", c.GPSD, err))
+	}
+	if c.GPSDTimeout < 0 {
+		errs = append(errs, errors.New("--gpsd-timeout must not be negative"))
+	}
+	if c.FixedLocation != "" {
+		if _, err := parseFixedLocation(c.FixedLocation); err != nil {
+			errs = append(errs, fmt.Errorf("--fixed-location: %v", err))
+		}
+		if c.OnlyMoving {
+			errs = append(errs, errors.New("--only-moving never logs anything with --fixed-location"))
+		}
+	}
+	if c.NMEA != "" {
+		if _, _, err := parseNMEASource(c.NMEA); err != nil {
+			errs = append(errs, fmt.Errorf("--nmea %q: %v", c.NMEA, err))
+		}
+		if c.FixedLocation != "" {
+			errs = append(errs, errors.New("--nmea and --fixed-location can't be used together"))
+		}
+	}
+	if c.Replay != "" {
+		// Nothing live runs alongside a replay.
+		for _, live := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--nmea", c.NMEA != ""},
+			{"--fixed-location", c.FixedLocation != ""},
+			{"--classic", c.Classic},
+			{"--wifi", c.WiFi != ""},
+			{"--resolve-names", c.ResolveNames},
+		} {
+			if live.set {
+				errs = append(errs, fmt.Errorf("--replay and %s can't be used together", live.flag))
+			}
+		}
+	}
+	if c.ReplaySpeed < 0 {
+		errs = append(errs, errors.New("--replay-speed must not be negative"))
+	}
+	if c.FixedAccuracy < 0 {
+		errs = append(errs, errors.New("--fixed-accuracy must not be negative"))
+	}
+	if c.FixMaxAge < 0 {
+		errs = append(errs, errors.New("--fix-max-age must not be negative"))
+	}
+	if c.OnlyMoving && c.OnlyStationary {
+		errs = append(errs, errors.New("--only-moving and --only-stationary can't be combined"))
+	}
+	if c.MovingSpeed < 0 {
+		errs = append(errs, errors.New("--moving-speed must not be negative"))
+	}
+	if c.BackfillWindow < 0 {
+		errs = append(errs, errors.New("--backfill-window must not be negative"))
+	}
+	if c.MinSatellites < 0 {
+		errs = append(errs, errors.New("--min-satellites must not be negative"))
+	}
+	if c.MaxHDOP < 0 {
+		errs = append(errs, errors.New("--max-hdop must not be negative"))
+	}
+	if c.Track != "" && c.Track != "gpx" && c.Track != "csv" {
+		errs = append(errs, fmt.Errorf("--track must be gpx or csv, not %q", c.Track))
+	}
+	if c.TrackMinDistance < 0 {
+		errs = append(errs, errors.New("--track-min-distance must not be negative"))
+	}
+	if c.MaxAccuracy < 0 {
+		errs = append(errs, errors.New("--max-accuracy must not be negative"))
+	}
+	if c.MinRSSI > 0 || c.MinRSSI < -127 {
+		errs = append(errs, fmt.Errorf("--min-rssi %d is outside -127..0 dBm", c.MinRSSI))
+	}
+	switch c.ScanMode {
+	case "active":
+	case "passive":
+		if c.ScanRSSI != 0 || c.ScanPathloss != 0 || c.ScanUUIDs != "" || c.ScanTransport != "le" {
+			errs = append(errs, errors.New("--scan-mode passive takes no discovery filter (--scan-transport, --scan-rssi, "+
+				"--scan-pathloss, --scan-uuids)"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("--scan-mode must be active or passive, not %q", c.ScanMode))
+	}
+	if (c.ScanInterval == 0) != (c.ScanWindow == 0) {
+		errs = append(errs, errors.New("--scan-interval and --scan-window must be set together"))
+	} else if c.ScanInterval != 0 {
+		if c.ScanInterval < 2.5 || c.ScanInterval > 10240 {
+			errs = append(errs, fmt.Errorf("--scan-interval %g is outside 2.5..10240 ms", c.ScanInterval))
+		}
+		if c.ScanWindow < 2.5 || c.ScanWindow > c.ScanInterval {
+			errs = append(errs, fmt.Errorf("--scan-window %g must be from 2.5 ms up to --scan-interval", c.ScanWindow))
+		}
+	}
+	switch c.ScanTransport {
+	case "le", "bredr", "auto":
+	default:
+		errs = append(errs, fmt.Errorf("--scan-transport must be le, bredr or auto, not %q", c.ScanTransport))
+	}
+	if c.ScanRSSI > 0 || c.ScanRSSI < -127 {
+		errs = append(errs, fmt.Errorf("--scan-rssi %d is outside -127..0 dBm", c.ScanRSSI))
+	}
+	if c.ScanPathloss < 0 || c.ScanPathloss > 137 {
+		errs = append(errs, fmt.Errorf("--scan-pathloss %d is outside 0..137 dB", c.ScanPathloss))
+	}
+	if c.ScanRSSI != 0 && c.ScanPathloss != 0 {
+		errs = append(errs, errors.New("BlueZ accepts --scan-rssi or --scan-pathloss, not both"))
+	}
+	if _, err := c.DiscoveryFilter(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.DedupInterval < 0 {
+		errs = append(errs, errors.New("--dedup-interval must not be negative"))
+	}
+	if c.DedupRSSI < 0 {
+		errs = append(errs, errors.New("--dedup-rssi must not be negative"))
+	}
+	if c.PathLossExponent <= 0 {
+		errs = append(errs, errors.New("--path-loss-exponent must be positive"))
+	}
+	if c.FollowOnly && c.Follow == "" {
+		errs = append(errs, errors.New("--follow-only requires --follow"))
+	}
+	if c.RSSIAlpha <= 0 || c.RSSIAlpha > 1 {
+		errs = append(errs, errors.New("--rssi-alpha must be in (0, 1]"))
+	}
+	switch c.DedupeOutput {
+	case "raw", "best", "both":
+	default:
+		errs = append(errs, fmt.Errorf("--dedupe-output must be raw, best or both, not %q", c.DedupeOutput))
+	}
+	if c.ResolveWorkers < 1 {
+		errs = append(errs, errors.New("--resolve-concurrency must be at least 1"))
+	}
+	if c.ResolveCooldown < 0 {
+		errs = append(errs, errors.New("--resolve-cooldown must not be negative"))
+	}
+	if c.ResolveInterval <= 0 {
+		errs = append(errs, errors.New("--resolve-interval must be positive"))
+	}
+	if c.DeviceRetention < 0 {
+		errs = append(errs, errors.New("--device-retention must not be negative"))
+	}
+	if c.MaxTracked < 0 {
+		errs = append(errs, errors.New("--max-tracked-devices must not be negative"))
+	}
+	if c.Duration < 0 {
+		errs = append(errs, errors.New("--duration must not be negative"))
+	}
+	if c.MaxDevices < 0 {
+		errs = append(errs, errors.New("--max-devices must not be negative"))
+	}
+	if c.DeviceMaxAge < 0 {
+		errs = append(errs, errors.New("--device-max-age must not be negative"))
+	}
+	if c.WiFi != "" && c.WiFiInterval <= 0 {
+		errs = append(errs, errors.New("--wifi-interval must be positive"))
+	}
+	if c.HTTPToken != "" && c.HTTP == "" {
+		errs = append(errs, errors.New("--http-token needs --http"))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("--log-format must be text or json, not %q", c.LogFormat))
+	}
+	if c.Quiet && c.Verbose {
+		errs = append(errs, errors.New("--quiet and --verbose contradict each other"))
+	}
+	if c.StatusInterval < 0 {
+		errs = append(errs, errors.New("--status-interval must not be negative"))
+	}
+	if c.TUI && c.Follow != "" {
+		errs = append(errs, errors.New("--tui and --follow both need the whole terminal"))
+	}
+	if c.ScanWatchdog < 0 {
+		errs = append(errs, errors.New("--scan-watchdog must not be negative"))
+	}
+	if _, err := parseDutyCycle(c.DutyCycle); err != nil {
+		errs = append(errs, err)
+	}
+	if err := parseLowSpaceAction(c.LowSpaceAction); err != nil {
+		errs = append(errs, err)
+	}
+	if c.CoordPrecision < 0 || c.CoordPrecision > wigle.DefaultPrecision.Coordinates {
+		errs = append(errs, fmt.Errorf("--coord-precision must be between 0 and %d", wigle.DefaultPrecision.Coordinates))
+	}
+	if c.RotateInterval < 0 {
+		errs = append(errs, errors.New("--rotate-interval must not be negative"))
+	}
+	if c.MQTTBroker != "" && c.MQTTTopic == "" {
+		errs = append(errs, errors.New("--mqtt-broker requires --mqtt-topic"))
+	}
+	if c.WigleUpload && (c.WigleAPIName == "" || c.WigleAPIToken == "") {
+		errs = append(errs, errors.New("--wigle-upload requires --wigle-api-name and --wigle-api-token"))
+	}
+	if c.PrivacyDecimals < 0 || c.PrivacyDecimals > 8 {
+		errs = append(errs, errors.New("--privacy-decimals must be between 0 and 8"))
+	}
+	if c.Privacy && c.WigleUpload {
+		errs = append(errs, errors.New("--privacy captures can't be uploaded to WiGLE; their MACs are made up"))
+	}
+	if c.Privacy && c.RawLog != "" {
+		errs = append(errs, errors.New("--raw-log can't be anonymised and doesn't work with --privacy"))
+	}
+	return errors.Join(errs...)
+}
+
+// DiscoveryFilter returns the BlueZ discovery filter for the BLE scan.
+func (c *config) DiscoveryFilter() (map[string]dbus.Variant, error) {
+	filter := map[string]dbus.Variant{
+		"Transport":     dbus.MakeVariant(c.ScanTransport),
+		"DuplicateData": dbus.MakeVariant(c.ScanDuplicates),
+	}
+	if c.ScanRSSI != 0 {
+		filter["RSSI"] = dbus.MakeVariant(int16(c.ScanRSSI))
+	}
+	if c.ScanPathloss != 0 {
+		filter["Pathloss"] = dbus.MakeVariant(uint16(c.ScanPathloss))
+	}
+	if c.ScanUUIDs != "" {
+		var uuids []string
+		for _, u := range strings.Split(c.ScanUUIDs, ",") {
+			uuid, err := bluetooth.ParseUUID(strings.TrimSpace(u))
+			if err != nil {
+				return nil, fmt.Errorf("--scan-uuids: %q is not a UUID", u)
+			}
+			uuids = append(uuids, uuid.String())
+		}
+		filter["UUIDs"] = dbus.MakeVariant(uuids)
+	}
+	return filter, nil
+}
+
+// Precision returns how precisely the CSV writes positions.
+func (c *config) Precision() wigle.Precision {
+	p := wigle.DefaultPrecision
+	p.Coordinates = c.CoordPrecision
+	return p
+}
+
+// Adapters returns the controllers named by --adapter, without duplicates.
+func (c *config) Adapters() []string {
+	var ids []string
+	for _, id := range strings.Split(c.Adapter, ",") {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// FixedPosition returns the --fixed-location fix, if one was given.
+func (c *config) FixedPosition() (LocationData, bool) {
+	if c.FixedLocation == "" {
+		return LocationData{}, false
+	}
+	loc, err := parseFixedLocation(c.FixedLocation)
+	if err != nil {
+		return LocationData{}, false
+	}
+	loc.Error = c.FixedAccuracy
+	return loc, true
+}
+
+// parseFixedLocation parses "lat,lon" or "lat,lon,alt".
+func parseFixedLocation(s string) (LocationData, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 && len(parts) != 3 {
+		return LocationData{}, fmt.Errorf("%q is not lat,lon[,alt]", s)
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return LocationData{}, fmt.Errorf("%q is not lat,lon[,alt]", s)
+		}
+		v[i] = f
+	}
+	if v[0] < -90 || v[0] > 90 || v[1] < -180 || v[1] > 180 {
+		return LocationData{}, fmt.Errorf("%q is outside the valid latitude and longitude range", s)
+	}
+	return LocationData{Fix: true, Latitude: v[0], Longitude: v[1], Altitude: v[2]}, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// converters open the sink a capture is converted into, by --to format.
+var converters = map[string]func(path string) (Sink, error){
+	"kml":     func(path string) (Sink, error) { return newKMLWriter(path) },
+	"gpx":     func(path string) (Sink, error) { return newGPXWriter(path) },
+	"geojson": func(path string) (Sink, error) { return newGeoJSONWriter(path) },
+}
+
+// sightingFromRecord rebuilds a sighting from a WiGLE CSV row. For
+// Bluetooth the Frequency column carries the device class.
+func sightingFromRecord(r wigle.Record) Sighting {
+	s := Sighting{
+		Address:      r.MAC,
+		Name:         sanitizeName(r.SSID),
+		Capabilities: r.AuthMode,
+		RSSI:         int16(r.RSSI),
+		MfgrID:       r.MfgrID,
+		Type:         r.Type,
+		FirstSeen:    r.FirstSeen,
+		Timestamp:    r.FirstSeen,
+		Location: LocationData{
+			Fix:       true,
+			Latitude:  r.Latitude,
+			Longitude: r.Longitude,
+			Altitude:  r.Altitude,
+			Error:     r.Accuracy,
+		},
+	}
+	if r.Type == wigle.TypeWiFi {
+		s.Channel, s.Frequency = r.Channel, r.Frequency
+	} else {
+		s.Class = uint32(r.Frequency)
+	}
+	return s
+}
+
+// convertCapture writes every row of a WiGLE CSV file to sink. Rows that
+// can't be read are reported and skipped.
+func convertCapture(path string, sink Sink) (rows, skipped int, err error) {
+	f, err := openCapture(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := wigle.NewReader(f)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return rows, skipped, nil
+		}
+		var re *wigle.RowError
+		if errors.As(err, &re) {
+			fmt.Fprintf(os.Stderr, "convert: %s:%d: skipped, %v\n", path, re.Line, re.Err)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return rows, skipped, err
+		}
+		if err := sink.Write(sightingFromRecord(rec)); err != nil {
+			return rows, skipped, err
+		}
+		rows++
+	}
+}
+
+// runConvert implements the convert subcommand: it turns a WiGLE CSV into
+// the same KML, GPX or GeoJSON the --kml, --gpx and --geojson sinks write.
+func runConvert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	to := fs.String("to", "", "format to write: kml, gpx or geojson")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s convert -to kml|gpx|geojson IN.csv OUT\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	open, ok := converters[*to]
+	if !ok || fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+
+	sink, err := open(out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "convert:", err)
+		return 1
+	}
+	rows, skipped, err := convertCapture(in, sink)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %s: %v\n", in, err)
+		os.Remove(out)
+		return 1
+	}
+	fmt.Printf("Converted %d rows to %s", rows, out)
+	if skipped > 0 {
+		fmt.Printf(", %d rows skipped", skipped)
+	}
+	fmt.Println()
+	return 0
+}
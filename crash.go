@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// crashGuard keeps a panic in a callback from taking the process, and
+// everything still buffered in the outputs, down with it. Each panic is
+// logged with its stack to a crash file, or to the log without one. With
+// exit set, Crashed is closed after the first one so main can shut down
+// through the normal path, which flushes and closes every sink. Without
+// it, scanning carries on and the sinks are flushed straight away, through
+// the hook set with FlushWith.
+type crashGuard struct {
+	path string // "" logs panics without writing a crash file
+	exit bool
+
+	count   atomic.Uint64
+	once    sync.Once
+	crashed chan struct{}
+	mu      sync.Mutex // serialises writes to the crash file, guards flush
+	flush   func() error
+}
+
+func newCrashGuard(path string, exit bool) *crashGuard {
+	return &crashGuard{path: path, exit: exit, crashed: make(chan struct{})}
+}
+
+// FlushWith sets what flushes the outputs after a panic. The sinks are set
+// up after the first callbacks, so it may be called while they run.
+func (g *crashGuard) FlushWith(flush func() error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.flush = flush
+}
+
+// Recover must be deferred directly by the function it protects.
+func (g *crashGuard) Recover(where string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	g.count.Add(1)
+	stack := debug.Stack()
+
+	if g.path == "" {
+		logError("panic in %s: %v\n%s", where, r, stack)
+	} else {
+		g.mu.Lock()
+		err := g.write(where, r, stack)
+		g.mu.Unlock()
+		if err != nil {
+			logError("panic in %s: %v (failed to write crash file: %v)\n%s", where, r, err, stack)
+		} else {
+			logError("panic in %s: %v (details in %s)", where, r, g.path)
+		}
+	}
+
+	if g.exit {
+		g.once.Do(func() { close(g.crashed) })
+		return
+	}
+	// The process carries on, but may not for long: get what is buffered
+	// onto disk while it can.
+	g.mu.Lock()
+	flush := g.flush
+	g.mu.Unlock()
+	if flush != nil {
+		if err := flush(); err != nil {
+			logWarn("failed to flush the outputs after a panic: %v", err)
+		}
+	}
+}
+
+func (g *crashGuard) write(where string, r any, stack []byte) error {
+	f, err := os.OpenFile(g.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s panic in %s: %v\n%s\n", time.Now().Format(time.RFC3339), where, r, stack)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Crashed is closed after a panic when the guard is set to exit.
+func (g *crashGuard) Crashed() <-chan struct{} {
+	return g.crashed
+}
+
+// Count returns the number of panics recovered.
+func (g *crashGuard) Count() uint64 {
+	return g.count.Load()
+}
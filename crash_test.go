@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// panicIn calls fn under g, as a callback would run.
+func panicIn(g *crashGuard, fn func()) {
+	defer g.Recover("test callback")
+	fn()
+}
+
+func TestCrashGuardContinue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crash.log")
+	g := newCrashGuard(path, false)
+	var flushes int
+	g.FlushWith(func() error {
+		flushes++
+		return nil
+	})
+
+	panicIn(g, func() {})
+	if flushes != 0 || g.Count() != 0 {
+		t.Fatalf("%d flushes and %d panics without one", flushes, g.Count())
+	}
+	panicIn(g, func() { panic("boom") })
+	panicIn(g, func() { panic(errors.New("bang")) })
+	if g.Count() != 2 {
+		t.Errorf("Count = %d, want 2", g.Count())
+	}
+	if flushes != 2 {
+		t.Errorf("flushed %d times after 2 panics", flushes)
+	}
+	select {
+	case <-g.Crashed():
+		t.Error("Crashed closed without exit set")
+	default:
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "panic in test callback: boom") || !strings.Contains(string(data), "bang") {
+		t.Errorf("crash file holds\n%s", data)
+	}
+}
+
+func TestCrashGuardExit(t *testing.T) {
+	g := newCrashGuard("", true)
+	var flushes int
+	g.FlushWith(func() error {
+		flushes++
+		return nil
+	})
+	panicIn(g, func() { panic("boom") })
+	panicIn(g, func() { panic("again") })
+	select {
+	case <-g.Crashed():
+	default:
+		t.Error("Crashed not closed after a panic")
+	}
+	// main flushes and closes the sinks on the way out.
+	if flushes != 0 {
+		t.Errorf("flushed %d times with exit set", flushes)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// csvFlushInterval is how often buffered rows are flushed to disk. Flushing
+// per row made discovery stutter on slow flash during bursts.
+const csvFlushInterval = 2 * time.Second
+
+// A full filesystem or a pulled SD card makes every flush fail. After
+// csvMaxFailures failed flushes in a row the file is abandoned for a new one,
+// and after csvMaxReopens new files have failed too the CSV gives up.
+const (
+	csvMaxFailures = 3
+	csvMaxReopens  = 3
+)
+
+// defaultFilenameTemplate reproduces the original capture naming.
+const defaultFilenameTemplate = "wigle-bluetooth-{date}T{time}"
+
+// defaultFilenameTimeFormat is the layout of the {time} token: UTC to the
+// second. Files named before it was introduced end in a fractional second
+// and offset, e.g. T104320.632260971+0000; nothing parses the names, so
+// both kinds are picked up alike.
+const defaultFilenameTimeFormat = "150405Z"
+
+// layoutHasZone reports whether a time layout writes the zone, by checking
+// whether the same wall clock time formats differently in two zones.
+func layoutHasZone(layout string) bool {
+	a := time.Date(2006, 1, 2, 15, 4, 5, 0, time.FixedZone("A", 3600))
+	b := time.Date(2006, 1, 2, 15, 4, 5, 0, time.FixedZone("B", 7200))
+	return a.Format(layout) != b.Format(layout)
+}
+
+// expandFilenameTemplate substitutes the {hostname}, {date}, {time} and
+// {adapter} tokens in a capture file name template. {time} is formatted
+// with timeFormat, in local time if the layout writes the zone and in UTC
+// otherwise, so the name never claims an offset it doesn't have.
+func expandFilenameTemplate(template, timeFormat string, t time.Time, adapter string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	if layoutHasZone(timeFormat) {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+	return strings.NewReplacer(
+		"{hostname}", hostname,
+		"{date}", t.Format("2006-01-02"),
+		"{time}", t.Format(timeFormat),
+		"{adapter}", adapter,
+	).Replace(template)
+}
+
+// wigleCSV writes rows to a WiGLE CSV file, optionally gzipped, and rotates to
+// a new numbered file once the current one grows past maxSize or crosses an
+// interval boundary.
+type wigleCSV struct {
+	mu        sync.Mutex
+	base      string // path without extension
+	compress  bool
+	precision wigle.Precision
+	maxSize   int64         // 0 disables size rotation
+	interval  time.Duration // 0 disables time rotation
+	seq       int
+	rows      uint64 // data rows written across all files
+
+	pending  uint64 // rows buffered since the last successful flush
+	lost     uint64 // rows dropped by failed writes
+	failures int    // failed flushes in a row
+	reopens  int    // files abandoned in a row
+	failed   chan struct{}
+
+	// nextRotation is the interval boundary after which the next write
+	// starts a new file.
+	nextRotation time.Time
+
+	path   string
+	file   csvFile
+	gz     *gzip.Writer
+	writer *wigle.Writer
+
+	// create makes each new file; newWigleCSV sets it to createCSVFile.
+	create func(path string) (csvFile, error)
+
+	// onRotate is called with the finished file and its successor after a
+	// rotation.
+	onRotate func(finished, next string)
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// csvFile is the file a wigleCSV writes to.
+type csvFile interface {
+	io.WriteSeeker
+	io.Closer
+}
+
+func createCSVFile(path string) (csvFile, error) {
+	return os.Create(path)
+}
+
+func newWigleCSV(base string, compress bool, precision wigle.Precision, maxSize int64, interval time.Duration) (*wigleCSV, error) {
+	w := &wigleCSV{
+		base:      base,
+		compress:  compress,
+		precision: precision,
+		maxSize:   maxSize,
+		interval:  interval,
+		failed:    make(chan struct{}),
+		done:      make(chan struct{}),
+		create:    createCSVFile,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.wg.Add(1)
+	go w.flushLoop()
+	return w, nil
+}
+
+// flushLoop flushes buffered rows every csvFlushInterval.
+func (w *wigleCSV) flushLoop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(csvFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			w.checkedFlush()
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Path returns the file currently being written.
+func (w *wigleCSV) Path() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.path
+}
+
+// open creates the next file in the sequence and writes the WiGLE headers.
+// w.mu must be held.
+func (w *wigleCSV) open() error {
+	path := w.base
+	if w.seq > 0 {
+		path += fmt.Sprintf("-%03d", w.seq)
+	}
+	path += ".csv"
+	if w.compress {
+		path += ".gz"
+	}
+
+	file, err := w.create(path)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = file
+	w.gz = nil
+	if w.compress {
+		w.gz = gzip.NewWriter(file)
+		out = w.gz
+	}
+
+	if w.interval > 0 {
+		// Truncate aligns to whole hours/days in UTC.
+		w.nextRotation = time.Now().Truncate(w.interval).Add(w.interval)
+	}
+
+	w.path = path
+	w.file = file
+	w.writer = wigle.NewWriter(out)
+	w.writer.Precision = w.precision
+	if err := w.writer.WriteHeader(detectDeviceInfo()); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// flush pushes buffered rows all the way to the file. The gzip stream is
+// flushed too so a power pull still leaves a readable prefix.
+func (w *wigleCSV) flush() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Flush()
+	}
+	return nil
+}
+
+// Flush writes out buffered rows.
+func (w *wigleCSV) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.checkedFlush()
+}
+
+// checkedFlush flushes and keeps track of failures. w.mu must be held.
+func (w *wigleCSV) checkedFlush() error {
+	if err := w.flush(); err != nil {
+		logWarn("failed to flush CSV %s: %v", w.path, err)
+		w.fail()
+		return err
+	}
+	w.pending, w.failures, w.reopens = 0, 0, 0
+	return nil
+}
+
+// fail records a failed write. The rows buffered since the last good flush
+// are gone: wigle.Writer keeps failing once its buffer has. Repeated failures
+// move on to a new file, and if those fail too the CSV gives up and Failed
+// is closed. w.mu must be held.
+func (w *wigleCSV) fail() {
+	w.lost += w.pending
+	w.rows -= w.pending
+	w.pending = 0
+	w.failures++
+	if w.failures < csvMaxFailures || isClosed(w.failed) {
+		return
+	}
+	if w.reopens == csvMaxReopens {
+		logError("giving up on CSV output after %d new files failed as well", w.reopens)
+		close(w.failed)
+		return
+	}
+	w.failures = 0
+	w.reopens++
+	finished := w.path
+	w.close() // already failing
+	w.seq++
+	if err := w.open(); err != nil {
+		logWarn("failed to start a new CSV file: %v", err)
+		return
+	}
+	logWarn("Abandoned %s after repeated write errors (%d rows lost so far), now writing to %s",
+		finished, w.lost, w.path)
+	if w.onRotate != nil {
+		w.onRotate(finished, w.path)
+	}
+}
+
+// Finished lists the files this CSV has rotated away from that still exist,
+// oldest first.
+func (w *wigleCSV) Finished() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var paths []string
+	for seq := range w.seq {
+		path := w.base
+		if seq > 0 {
+			path += fmt.Sprintf("-%03d", seq)
+		}
+		for _, ext := range []string{".csv", ".csv.gz"} {
+			if _, err := os.Stat(path + ext); err == nil {
+				paths = append(paths, path+ext)
+			}
+		}
+	}
+	return paths
+}
+
+// Compress switches to gzipped output, starting a new file. It returns false
+// if the output is compressed already or the new file couldn't be started.
+func (w *wigleCSV) Compress() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.compress {
+		return false
+	}
+	w.compress = true
+	if err := w.rotate(); err != nil {
+		logWarn("failed to start a compressed CSV: %v", err)
+		w.fail()
+		return false
+	}
+	return true
+}
+
+// Failed is closed once the CSV has given up after repeated write errors.
+func (w *wigleCSV) Failed() <-chan struct{} {
+	return w.failed
+}
+
+// Lost returns the number of rows dropped by failed writes.
+func (w *wigleCSV) Lost() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lost
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *wigleCSV) close() error {
+	err := w.flush()
+	if w.gz != nil {
+		err = errors.Join(err, w.gz.Close())
+	}
+	return errors.Join(err, w.file.Close())
+}
+
+// Rows returns the number of data rows written so far.
+func (w *wigleCSV) Rows() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows
+}
+
+// WriteRecord buffers a single row; it reaches the disk on the next flush.
+// Time rotation happens lazily before the first write past the boundary;
+// size rotation happens once the flushed file has reached the limit.
+func (w *wigleCSV) WriteRecord(r wigle.Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.interval > 0 && !time.Now().Before(w.nextRotation) {
+		if err := w.rotate(); err != nil {
+			w.lost++
+			w.fail()
+			return err
+		}
+	}
+
+	// Errors are returned for the sink to log.
+	if err := w.writer.Write(r); err != nil {
+		w.lost++
+		w.fail()
+		return err
+	}
+	w.rows++
+	w.pending++
+
+	if w.maxSize <= 0 {
+		return nil
+	}
+	size, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil || size < w.maxSize {
+		return err
+	}
+	if err := w.rotate(); err != nil {
+		w.fail()
+		return err
+	}
+	return nil
+}
+
+// Write writes a sighting as a WiGLE CSV row.
+func (w *wigleCSV) Write(s Sighting) error {
+	return w.WriteRecord(wigleRecord(s))
+}
+
+// wigleRecord converts a sighting into a WiGLE CSV record.
+func wigleRecord(s Sighting) wigle.Record {
+	// Mask to major+minor class bits only (matches Android's getDeviceClass()).
+	// WiFi rows carry the real channel and frequency instead.
+	channel, frequency := 0, int(s.Class&0x1FFC)
+	if s.Type == wigle.TypeWiFi {
+		channel, frequency = s.Channel, s.Frequency
+	}
+	return wigle.Record{
+		MAC:       s.Address,
+		SSID:      s.Name,
+		AuthMode:  s.Capabilities,
+		FirstSeen: s.FirstSeen,
+		Channel:   channel,
+		Frequency: frequency,
+		RSSI:      int(s.RSSI),
+		Latitude:  s.Location.Latitude,
+		Longitude: s.Location.Longitude,
+		Altitude:  s.Location.Altitude,
+		Accuracy:  s.Location.Error,
+		MfgrID:    s.MfgrID,
+		Type:      s.Type,
+	}
+}
+
+// rotate closes the current file and starts the next one. w.mu must be held.
+func (w *wigleCSV) rotate() error {
+	finished := w.path
+	if err := w.close(); err != nil {
+		return err
+	}
+	w.pending = 0
+	w.seq++
+	if err := w.open(); err != nil {
+		return err
+	}
+	logInfo("Rotated CSV, now writing to %s", w.path)
+	if w.onRotate != nil {
+		w.onRotate(finished, w.path)
+	}
+	return nil
+}
+
+func (w *wigleCSV) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close()
+}
+
+// byteSize is a flag value accepting sizes like "512KB", "5MB" or "1GB".
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * float64(multiplier))
+	return nil
+}
+
+// formatSize formats a size in the units byteSize accepts, e.g. "1.2MB".
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// fullDisk hands out files whose writes fail with ENOSPC while it is full,
+// or for the next failWrites writes.
+type fullDisk struct {
+	full       bool
+	failWrites int
+	created    []string
+}
+
+type fullDiskFile struct {
+	*os.File
+	disk *fullDisk
+}
+
+func (f fullDiskFile) Write(p []byte) (int, error) {
+	if f.disk.full || f.disk.failWrites > 0 {
+		f.disk.failWrites--
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	return f.File.Write(p)
+}
+
+func (d *fullDisk) create(path string) (csvFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d.created = append(d.created, filepath.Base(path))
+	return fullDiskFile{f, d}, nil
+}
+
+// newTestCSV opens a wigleCSV on disk. Unlike newWigleCSV it starts no
+// flush loop, so the test decides when rows are flushed.
+func newTestCSV(t *testing.T, disk *fullDisk) *wigleCSV {
+	t.Helper()
+	w := &wigleCSV{
+		base:      filepath.Join(t.TempDir(), "capture"),
+		precision: wigle.DefaultPrecision,
+		failed:    make(chan struct{}),
+		done:      make(chan struct{}),
+		create:    disk.create,
+	}
+	if err := w.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func testRecord(mac string) wigle.Record {
+	return wigle.Record{
+		MAC:       mac,
+		FirstSeen: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		RSSI:      -60,
+		Latitude:  1,
+		Longitude: 2,
+		Type:      wigle.TypeBLE,
+	}
+}
+
+func TestCSVDiskFullReopens(t *testing.T) {
+	disk := &fullDisk{}
+	w := newTestCSV(t, disk)
+	var rotated []string
+	w.onRotate = func(finished, next string) {
+		rotated = append(rotated, filepath.Base(finished), filepath.Base(next))
+	}
+
+	if err := w.WriteRecord(testRecord("00:00:00:00:00:01")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next flush hits a full disk, and the rows buffered since the
+	// last good one are lost.
+	disk.failWrites = 1
+	w.WriteRecord(testRecord("00:00:00:00:00:02"))
+	w.WriteRecord(testRecord("00:00:00:00:00:03"))
+	for i := 1; i < csvMaxFailures; i++ {
+		if err := w.Flush(); err == nil {
+			t.Fatalf("flush %d succeeded on a full disk", i)
+		}
+		if !strings.HasSuffix(w.Path(), "capture.csv") {
+			t.Fatalf("moved on to %s after %d failed flushes", w.Path(), i)
+		}
+	}
+	if n := w.Lost(); n != 2 {
+		t.Errorf("Lost = %d, want 2", n)
+	}
+
+	// The last failure in a row abandons the file. By then there is room
+	// again, so the new one takes rows.
+	w.Flush()
+	if got := filepath.Base(w.Path()); got != "capture-001.csv" {
+		t.Fatalf("writing to %s after %d failed flushes, want capture-001.csv", got, csvMaxFailures)
+	}
+	if len(rotated) != 2 || rotated[0] != "capture.csv" || rotated[1] != "capture-001.csv" {
+		t.Errorf("onRotate called with %v, want capture.csv and capture-001.csv", rotated)
+	}
+	if err := w.WriteRecord(testRecord("00:00:00:00:00:04")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flush to the new file: %v", err)
+	}
+	if n, rows := w.Lost(), w.Rows(); n != 2 || rows != 2 {
+		t.Errorf("Lost = %d, Rows = %d, want 2 lost and 2 written", n, rows)
+	}
+	select {
+	case <-w.Failed():
+		t.Error("Failed closed after a successful reopen")
+	default:
+	}
+
+	data, err := os.ReadFile(w.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 ||
+		!strings.HasPrefix(lines[2], "00:00:00:00:00:04,") {
+		t.Errorf("new file holds\n%s\nwant the headers and row 4", data)
+	}
+}
+
+func TestCSVDiskFullGivesUp(t *testing.T) {
+	disk := &fullDisk{}
+	w := newTestCSV(t, disk)
+	disk.full = true
+
+	w.WriteRecord(testRecord("00:00:00:00:00:01"))
+	flushes := csvMaxFailures * (csvMaxReopens + 1)
+	for i := 1; i <= flushes; i++ {
+		w.Flush()
+		select {
+		case <-w.Failed():
+			if i < flushes {
+				t.Fatalf("gave up after %d failed flushes, want %d", i, flushes)
+			}
+		default:
+			if i == flushes {
+				t.Fatalf("still trying after %d failed flushes", i)
+			}
+		}
+	}
+	if len(disk.created) != csvMaxReopens+1 {
+		t.Errorf("created %v, want the first file and %d new ones", disk.created, csvMaxReopens)
+	}
+	if n := w.Lost(); n != 1 {
+		t.Errorf("Lost = %d, want 1", n)
+	}
+
+	// Once given up, more failures neither reopen nor panic on the
+	// closed channel.
+	w.Flush()
+	if len(disk.created) != csvMaxReopens+1 {
+		t.Errorf("created %v after giving up", disk.created)
+	}
+}
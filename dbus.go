@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// dbusTimeout bounds calls about a single object, such as property
+	// reads, which a healthy BlueZ answers within milliseconds. Without it
+	// a hung bluetoothd would stall the caller indefinitely.
+	dbusTimeout = 500 * time.Millisecond
+	// dbusSlowTimeout bounds calls that can return a lot, such as
+	// GetManagedObjects with a large device cache, or that make BlueZ
+	// talk to the controller.
+	dbusSlowTimeout = 5 * time.Second
+	// dbusMaxBackoff caps the wait between attempts to get back onto the
+	// bus or BlueZ after losing it.
+	dbusMaxBackoff = 30 * time.Second
+)
+
+var errBusLost = errors.New("lost connection to the system bus")
+
+// callTimeout calls method on obj, giving up after timeout.
+func callTimeout(obj dbus.BusObject, timeout time.Duration, method string, args ...any) *dbus.Call {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return obj.CallWithContext(ctx, method, 0, args...)
+}
+
+// getProperty reads a property such as "org.bluez.Adapter1.Powered".
+func getProperty(obj dbus.BusObject, prop string) (dbus.Variant, error) {
+	i := strings.LastIndexByte(prop, '.')
+	var v dbus.Variant
+	err := callTimeout(obj, dbusTimeout, "org.freedesktop.DBus.Properties.Get", prop[:i], prop[i+1:]).Store(&v)
+	return v, err
+}
+
+// setProperty writes a property such as "org.bluez.Adapter1.Powered".
+// Powering a controller can take a moment, so this gets the slow timeout.
+func setProperty(obj dbus.BusObject, prop string, value any) error {
+	i := strings.LastIndexByte(prop, '.')
+	return callTimeout(obj, dbusSlowTimeout, "org.freedesktop.DBus.Properties.Set",
+		prop[:i], prop[i+1:], dbus.MakeVariant(value)).Err
+}
+
+// managedObjects returns everything BlueZ exposes, keyed by object path.
+func managedObjects(conn *dbus.Conn) (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, error) {
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err := callTimeout(conn.Object("org.bluez", "/"), dbusSlowTimeout,
+		"org.freedesktop.DBus.ObjectManager.GetManagedObjects").Store(&objects)
+	return objects, err
+}
+
+// busUnavailable reports whether err means the bus or BlueZ itself is gone,
+// e.g. because dbus-daemon or bluetoothd restarted, rather than a call
+// failing on its own merits. Such errors are worth retrying later.
+func busUnavailable(err error) bool {
+	if errors.Is(err, errBusLost) || errors.Is(err, dbus.ErrClosed) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		switch dbusErr.Name {
+		case "org.freedesktop.DBus.Error.ServiceUnknown",
+			"org.freedesktop.DBus.Error.NameHasNoOwner",
+			"org.freedesktop.DBus.Error.NoReply",
+			"org.freedesktop.DBus.Error.Disconnected":
+			return true
+		}
+	}
+	return false
+}
+
+// systemBus is a shared system bus connection that is re-established when
+// it drops. While the bus is down, reconnecting is only tried with backoff,
+// so callers fail fast in the meantime.
+type systemBus struct {
+	mu      sync.Mutex
+	conn    *dbus.Conn
+	retry   time.Time
+	backoff time.Duration
+}
+
+// Conn returns a live connection, reconnecting if the last one dropped.
+func (b *systemBus) Conn() (*dbus.Conn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn != nil && b.conn.Connected() {
+		return b.conn, nil
+	}
+	if time.Now().Before(b.retry) {
+		return nil, errBusLost
+	}
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		b.backoff = min(max(2*b.backoff, time.Second), dbusMaxBackoff)
+		b.retry = time.Now().Add(b.backoff)
+		return nil, fmt.Errorf("%w: %v", errBusLost, err)
+	}
+	if b.conn != nil {
+		logInfo("Reconnected to the system bus")
+	}
+	b.conn = conn
+	b.backoff = 0
+	return conn, nil
+}
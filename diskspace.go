@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// spaceCheckInterval is how often the free space in the output directory is
+// checked.
+const spaceCheckInterval = 30 * time.Second
+
+// Actions taken by --low-space-action.
+const (
+	lowSpaceStop     = "stop"     // stop writing until space is freed
+	lowSpaceDelete   = "delete"   // delete this session's oldest rotated CSVs
+	lowSpaceCompress = "compress" // carry on in a gzipped CSV
+)
+
+// spaceMonitor watches the free space in the output directory. The Pager's
+// overlay filesystem is small, and filling it breaks other payloads too.
+// When the free space falls below minFree it acts: stopping all output,
+// deleting the oldest CSVs this session rotated away from, or switching the
+// CSV to gzip. When deleting or compressing can't help any more it stops.
+type spaceMonitor struct {
+	dir     string
+	minFree int64
+	action  string
+	csv     *wigleCSV // nil without a raw CSV
+
+	free    atomic.Int64 // bytes, -1 until the first check
+	stopped atomic.Bool
+	deleted atomic.Uint64
+}
+
+func newSpaceMonitor(dir string, minFree int64, action string, csv *wigleCSV) *spaceMonitor {
+	m := &spaceMonitor{dir: dir, minFree: minFree, action: action, csv: csv}
+	m.free.Store(-1)
+	return m
+}
+
+// Free returns the free space at the last check, or -1 before the first.
+func (m *spaceMonitor) Free() int64 {
+	return m.free.Load()
+}
+
+// Stopped reports whether output is stopped for lack of space.
+func (m *spaceMonitor) Stopped() bool {
+	return m.stopped.Load()
+}
+
+// Deleted returns the number of files deleted to free space.
+func (m *spaceMonitor) Deleted() uint64 {
+	return m.deleted.Load()
+}
+
+// Run checks the free space every spaceCheckInterval until ctx is cancelled.
+func (m *spaceMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(spaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		m.check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *spaceMonitor) check() {
+	free, err := freeSpace(m.dir)
+	if err != nil {
+		logWarn("failed to check free space in %s: %v", m.dir, err)
+		return
+	}
+	m.free.Store(free)
+	if free >= m.minFree {
+		if m.stopped.Swap(false) {
+			logInfo("%s free in %s again, writing resumed", formatSize(free), m.dir)
+		}
+		return
+	}
+	if m.stopped.Load() {
+		return
+	}
+
+	switch m.action {
+	case lowSpaceDelete:
+		if m.deleteOldest() {
+			return
+		}
+	case lowSpaceCompress:
+		if m.csv != nil && m.csv.Compress() {
+			logWarn("only %s free in %s, switched to compressed output in %s",
+				formatSize(free), m.dir, m.csv.Path())
+			return
+		}
+	}
+	m.stopped.Store(true)
+	logError("only %s free in %s, below --min-free-space %s: NOT WRITING until space is freed",
+		formatSize(free), m.dir, formatSize(m.minFree))
+}
+
+// deleteOldest deletes rotated CSVs of this session, oldest first, until
+// there is enough space again. It never touches the file being written or
+// anything this session didn't write. It returns false if nothing was left
+// to delete.
+func (m *spaceMonitor) deleteOldest() bool {
+	if m.csv == nil {
+		return false
+	}
+	deletedAny := false
+	for _, path := range m.csv.Finished() {
+		if err := os.Remove(path); err != nil {
+			logWarn("failed to delete %s to free space: %v", path, err)
+			continue
+		}
+		os.Remove(path + pendingSuffix)
+		m.deleted.Add(1)
+		deletedAny = true
+		free, err := freeSpace(m.dir)
+		logWarn("low on space, deleted %s (%s now free)", path, formatSize(free))
+		if err == nil {
+			m.free.Store(free)
+			if free >= m.minFree {
+				break
+			}
+		}
+	}
+	return deletedAny
+}
+
+// freeSpace returns the bytes available to unprivileged users in dir.
+func freeSpace(dir string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// parseLowSpaceAction checks a --low-space-action value.
+func parseLowSpaceAction(s string) error {
+	switch s {
+	case lowSpaceStop, lowSpaceDelete, lowSpaceCompress:
+		return nil
+	}
+	return fmt.Errorf("--low-space-action must be %s, %s or %s, not %q",
+		lowSpaceStop, lowSpaceDelete, lowSpaceCompress, s)
+}
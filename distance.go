@@ -0,0 +1,14 @@
+package main
+
+import "math"
+
+// txPowerPathLoss is the typical loss between the antenna and 1 m, used to
+// turn an advertised TX power into the RSSI expected at 1 m.
+const txPowerPathLoss = 41
+
+// estimateDistance applies the log-distance path loss model: measuredPower
+// is the RSSI expected at 1 m and exponent is 2 in free space, higher
+// indoors. The result is in metres.
+func estimateDistance(rssi int16, measuredPower int, exponent float64) float64 {
+	return math.Pow(10, float64(measuredPower-int(rssi))/(10*exponent))
+}
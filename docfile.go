@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+)
+
+// docFile is a structured document (KML, GPX, GeoJSON, ...) whose closing
+// footer is rewritten after every append, so the file on disk is always
+// well-formed even if the process is killed mid-run.
+type docFile struct {
+	f      *os.File
+	footer []byte
+	end    int64 // offset where the footer starts
+}
+
+func createDocFile(path string, header, footer string) (*docFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &docFile{f: f, footer: []byte(footer)}
+	if err := d.Append([]byte(header)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Append writes p in front of the footer.
+func (d *docFile) Append(p []byte) error {
+	buf := make([]byte, 0, len(p)+len(d.footer))
+	buf = append(buf, p...)
+	buf = append(buf, d.footer...)
+	if _, err := d.f.WriteAt(buf, d.end); err != nil {
+		return err
+	}
+	d.end += int64(len(p))
+	return nil
+}
+
+func (d *docFile) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dutyCycle alternates scanning for on with resting for off to save power.
+// Only the scan stops: GPS, the outputs and the per-device state carry on, so
+// nothing is lost but the sightings themselves. One dutyCycle is shared by
+// all adapters so they rest together.
+type dutyCycle struct {
+	on, off time.Duration
+
+	mu   sync.Mutex
+	wake chan struct{} // closed by Burst
+}
+
+// parseDutyCycle parses "30s/90s", meaning scan for 30 seconds then rest
+// for 90. An empty string returns nil.
+func parseDutyCycle(s string) (*dutyCycle, error) {
+	if s == "" {
+		return nil, nil
+	}
+	onStr, offStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, fmt.Errorf("--duty-cycle %q is not on/off, e.g. 30s/90s", s)
+	}
+	on, err := time.ParseDuration(onStr)
+	if err != nil {
+		return nil, fmt.Errorf("--duty-cycle: %w", err)
+	}
+	off, err := time.ParseDuration(offStr)
+	if err != nil {
+		return nil, fmt.Errorf("--duty-cycle: %w", err)
+	}
+	if on <= 0 || off <= 0 {
+		return nil, fmt.Errorf("--duty-cycle %q needs positive durations", s)
+	}
+	return &dutyCycle{on: on, off: off, wake: make(chan struct{})}, nil
+}
+
+// Rest waits out the off period, or until Burst or ctx ends it early.
+func (d *dutyCycle) Rest(ctx context.Context) {
+	d.mu.Lock()
+	wake := d.wake
+	d.mu.Unlock()
+
+	t := time.NewTimer(d.off)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	case <-wake:
+	}
+}
+
+// Burst ends the current rest on every adapter, starting a scan period now.
+func (d *dutyCycle) Burst() {
+	d.mu.Lock()
+	close(d.wake)
+	d.wake = make(chan struct{})
+	d.mu.Unlock()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// evictInterval is how often devices are evicted from memory.
+const evictInterval = time.Minute
+
+// deviceEvictor keeps the devices map from growing without bound on long
+// runs in busy places, where address randomisation makes every phone a new
+// device every few minutes. Devices unseen for the retention are forgotten,
+// and if there are still more than maxEntries the longest unseen go too. A
+// forgotten device that turns up again gets a new FirstSeen, as it would in
+// the Android app's next session.
+type deviceEvictor struct {
+	retention  time.Duration // 0 keeps devices however long they go unseen
+	maxEntries int           // 0 means no limit
+	now        func() time.Time
+	smoother   *rssiSmoother // pruned along with the devices
+	resolver   *nameResolver // forgets the evicted devices' names; may be nil
+
+	evicted int // only touched with devicesMu held
+}
+
+// Run evicts every evictInterval until ctx is cancelled.
+func (e *deviceEvictor) Run(ctx context.Context) {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			evicted := e.evict()
+			e.smoother.Prune()
+			if e.resolver != nil {
+				e.resolver.Forget(evicted)
+			}
+		}
+	}
+}
+
+// Evicted returns the number of devices evicted so far.
+func (e *deviceEvictor) Evicted() int {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	return e.evicted
+}
+
+// evict forgets devices as described above and returns their addresses.
+func (e *deviceEvictor) evict() []string {
+	now := e.now()
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	var evicted []string
+	if e.retention > 0 {
+		for addr, d := range devices {
+			if now.Sub(d.lastActive()) > e.retention {
+				delete(devices, addr)
+				evicted = append(evicted, addr)
+			}
+		}
+	}
+	if e.maxEntries > 0 && len(devices) > e.maxEntries {
+		type entry struct {
+			addr string
+			last time.Time
+		}
+		entries := make([]entry, 0, len(devices))
+		for addr, d := range devices {
+			entries = append(entries, entry{addr, d.lastActive()})
+		}
+		slices.SortFunc(entries, func(a, b entry) int { return a.last.Compare(b.last) })
+		for _, en := range entries[:len(devices)-e.maxEntries] {
+			delete(devices, en.addr)
+			evicted = append(evicted, en.addr)
+		}
+	}
+	if n := len(evicted); n > 0 {
+		e.evicted += n
+		logDebug("Evicted %d devices from memory, %d left", n, len(devices))
+	}
+	return evicted
+}
+
+// lastActive is when the device was last seen, or first seen if it was
+// never logged.
+func (d *deviceState) lastActive() time.Time {
+	if d.LastSeen.IsZero() {
+		return d.FirstSeen
+	}
+	return d.LastSeen
+}
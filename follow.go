@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// followCueDB is how much the smoothed RSSI must improve on its best so far
+// before the "getting closer" cue fires again.
+const followCueDB = 3
+
+// follower tracks a single device for locating it on foot. It keeps one
+// console line updated with the device's signal and rings the terminal bell
+// whenever the signal gets noticeably stronger.
+type follower struct {
+	target string
+
+	mu       sync.Mutex
+	rssi     int16
+	smoothed float64
+	best     float64
+	distance float64
+	lastSeen time.Time
+}
+
+func newFollower(addr string) *follower {
+	return &follower{target: strings.ToUpper(addr)}
+}
+
+func (f *follower) Matches(addr string) bool {
+	return strings.EqualFold(addr, f.target)
+}
+
+// Update records a new sighting of the followed device.
+func (f *follower) Update(rssi int16, smoothed, distance float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lastSeen.IsZero() {
+		f.best = smoothed
+	}
+	f.rssi = rssi
+	f.smoothed = smoothed
+	f.distance = distance
+	f.lastSeen = time.Now()
+
+	cue := ""
+	if f.smoothed >= f.best+followCueDB {
+		f.best = f.smoothed
+		cue = "\a ▲ stronger"
+	}
+	f.draw(cue)
+}
+
+// Run redraws the line every second so the time since the last sighting
+// keeps counting up, until ctx is cancelled.
+func (f *follower) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			f.draw("")
+			f.mu.Unlock()
+		}
+	}
+}
+
+// draw rewrites the follow line in place. f.mu must be held.
+func (f *follower) draw(cue string) {
+	if f.lastSeen.IsZero() {
+		fmt.Printf("\rFollowing %s: not seen yet\033[K", f.target)
+		return
+	}
+	distance := "?"
+	if f.distance > 0 {
+		distance = fmt.Sprintf("~%.1f m", f.distance)
+	}
+	fmt.Printf("\rFollowing %s: RSSI %d dBm, smoothed %.1f dBm, %s, seen %ds ago%s\033[K",
+		f.target, f.rssi, f.smoothed, distance, int(time.Since(f.lastSeen).Seconds()), cue)
+}
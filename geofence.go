@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// geofence limits where sightings are logged. With any include boxes, a
+// position must be inside one of them; a position within any exclusion
+// circle is never logged.
+type geofence struct {
+	include geoBoxes
+	exclude geoCircles
+}
+
+// Active reports whether any fence is configured.
+func (g *geofence) Active() bool {
+	return len(g.include) > 0 || len(g.exclude) > 0
+}
+
+// Allows reports whether anything may be logged at loc.
+func (g *geofence) Allows(loc LocationData) bool {
+	for _, c := range g.exclude {
+		if c.contains(loc) {
+			return false
+		}
+	}
+	if len(g.include) == 0 {
+		return true
+	}
+	for _, b := range g.include {
+		if b.contains(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// geoBox is a latitude/longitude rectangle given by two opposite corners.
+type geoBox struct {
+	minLat, minLon, maxLat, maxLon float64
+}
+
+func (b geoBox) contains(loc LocationData) bool {
+	return loc.Latitude >= b.minLat && loc.Latitude <= b.maxLat &&
+		loc.Longitude >= b.minLon && loc.Longitude <= b.maxLon
+}
+
+// geoCircle is a radius in metres around a point.
+type geoCircle struct {
+	lat, lon, radius float64
+}
+
+func (c geoCircle) contains(loc LocationData) bool {
+	return geoDistance(c.lat, c.lon, loc.Latitude, loc.Longitude) <= c.radius
+}
+
+// geoDistance returns the great-circle distance in metres between two points.
+func geoDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	rlat1, rlat2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dlat := rlat2 - rlat1
+	dlon := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(a, 1)))
+}
+
+// geoBoxes is a repeatable flag of "lat1,lon1,lat2,lon2" boxes. Several can
+// also be given in one value separated by ";", as an environment variable
+// only holds one.
+type geoBoxes []geoBox
+
+func (g *geoBoxes) String() string {
+	parts := make([]string, len(*g))
+	for i, b := range *g {
+		parts[i] = fmt.Sprintf("%g,%g,%g,%g", b.minLat, b.minLon, b.maxLat, b.maxLon)
+	}
+	return strings.Join(parts, ";")
+}
+
+func (g *geoBoxes) Set(s string) error {
+	for _, part := range strings.Split(s, ";") {
+		v, err := parseCoordinates(part, 4)
+		if err != nil {
+			return err
+		}
+		if err := checkLatLon(v[0], v[1]); err != nil {
+			return err
+		}
+		if err := checkLatLon(v[2], v[3]); err != nil {
+			return err
+		}
+		*g = append(*g, geoBox{
+			minLat: min(v[0], v[2]), minLon: min(v[1], v[3]),
+			maxLat: max(v[0], v[2]), maxLon: max(v[1], v[3]),
+		})
+	}
+	return nil
+}
+
+// geoCircles is a repeatable flag of "lat,lon,metres" circles, separated
+// by ";" like geoBoxes.
+type geoCircles []geoCircle
+
+func (g *geoCircles) String() string {
+	parts := make([]string, len(*g))
+	for i, c := range *g {
+		parts[i] = fmt.Sprintf("%g,%g,%g", c.lat, c.lon, c.radius)
+	}
+	return strings.Join(parts, ";")
+}
+
+func (g *geoCircles) Set(s string) error {
+	for _, part := range strings.Split(s, ";") {
+		v, err := parseCoordinates(part, 3)
+		if err != nil {
+			return err
+		}
+		if err := checkLatLon(v[0], v[1]); err != nil {
+			return err
+		}
+		if v[2] <= 0 {
+			return fmt.Errorf("radius must be positive, got %g", v[2])
+		}
+		*g = append(*g, geoCircle{lat: v[0], lon: v[1], radius: v[2]})
+	}
+	return nil
+}
+
+// parseCoordinates parses exactly n comma-separated numbers.
+func parseCoordinates(s string, n int) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d comma-separated numbers, got %q", n, s)
+	}
+	v := make([]float64, n)
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+	}
+	return v, nil
+}
+
+func checkLatLon(lat, lon float64) error {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return fmt.Errorf("%g,%g is not a valid latitude,longitude", lat, lon)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const geojsonHeader = `{"type":"FeatureCollection","features":[
+`
+
+const geojsonFooter = `
+]}
+`
+
+type geojsonFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geojsonPoint      `json:"geometry"`
+	Properties geojsonProperties `json:"properties"`
+}
+
+type geojsonPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+type geojsonProperties struct {
+	Name      string `json:"name"`
+	MAC       string `json:"mac"`
+	RSSI      int    `json:"rssi"`
+	Type      string `json:"type"`
+	FirstSeen string `json:"first_seen"`
+}
+
+// geojsonWriter writes a FeatureCollection with one Point feature per unique
+// device, placed where the device was first logged.
+type geojsonWriter struct {
+	doc      *docFile
+	features map[string]bool
+}
+
+func newGeoJSONWriter(path string) (*geojsonWriter, error) {
+	doc, err := createDocFile(path, geojsonHeader, geojsonFooter)
+	if err != nil {
+		return nil, err
+	}
+	return &geojsonWriter{doc: doc, features: make(map[string]bool)}, nil
+}
+
+func (g *geojsonWriter) Write(s Sighting) error {
+	if g.features[s.Address] {
+		return nil
+	}
+
+	feature, err := json.Marshal(geojsonFeature{
+		Type: "Feature",
+		Geometry: geojsonPoint{
+			Type:        "Point",
+			Coordinates: []float64{s.Location.Longitude, s.Location.Latitude, s.Location.Altitude},
+		},
+		Properties: geojsonProperties{
+			Name:      s.Name,
+			MAC:       s.Address,
+			RSSI:      int(s.RSSI),
+			Type:      deviceTypeLegend(s.Class & 0x1FFC),
+			FirstSeen: s.FirstSeen.Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(g.features) > 0 {
+		feature = append([]byte(",\n"), feature...)
+	}
+	if err := g.doc.Append(feature); err != nil {
+		return err
+	}
+	g.features[s.Address] = true
+	return nil
+}
+
+func (g *geojsonWriter) Close() error {
+	return g.doc.Close()
+}
@@ -3,10 +3,21 @@ module github.com/craftzman7/wigle-bluetooth-pineapplepager
 go 1.25.4
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/ncruces/go-sqlite3 v0.33.3
 	github.com/stratoberry/go-gpsd v1.3.0
 	tinygo.org/x/bluetooth v0.14.0
 )
 
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/ncruces/go-sqlite3-wasm v1.1.1-0.20260409221933-87e4b35a38d0 // indirect
+	github.com/ncruces/julianday v1.0.0 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
+)
+
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/godbus/dbus/v5 v5.1.0
@@ -17,5 +28,5 @@ require (
 	github.com/tinygo-org/cbgo v0.0.4 // indirect
 	github.com/tinygo-org/pio v0.2.0 // indirect
 	golang.org/x/exp v0.0.0-20241204233417-43b7b7cde48d // indirect
-	golang.org/x/sys v0.11.0 // indirect
+	golang.org/x/sys v0.43.0
 )
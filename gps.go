@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/stratoberry/go-gpsd"
+)
+
+// gpsClient is the LocationProvider for gpsd. It keeps a gpsd session
+// open: gpsd often isn't listening yet when the payload starts on boot, and
+// can die or be restarted mid-run, so failed dials and dropped sessions are
+// retried rather than fatal. A dropped session loses the fix.
+type gpsClient struct {
+	locationFeed
+	addr    string
+	retries int // consecutive failed dials to give up after; 0 retries forever
+	backoff time.Duration
+	timeout time.Duration // reconnect after this long without a report; 0 never
+	onSKY   gpsd.Filter
+	onReady func() // called once a session is open; may be nil
+}
+
+// Run keeps a session open until ctx is cancelled. It only returns early if
+// the retry limit is reached.
+func (g *gpsClient) Run(ctx context.Context) error {
+	for {
+		gps, err := g.dial(ctx)
+		if err != nil {
+			return err
+		}
+		var last atomic.Int64
+		last.Store(time.Now().UnixNano())
+		gps.AddFilter("TPV", func(r any) {
+			last.Store(time.Now().UnixNano())
+			g.tpv(r)
+		})
+		if g.onSKY != nil {
+			gps.AddFilter("SKY", g.onSKY)
+		}
+		done := gps.Watch()
+		logInfo("Connected to gpsd at %s", g.peer())
+		if g.onReady != nil {
+			g.onReady()
+		}
+
+		if err := g.wait(ctx, gps, done, &last); err != nil {
+			// Closing the session ends the gpsd watch goroutine.
+			gps.Close()
+			<-done
+			return nil
+		}
+		gps.Close()
+		g.lose()
+		logWarn("Lost connection to gpsd, reconnecting")
+	}
+}
+
+// wait blocks until the session ends, closing it if gpsd goes quiet for
+// longer than the timeout. A half-open TCP connection to a remote gpsd
+// would otherwise never report an error. It returns ctx's error if ctx was
+// cancelled first.
+func (g *gpsClient) wait(ctx context.Context, gps *gpsd.Session, done chan bool, last *atomic.Int64) error {
+	var tick <-chan time.Time
+	if g.timeout > 0 {
+		ticker := time.NewTicker(g.timeout / 4)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		case <-tick:
+			quiet := time.Since(time.Unix(0, last.Load()))
+			if quiet >= g.timeout {
+				logWarn("No reports from gpsd for %s", quiet.Round(time.Second))
+				gps.Close()
+				<-done
+				return nil
+			}
+		}
+	}
+}
+
+// peer describes the gpsd being talked to, with its resolved address when
+// that differs from the one configured.
+func (g *gpsClient) peer() string {
+	tcp, err := net.ResolveTCPAddr("tcp", g.addr)
+	if err != nil || tcp.String() == g.addr {
+		return g.addr
+	}
+	return fmt.Sprintf("%s (%s)", g.addr, tcp)
+}
+
+// validGPSDAddress checks that addr is a host:port pair.
+func validGPSDAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return errors.New("missing host")
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+func (g *gpsClient) dial(ctx context.Context) (*gpsd.Session, error) {
+	for attempt := 1; ; attempt++ {
+		gps, err := gpsd.Dial(g.addr)
+		if err == nil {
+			return gps, nil
+		}
+		if g.retries > 0 && attempt >= g.retries {
+			return nil, fmt.Errorf("gpsd dial: %w", err)
+		}
+		logWarn("gpsd at %s not reachable (%v), retrying in %s", g.addr, err, g.backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(g.backoff):
+		}
+	}
+}
+
+// locationFromTPV converts a TPV report into a location. It reports false
+// when the report holds no usable fix: gpsd sends mode 0/1 reports with
+// NaN coordinates, and malformed reports may carry NaN, infinite or
+// out-of-range values even with a fix. Optional fields that aren't finite
+// are zeroed, which means unknown.
+func locationFromTPV(report *gpsd.TPVReport, received time.Time) (LocationData, bool) {
+	if report == nil || report.Mode < gpsd.Mode2D {
+		return LocationData{}, false
+	}
+	if !finite(report.Lat) || !finite(report.Lon) ||
+		math.Abs(report.Lat) > 90 || math.Abs(report.Lon) > 180 {
+		return LocationData{}, false
+	}
+	finiteOrZero := func(v float64) float64 {
+		if !finite(v) {
+			return 0
+		}
+		return v
+	}
+	eph := finiteOrZero(report.Eph)
+	if eph < 0 {
+		eph = 0
+	}
+	return LocationData{
+		Fix:       true,
+		Latitude:  report.Lat,
+		Longitude: report.Lon,
+		Altitude:  finiteOrZero(report.Alt),
+		Error:     eph,
+		Track:     finiteOrZero(report.Track),
+		Speed:     finiteOrZero(report.Speed),
+		Time:      report.Time,
+		Received:  received,
+	}, true
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
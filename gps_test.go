@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stratoberry/go-gpsd"
+)
+
+func TestLocationFromTPV(t *testing.T) {
+	nan := math.NaN()
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	received := at.Add(time.Second)
+	good := func() *gpsd.TPVReport {
+		return &gpsd.TPVReport{Mode: gpsd.Mode3D, Time: at, Lat: 51.5, Lon: -0.12,
+			Alt: 30, Eph: 4, Track: 90, Speed: 2}
+	}
+	for _, tt := range []struct {
+		name   string
+		report func(r *gpsd.TPVReport)
+		fix    bool
+		want   LocationData // checked when fix is true
+	}{
+		{"3D fix", func(r *gpsd.TPVReport) {}, true,
+			LocationData{Latitude: 51.5, Longitude: -0.12, Altitude: 30, Error: 4, Track: 90, Speed: 2}},
+		{"2D fix", func(r *gpsd.TPVReport) { r.Mode = gpsd.Mode2D; r.Alt = nan }, true,
+			LocationData{Latitude: 51.5, Longitude: -0.12, Error: 4, Track: 90, Speed: 2}},
+		{"mode 0", func(r *gpsd.TPVReport) { r.Mode = gpsd.NoValueSeen }, false, LocationData{}},
+		{"mode 1", func(r *gpsd.TPVReport) { r.Mode = gpsd.NoFix; r.Lat, r.Lon = nan, nan }, false, LocationData{}},
+		{"NaN latitude", func(r *gpsd.TPVReport) { r.Lat = nan }, false, LocationData{}},
+		{"infinite longitude", func(r *gpsd.TPVReport) { r.Lon = math.Inf(-1) }, false, LocationData{}},
+		{"latitude over 90", func(r *gpsd.TPVReport) { r.Lat = 90.5 }, false, LocationData{}},
+		{"longitude under -180", func(r *gpsd.TPVReport) { r.Lon = -181 }, false, LocationData{}},
+		{"poles and date line", func(r *gpsd.TPVReport) { r.Lat, r.Lon = -90, 180 }, true,
+			LocationData{Latitude: -90, Longitude: 180, Altitude: 30, Error: 4, Track: 90, Speed: 2}},
+		{"NaN optional fields", func(r *gpsd.TPVReport) { r.Alt, r.Eph, r.Track, r.Speed = nan, nan, nan, math.Inf(1) }, true,
+			LocationData{Latitude: 51.5, Longitude: -0.12}},
+		{"negative error", func(r *gpsd.TPVReport) { r.Eph = -1 }, true,
+			LocationData{Latitude: 51.5, Longitude: -0.12, Altitude: 30, Track: 90, Speed: 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := good()
+			tt.report(r)
+			loc, fix := locationFromTPV(r, received)
+			if fix != tt.fix {
+				t.Fatalf("fix = %v, want %v", fix, tt.fix)
+			}
+			if !fix {
+				if loc != (LocationData{}) {
+					t.Errorf("location %+v without a fix", loc)
+				}
+				return
+			}
+			tt.want.Fix, tt.want.Time, tt.want.Received = true, at, received
+			if loc != tt.want {
+				t.Errorf("location %+v, want %+v", loc, tt.want)
+			}
+		})
+	}
+
+	if _, fix := locationFromTPV(nil, received); fix {
+		t.Error("fix from a nil report")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const gpxHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="wigle-bluetooth" xmlns="http://www.topografix.com/GPX/1/1"
+ xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">
+`
+
+const gpxTrackOpen = `<trk>
+<name>wigle-bluetooth</name>
+<trkseg>
+`
+
+const gpxFooter = `</trkseg>
+</trk>
+</gpx>
+`
+
+// gpxWriter writes the drive track and one waypoint per unique device to a
+// GPX file. GPX requires every <wpt> to come before the <trk>, so the
+// waypoints are appended to a side file instead and only put in front of
+// the track by Close. Until then the GPX file holds the track alone, with
+// the footer rewritten behind each new point, so a crash never loses the
+// route, and the waypoints are in the side file.
+type gpxWriter struct {
+	mu        sync.Mutex
+	path      string
+	f         *os.File
+	end       int64 // offset of the footer
+	wpt       *os.File
+	waypoints map[string]bool
+	closed    bool
+}
+
+func newGPXWriter(path string) (*gpxWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(gpxHeader + gpxTrackOpen + gpxFooter); err != nil {
+		f.Close()
+		return nil, err
+	}
+	wpt, err := os.Create(path + ".wpt")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gpxWriter{
+		path:      path,
+		f:         f,
+		end:       int64(len(gpxHeader) + len(gpxTrackOpen)),
+		wpt:       wpt,
+		waypoints: make(map[string]bool),
+	}, nil
+}
+
+// AddTrackPoint appends a position to the drive track. GPX 1.1 has no
+// speed or course, so they go in Garmin's widely read track point extension.
+func (g *gpxWriter) AddTrackPoint(loc LocationData, t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed { // a late fix during shutdown
+		return nil
+	}
+	pt := fmt.Sprintf("<trkpt lat=\"%f\" lon=\"%f\"><ele>%.1f</ele><time>%s</time>"+
+		"<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>%.2f</gpxtpx:speed><gpxtpx:course>%.1f</gpxtpx:course>"+
+		"</gpxtpx:TrackPointExtension></extensions></trkpt>\n",
+		loc.Latitude, loc.Longitude, loc.Altitude, t.UTC().Format(time.RFC3339), loc.Speed, loc.Track)
+	if _, err := g.f.WriteAt([]byte(pt+gpxFooter), g.end); err != nil {
+		return err
+	}
+	g.end += int64(len(pt))
+	return nil
+}
+
+// AddWaypoint records a waypoint for the sighting's device, at the location
+// of the first sighting seen for it.
+func (g *gpxWriter) AddWaypoint(s Sighting) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed || g.waypoints[s.Address] {
+		return nil
+	}
+	g.waypoints[s.Address] = true
+
+	name := s.Name
+	if name == "" {
+		name = s.Address
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<wpt lat=\"%f\" lon=\"%f\"><ele>%.1f</ele><time>%s</time><name>",
+		s.Location.Latitude, s.Location.Longitude, s.Location.Altitude,
+		s.FirstSeen.UTC().Format(time.RFC3339))
+	xml.EscapeText(&b, []byte(name))
+	b.WriteString("</name><desc>")
+	xml.EscapeText(&b, []byte(s.Address+" "+s.Capabilities))
+	b.WriteString("</desc></wpt>\n")
+	_, err := g.wpt.Write(b.Bytes())
+	return err
+}
+
+// Write adds a waypoint for the sighting, so the GPX writer can be used as a
+// Sink.
+func (g *gpxWriter) Write(s Sighting) error {
+	return g.AddWaypoint(s)
+}
+
+// Close puts the waypoints in front of the track, writing the GPX file
+// once more in full, and removes the side file. Points added after it are
+// dropped.
+func (g *gpxWriter) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	err := g.assemble()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := g.wpt.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Remove(g.wpt.Name())
+	}
+	return err
+}
+
+// assemble writes the header, the waypoints and the track to a new file
+// that replaces the GPX file. g.mu must be held.
+func (g *gpxWriter) assemble() error {
+	tmp, err := os.CreateTemp(filepath.Dir(g.path), filepath.Base(g.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(gpxHeader)
+	if err == nil {
+		_, err = g.wpt.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(tmp, g.wpt)
+	}
+	if err == nil {
+		track := io.NewSectionReader(g.f, int64(len(gpxHeader)), g.end+int64(len(gpxFooter))-int64(len(gpxHeader)))
+		_, err = io.Copy(tmp, track)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), g.path)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// gpxFile is the part of a GPX file the tests look at. Elements holds the
+// top-level element names in order.
+type gpxFile struct {
+	Elements  []string
+	Waypoints []string
+	Points    int
+}
+
+func readGPX(t *testing.T, path string) gpxFile {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var doc struct {
+		Any []struct {
+			XMLName xml.Name
+			Name    string     `xml:"name"`
+			Points  []struct{} `xml:"trkseg>trkpt"`
+		} `xml:",any"`
+	}
+	if err := xml.NewDecoder(f).Decode(&doc); err != nil {
+		t.Fatalf("%s is not valid XML: %v", filepath.Base(path), err)
+	}
+	var g gpxFile
+	for _, e := range doc.Any {
+		g.Elements = append(g.Elements, e.XMLName.Local)
+		switch e.XMLName.Local {
+		case "wpt":
+			g.Waypoints = append(g.Waypoints, e.Name)
+		case "trk":
+			g.Points += len(e.Points)
+		}
+	}
+	return g
+}
+
+func TestGPXWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drive.gpx")
+	g, err := newGPXWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 3 {
+		if err := g.AddTrackPoint(testFix(1+float64(i)/1000, 2), now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []Sighting{
+		{Address: "00:11:22:33:44:01", Name: "Speaker <kitchen> & co", FirstSeen: now, Location: testFix(1, 2)},
+		{Address: "00:11:22:33:44:02", FirstSeen: now, Location: testFix(1.001, 2)},
+		{Address: "00:11:22:33:44:01", Name: "again", FirstSeen: now, Location: testFix(1.002, 2)},
+	} {
+		if err := g.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.AddTrackPoint(testFix(1.003, 2), now.Add(3*time.Second))
+
+	// Until closed, the file is a valid GPX of the track alone.
+	if got := readGPX(t, path); len(got.Elements) != 1 || got.Points != 4 {
+		t.Errorf("before Close the file holds %v with %d points, want the track of 4", got.Elements, got.Points)
+	}
+
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := readGPX(t, path)
+	if want := []string{"wpt", "wpt", "trk"}; !slices.Equal(got.Elements, want) {
+		t.Errorf("elements %v, want %v", got.Elements, want)
+	}
+	if len(got.Waypoints) != 2 || got.Waypoints[0] != "Speaker <kitchen> & co" || got.Waypoints[1] != "00:11:22:33:44:02" {
+		t.Errorf("waypoints %q", got.Waypoints)
+	}
+	if got.Points != 4 {
+		t.Errorf("%d track points, want 4", got.Points)
+	}
+	if _, err := os.Stat(path + ".wpt"); !os.IsNotExist(err) {
+		t.Errorf("waypoint file left behind: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("GPX file mode %v, want 0644", info.Mode())
+	}
+
+	// A fix arriving during shutdown is dropped.
+	if err := g.AddTrackPoint(testFix(1, 2), time.Now()); err != nil {
+		t.Errorf("AddTrackPoint after Close: %v", err)
+	}
+	if got := readGPX(t, path); got.Points != 4 {
+		t.Errorf("%d track points after Close, want 4", got.Points)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// extrapolationHorizon is how far past the latest fix a position is
+// projected along its course. Beyond that the fix is used as it is, so GPS
+// dropouts never produce invented positions.
+const extrapolationHorizon = 2 * time.Second
+
+// earthRadius is the mean radius of the Earth in metres.
+const earthRadius = 6371000.0
+
+// fixTime returns when a fix was taken: its GPS time if gpsd sent one,
+// otherwise when the report arrived.
+func fixTime(l LocationData) time.Time {
+	if !l.Time.IsZero() {
+		return l.Time
+	}
+	return l.Received
+}
+
+// positionAt estimates the position at t from two consecutive fixes. gpsd
+// reports once a second, which at highway speed is some 30 m between
+// fixes. A time between the fixes is interpolated linearly; a time shortly
+// after cur is projected along its course and speed. With prev zero, only
+// the projection is done.
+func positionAt(prev, cur LocationData, t time.Time) LocationData {
+	if !cur.Fix {
+		return cur
+	}
+	ct := fixTime(cur)
+
+	if t.After(ct) {
+		dt := t.Sub(ct)
+		if dt > extrapolationHorizon || cur.Speed <= 0 {
+			return cur
+		}
+		d := cur.Speed * dt.Seconds()
+		course := cur.Track * math.Pi / 180
+		lat := cur.Latitude * math.Pi / 180
+		out := cur
+		out.Latitude += d * math.Cos(course) / earthRadius * 180 / math.Pi
+		out.Longitude += d * math.Sin(course) / (earthRadius * math.Cos(lat)) * 180 / math.Pi
+		return out
+	}
+
+	pt := fixTime(prev)
+	if !prev.Fix || !pt.Before(t) || !pt.Before(ct) {
+		return cur
+	}
+	f := float64(t.Sub(pt)) / float64(ct.Sub(pt))
+	out := cur
+	out.Latitude = prev.Latitude + f*(cur.Latitude-prev.Latitude)
+	out.Longitude = prev.Longitude + f*(cur.Longitude-prev.Longitude)
+	out.Altitude = prev.Altitude + f*(cur.Altitude-prev.Altitude)
+	return out
+}
+
+// heldLimit bounds how many sightings wait for the next fix.
+const heldLimit = 10000
+
+// heldSightings holds the sightings made while moving until the fix after
+// them arrives. A sighting is stamped with the current time, which the GPS
+// clock puts just after the latest fix, so only then are there fixes on
+// both sides of it to interpolate between. The zero value is ready to use.
+type heldSightings struct {
+	mu      sync.Mutex
+	pending []Sighting // Location is the fix current when each was made
+}
+
+// Add holds s. It returns false when the buffer is full.
+func (h *heldSightings) Add(s Sighting) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.pending) >= heldLimit {
+		return false
+	}
+	h.pending = append(h.pending, s)
+	return true
+}
+
+// Take empties the buffer.
+func (h *heldSightings) Take() []Sighting {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	taken := h.pending
+	h.pending = nil
+	return taken
+}
+
+// Expired removes and returns the sightings made more than
+// extrapolationHorizon before now, whose next fix is overdue.
+func (h *heldSightings) Expired(now time.Time) []Sighting {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var expired, kept []Sighting
+	for _, s := range h.pending {
+		if now.Sub(s.Timestamp) > extrapolationHorizon {
+			expired = append(expired, s)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	h.pending = kept
+	return expired
+}
+
+// heldPosition estimates where a held sighting was made from the fix
+// current then and next, the one after it. Without a next fix, or when the
+// two are too far apart for a straight line between them, the first is
+// projected instead.
+func heldPosition(s Sighting, next LocationData) LocationData {
+	if !next.Fix || fixTime(next).Sub(fixTime(s.Location)) > extrapolationHorizon {
+		return positionAt(LocationData{}, s.Location, s.Timestamp)
+	}
+	return positionAt(s.Location, next, s.Timestamp)
+}
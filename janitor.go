@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// janitor removes devices from BlueZ once they haven't been seen for maxAge.
+// bluetoothd keeps an object for every device it has ever heard, and with
+// thousands of them after a long drive its own processing slows down until
+// discovery degrades. Paired, bonded, trusted and connected devices are
+// left alone.
+//
+// Only BlueZ forgets the device: its first-seen time lives in our own device
+// map, so a device found again after removal keeps it. Its cached properties
+// are dropped with it, so they are fetched afresh when it comes back.
+type janitor struct {
+	bus    *systemBus
+	props  *propCache
+	maxAge time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[propKey]time.Time
+	removed  atomic.Uint64
+}
+
+func newJanitor(bus *systemBus, props *propCache, maxAge time.Duration) *janitor {
+	return &janitor{bus: bus, props: props, maxAge: maxAge, lastSeen: make(map[propKey]time.Time)}
+}
+
+// Seen notes that a device was heard on a controller.
+func (j *janitor) Seen(adapterID, addr string) {
+	j.mu.Lock()
+	j.lastSeen[propKey{adapterID, addr}] = time.Now()
+	j.mu.Unlock()
+}
+
+// Removed returns the number of devices removed from BlueZ.
+func (j *janitor) Removed() uint64 {
+	return j.removed.Load()
+}
+
+// Run sweeps the given controllers until ctx is cancelled.
+func (j *janitor) Run(ctx context.Context, adapterIDs []string) {
+	ticker := time.NewTicker(max(j.maxAge/4, 10*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		conn, err := j.bus.Conn()
+		if err != nil {
+			continue
+		}
+		for _, id := range adapterIDs {
+			if err := j.sweep(conn, id); err != nil {
+				logWarn("failed to clean up devices on %s: %v", id, err)
+			}
+		}
+	}
+}
+
+func (j *janitor) sweep(conn *dbus.Conn, id string) error {
+	objects, err := managedObjects(conn)
+	if err != nil {
+		return err
+	}
+	adapter := conn.Object("org.bluez", adapterPath(id))
+	now := time.Now()
+	present := make(map[propKey]bool)
+	for path, ifaces := range objects {
+		props, ok := ifaces["org.bluez.Device1"]
+		if !ok || !onAdapter(path, id) {
+			continue
+		}
+		addr, _ := props["Address"].Value().(string)
+		key := propKey{id, addr}
+		present[key] = true
+		if keepDevice(props) {
+			continue
+		}
+
+		j.mu.Lock()
+		last, ok := j.lastSeen[key]
+		if !ok {
+			// Known from before this session, or never reported to us:
+			// start the clock now.
+			j.lastSeen[key] = now
+		}
+		j.mu.Unlock()
+		if !ok || now.Sub(last) < j.maxAge {
+			continue
+		}
+
+		if err := callTimeout(adapter, dbusTimeout, "org.bluez.Adapter1.RemoveDevice", path).Err; err != nil {
+			return err
+		}
+		j.removed.Add(1)
+		j.props.Remove(id, addr)
+		j.mu.Lock()
+		delete(j.lastSeen, key)
+		j.mu.Unlock()
+	}
+
+	// Forget devices BlueZ dropped by itself.
+	j.mu.Lock()
+	for key := range j.lastSeen {
+		if key.adapter == id && !present[key] {
+			delete(j.lastSeen, key)
+		}
+	}
+	j.mu.Unlock()
+	return nil
+}
+
+// keepDevice reports whether a device is one the user set up rather than a
+// passer-by, and so must stay in BlueZ.
+func keepDevice(props map[string]dbus.Variant) bool {
+	for _, p := range []string{"Paired", "Bonded", "Trusted", "Connected"} {
+		if v, _ := props[p].Value().(bool); v {
+			return true
+		}
+	}
+	return false
+}
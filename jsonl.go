@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// jsonlRecord is the JSON Lines representation of a sighting.
+type jsonlRecord struct {
+	MAC          string   `json:"mac"`
+	Adapter      string   `json:"adapter,omitempty"`
+	AddressType  string   `json:"address_type"`
+	Name         string   `json:"name"`
+	Vendor       string   `json:"vendor,omitempty"`
+	RSSI         int      `json:"rssi"`
+	RSSISmoothed float64  `json:"rssi_smoothed"`
+	Lat          float64  `json:"lat"`
+	Lon          float64  `json:"lon"`
+	Alt          float64  `json:"alt"`
+	Accuracy     float64  `json:"accuracy"`
+	Speed        float64  `json:"speed"`  // m/s
+	Course       float64  `json:"course"` // degrees from true north
+	Distance     float64  `json:"distance_m,omitempty"`
+	DeviceClass  string   `json:"device_class"`
+	Capabilities string   `json:"capabilities"`
+	MfgrID       string   `json:"mfgr_id"`
+	MfgrIDs      []uint16 `json:"mfgr_ids,omitempty"`
+	MfgrNames    []string `json:"mfgr_names,omitempty"`
+	Type         string   `json:"type"`
+	FirstSeen    string   `json:"first_seen"`
+	Timestamp    string   `json:"timestamp"`
+	TimeSource   string   `json:"time_source"` // "gps" or "system"
+	Backfilled   bool     `json:"backfilled,omitempty"`
+	Connectable  bool     `json:"connectable"`
+	AdvFlags     string   `json:"adv_flags,omitempty"`
+	Channel      int      `json:"channel,omitempty"`
+	Frequency    int      `json:"frequency_mhz,omitempty"`
+}
+
+// jsonlWriter writes one JSON object per line (NDJSON). Records go straight to
+// the file so every line is complete on disk as soon as Write returns.
+type jsonlWriter struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newJSONLWriter(path string) (*jsonlWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// newJSONLRecord converts a sighting into its JSON form.
+func newJSONLRecord(s Sighting) jsonlRecord {
+	return jsonlRecord{
+		MAC:          s.Address,
+		Adapter:      s.Adapter,
+		AddressType:  s.AddressType,
+		Name:         s.Name,
+		Vendor:       s.Vendor,
+		RSSI:         int(s.RSSI),
+		RSSISmoothed: math.Round(s.SmoothedRSSI*10) / 10,
+		Lat:          s.Location.Latitude,
+		Lon:          s.Location.Longitude,
+		Alt:          s.Location.Altitude,
+		Accuracy:     s.Location.Error,
+		Speed:        s.Location.Speed,
+		Course:       s.Location.Track,
+		Distance:     s.Distance,
+		DeviceClass:  fmt.Sprintf("0x%06X", s.Class),
+		Capabilities: s.Capabilities,
+		MfgrID:       s.MfgrID,
+		MfgrIDs:      s.MfgrIDs,
+		MfgrNames:    s.MfgrNames,
+		Type:         s.Type,
+		FirstSeen:    s.FirstSeen.Format(time.RFC3339),
+		Timestamp:    s.Timestamp.Format(time.RFC3339),
+		TimeSource:   timeSource(s),
+		Backfilled:   s.Backfilled,
+		Connectable:  s.Connectable,
+		AdvFlags:     s.AdvFlags,
+		Channel:      s.Channel,
+		Frequency:    s.Frequency,
+	}
+}
+
+// timeSource says which clock a sighting's timestamp came from.
+func timeSource(s Sighting) string {
+	if s.TimeFromGPS {
+		return "gps"
+	}
+	return "system"
+}
+
+func (j *jsonlWriter) Write(s Sighting) error {
+	// Encode escapes quotes and control characters and terminates each
+	// record with a newline.
+	return j.enc.Encode(newJSONLRecord(s))
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.f.Close()
+}
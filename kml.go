@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+const kmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+<name>wigle-bluetooth</name>
+`
+
+const kmlFooter = `</Document>
+</kml>
+`
+
+const kmlIcon = "http://maps.google.com/mapfiles/kml/shapes/placemark_circle.png"
+
+// kmlStyles colour placemarks by the major device class of the
+// deviceTypeLegend keys, with WiFi networks apart. Colours are KML's
+// aabbggrr.
+var kmlStyles = []struct {
+	major uint32
+	id    string
+	color string
+}{
+	{0x0000, "misc", "ff9e9e9e"},
+	{0x0100, "computer", "ffd47700"},
+	{0x0200, "phone", "ff4bb43c"},
+	{0x0400, "av", "ff3182f5"},
+	{0x0500, "peripheral", "ffb41e91"},
+	{0x0700, "wearable", "fff4d442"},
+	{0x0800, "toy", "ffe632f0"},
+	{0x0900, "health", "ff4b19e6"},
+	{0x1F00, "uncategorized", "ffffffff"},
+	{0, "wifi", "ff19e1ff"},
+}
+
+// kmlStyleDefs returns the <Style> elements of kmlStyles.
+func kmlStyleDefs() string {
+	var b strings.Builder
+	for _, st := range kmlStyles {
+		fmt.Fprintf(&b, "<Style id=\"%s\"><IconStyle><color>%s</color><Icon><href>%s</href></Icon></IconStyle></Style>\n",
+			st.id, st.color, kmlIcon)
+	}
+	return b.String()
+}
+
+// legendClasses maps deviceTypeLegend names back to a device class.
+var legendClasses = sync.OnceValue(func() map[string]uint32 {
+	classes := make(map[string]uint32)
+	for class := uint32(0); class <= 0x1FFC; class++ {
+		if _, ok := classes[deviceTypeLegend(class)]; !ok {
+			classes[deviceTypeLegend(class)] = class
+		}
+	}
+	return classes
+})
+
+// kmlStyle picks the style of a sighting's placemark. Most BLE devices have
+// no Class of Device, so theirs is looked up from the legend at the front of
+// the capabilities, which may have come from the Appearance.
+func kmlStyle(s Sighting) string {
+	if s.Type == wigle.TypeWiFi {
+		return "wifi"
+	}
+	class := s.Class & 0x1FFC
+	if class == 0 {
+		legend, _, _ := strings.Cut(s.Capabilities, "[")
+		class = legendClasses()[strings.TrimSpace(legend)]
+	}
+	for _, st := range kmlStyles {
+		if st.major == class&0x1F00 {
+			return st.id
+		}
+	}
+	return "misc"
+}
+
+// kmlWriter writes each sighting as a KML Placemark for Google Earth.
+type kmlWriter struct {
+	doc *docFile
+}
+
+func newKMLWriter(path string) (*kmlWriter, error) {
+	doc, err := createDocFile(path, kmlHeader+kmlStyleDefs(), kmlFooter)
+	if err != nil {
+		return nil, err
+	}
+	return &kmlWriter{doc: doc}, nil
+}
+
+func (k *kmlWriter) Write(s Sighting) error {
+	// Fall back to the MAC so nameless devices are still labelled on the map.
+	name := s.Name
+	if name == "" {
+		name = s.Address
+	}
+
+	description := fmt.Sprintf("MAC: %s\nRSSI: %d dBm\nCapabilities: %s\nFirst seen: %s",
+		s.Address, s.RSSI, s.Capabilities, s.FirstSeen.Format("2006-01-02 15:04:05"))
+
+	var b bytes.Buffer
+	b.WriteString("<Placemark>\n<name>")
+	xml.EscapeText(&b, []byte(name))
+	b.WriteString("</name>\n<description>")
+	xml.EscapeText(&b, []byte(description))
+	b.WriteString("</description>\n")
+	fmt.Fprintf(&b, "<styleUrl>#%s</styleUrl>\n", kmlStyle(s))
+	fmt.Fprintf(&b, "<Point><coordinates>%f,%f,%f</coordinates></Point>\n",
+		s.Location.Longitude, s.Location.Latitude, s.Location.Altitude)
+	b.WriteString("</Placemark>\n")
+
+	return k.doc.Append(b.Bytes())
+}
+
+func (k *kmlWriter) Close() error {
+	return k.doc.Close()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+var (
+	errAdapterOff = errors.New("adapter was powered off")
+	errScanEnded  = errors.New("discovery was stopped by someone else")
+)
+
+// leScanner runs BLE discovery on one BlueZ controller. tinygo's Adapter.Scan
+// listens for device signals from every controller on the shared system bus,
+// so with two adapters each scan would report every device with no way to
+// tell which radio heard it. This scanner uses its own connection and only
+// follows objects below its controller.
+type leScanner struct {
+	id      string
+	conn    *dbus.Conn
+	adapter dbus.BusObject
+
+	props   *propCache              // if set, kept up to date with every device's properties
+	filter  map[string]dbus.Variant // discovery filter; nil means LE only
+	passive bool                    // scan passively through an advertisement monitor
+
+	mu     sync.Mutex
+	cancel chan struct{} // closed by Stop; nil when not scanning
+}
+
+// newLEScanner connects to the controller named id, e.g. "hci1".
+func newLEScanner(id string) (*leScanner, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	s := &leScanner{
+		id:      id,
+		conn:    conn,
+		adapter: conn.Object("org.bluez", adapterPath(id)),
+	}
+	if _, err := getProperty(s.adapter, "org.bluez.Adapter1.Address"); err != nil {
+		conn.Close()
+		var dbusErr dbus.Error
+		if errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.DBus.Error.UnknownObject" {
+			return nil, fmt.Errorf("adapter %s does not exist", id)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// adapterPath returns the D-Bus object path of a controller.
+func adapterPath(id string) dbus.ObjectPath {
+	return dbus.ObjectPath("/org/bluez/" + id)
+}
+
+// devicePath returns the D-Bus object path of a device on a controller.
+func devicePath(id, addr string) dbus.ObjectPath {
+	return adapterPath(id) + dbus.ObjectPath("/dev_"+strings.ReplaceAll(addr, ":", "_"))
+}
+
+// onAdapter reports whether a BlueZ object belongs to the controller id.
+func onAdapter(path dbus.ObjectPath, id string) bool {
+	return strings.HasPrefix(string(path), string(adapterPath(id))+"/")
+}
+
+// Scan reports advertisements until ctx is cancelled, Stop is called or
+// discovery fails. Connected devices are reported once up front, as BlueZ
+// sends no advertisements for them. If the scanner's bus connection has
+// dropped, Scan reconnects first; errors caused by losing the bus wrap
+// errBusLost.
+func (s *leScanner) Scan(ctx context.Context, callback func(Advertisement)) (err error) {
+	if err := s.reconnect(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && !s.conn.Connected() && !errors.Is(err, errBusLost) {
+			err = fmt.Errorf("%w: %v", errBusLost, err)
+		}
+	}()
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		return errors.New("already scanning")
+	}
+	cancel := make(chan struct{})
+	s.cancel = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.cancel == cancel {
+			s.cancel = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	powered, err := getProperty(s.adapter, "org.bluez.Adapter1.Powered")
+	if err != nil {
+		return err
+	}
+	if on, _ := powered.Value().(bool); !on {
+		return errAdapterOff
+	}
+
+	// BlueZ merges the filters of every client running discovery on the
+	// adapter, so with --classic, or other programs scanning, results may
+	// arrive that this filter alone would have excluded. A passive scan
+	// isn't discovery and takes no filter.
+	if !s.passive {
+		filter := s.filter
+		if filter == nil {
+			filter = map[string]dbus.Variant{"Transport": dbus.MakeVariant("le")}
+		}
+		if err := callTimeout(s.adapter, dbusTimeout, "org.bluez.Adapter1.SetDiscoveryFilter", filter).Err; err != nil {
+			return err
+		}
+	}
+
+	matches := [][]dbus.MatchOption{
+		{dbus.WithMatchInterface("org.freedesktop.DBus.ObjectManager"), dbus.WithMatchMember("InterfacesAdded")},
+		{dbus.WithMatchInterface("org.freedesktop.DBus.ObjectManager"), dbus.WithMatchMember("InterfacesRemoved")},
+		{dbus.WithMatchInterface("org.freedesktop.DBus.Properties"), dbus.WithMatchMember("PropertiesChanged"),
+			dbus.WithMatchPathNamespace(adapterPath(s.id))},
+	}
+	for _, m := range matches {
+		if err := s.conn.AddMatchSignal(m...); err != nil {
+			return err
+		}
+		defer s.conn.RemoveMatchSignal(m...)
+	}
+	signals := make(chan *dbus.Signal, 64)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	// Remember what BlueZ already knows so PropertiesChanged signals, which
+	// only carry the changes, can be turned into complete results.
+	objects, err := managedObjects(s.conn)
+	if err != nil {
+		return err
+	}
+	devices := make(map[dbus.ObjectPath]map[string]dbus.Variant)
+	for path, ifaces := range objects {
+		props, ok := ifaces["org.bluez.Device1"]
+		if !ok || !onAdapter(path, s.id) {
+			continue
+		}
+		devices[path] = props
+		s.remember(props)
+		if connected, _ := props["Connected"].Value().(bool); connected {
+			callback(makeAdvertisement(s.id, props))
+		}
+	}
+
+	if s.passive {
+		stop, err := s.startPassive()
+		if err != nil {
+			return err
+		}
+		defer stop()
+	} else {
+		if err := callTimeout(s.adapter, dbusSlowTimeout, "org.bluez.Adapter1.StartDiscovery").Err; err != nil {
+			return err
+		}
+		defer callTimeout(s.adapter, dbusSlowTimeout, "org.bluez.Adapter1.StopDiscovery")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-cancel:
+			return nil
+		case sig, ok := <-signals:
+			if !ok {
+				return errBusLost
+			}
+			switch sig.Name {
+			case "org.freedesktop.DBus.ObjectManager.InterfacesAdded":
+				var path dbus.ObjectPath
+				var ifaces map[string]map[string]dbus.Variant
+				if dbus.Store(sig.Body, &path, &ifaces) != nil || !onAdapter(path, s.id) {
+					continue
+				}
+				props, ok := ifaces["org.bluez.Device1"]
+				if !ok {
+					continue
+				}
+				devices[path] = props
+				s.remember(props)
+				callback(makeAdvertisement(s.id, props))
+			case "org.freedesktop.DBus.ObjectManager.InterfacesRemoved":
+				var path dbus.ObjectPath
+				var ifaces []string
+				if dbus.Store(sig.Body, &path, &ifaces) != nil || !slices.Contains(ifaces, "org.bluez.Device1") {
+					continue
+				}
+				props, ok := devices[path]
+				if !ok {
+					continue
+				}
+				delete(devices, path)
+				if s.props != nil {
+					addr, _ := props["Address"].Value().(string)
+					s.props.Remove(s.id, addr)
+				}
+			case "org.freedesktop.DBus.Properties.PropertiesChanged":
+				var iface string
+				var changed map[string]dbus.Variant
+				var invalidated []string
+				if dbus.Store(sig.Body, &iface, &changed, &invalidated) != nil {
+					continue
+				}
+				switch iface {
+				case "org.bluez.Adapter1":
+					if sig.Path != adapterPath(s.id) {
+						continue
+					}
+					if on, ok := changed["Powered"].Value().(bool); ok && !on {
+						return errAdapterOff
+					}
+					if on, ok := changed["Discovering"].Value().(bool); ok && !on && !s.passive {
+						return errScanEnded
+					}
+				case "org.bluez.Device1":
+					props, ok := devices[sig.Path]
+					if !ok {
+						continue
+					}
+					for k, v := range changed {
+						props[k] = v
+					}
+					s.remember(props)
+					callback(makeAdvertisement(s.id, props))
+				}
+			}
+		}
+	}
+}
+
+// reconnect replaces the scanner's bus connection if it has dropped.
+func (s *leScanner) reconnect() error {
+	if s.conn.Connected() {
+		return nil
+	}
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBusLost, err)
+	}
+	s.conn.Close()
+	s.conn = conn
+	s.adapter = conn.Object("org.bluez", adapterPath(s.id))
+	return nil
+}
+
+// remember passes a device's properties on to the property cache.
+func (s *leScanner) remember(props map[string]dbus.Variant) {
+	if s.props != nil {
+		s.props.Update(s.id, props)
+	}
+}
+
+// ID returns the controller's name, e.g. "hci0".
+func (s *leScanner) ID() string {
+	return s.id
+}
+
+// Stop ends a running Scan.
+func (s *leScanner) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel == nil {
+		return errors.New("not scanning")
+	}
+	close(s.cancel)
+	s.cancel = nil
+	return nil
+}
+
+// Close releases the scanner's D-Bus connection.
+func (s *leScanner) Close() error {
+	return s.conn.Close()
+}
+
+// makeAdvertisement converts BlueZ Device1 properties into an
+// advertisement heard on adapter id.
+func makeAdvertisement(id string, props map[string]dbus.Variant) Advertisement {
+	adv := Advertisement{Adapter: id, Timestamp: time.Now()}
+	adv.Address, _ = props["Address"].Value().(string)
+	addrType, _ := props["AddressType"].Value().(string)
+	adv.Random = addrType == "random"
+	adv.Name, _ = props["Name"].Value().(string)
+	adv.RSSI, _ = props["RSSI"].Value().(int16)
+
+	uuids, _ := props["UUIDs"].Value().([]string)
+	for _, u := range uuids {
+		if uuid, err := bluetooth.ParseUUID(u); err == nil {
+			adv.ServiceUUIDs = append(adv.ServiceUUIDs, uuid)
+		}
+	}
+	if md, ok := props["ManufacturerData"].Value().(map[uint16]dbus.Variant); ok {
+		for id, v := range md {
+			data, _ := v.Value().([]byte)
+			adv.ManufacturerData = append(adv.ManufacturerData, bluetooth.ManufacturerDataElement{CompanyID: id, Data: data})
+		}
+	}
+	if sd, ok := props["ServiceData"].Value().(map[string]dbus.Variant); ok {
+		for u, v := range sd {
+			uuid, err := bluetooth.ParseUUID(u)
+			if err != nil {
+				continue
+			}
+			data, _ := v.Value().([]byte)
+			adv.ServiceData = append(adv.ServiceData, bluetooth.ServiceDataElement{UUID: uuid, Data: data})
+		}
+	}
+	return adv
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stratoberry/go-gpsd"
+)
+
+// LocationProvider is where sightings get their position from: gpsd, an
+// NMEA receiver, a fixed location or a mock. The scan pipeline only talks
+// to this interface.
+type LocationProvider interface {
+	// Run feeds positions until ctx is cancelled. It only returns an error
+	// if the source is gone for good.
+	Run(ctx context.Context) error
+	// Current returns the latest position. Fix is false while there is
+	// none; the coordinates of a lost fix are kept but mustn't be used.
+	Current() LocationData
+	// Subscribe calls fn with every new fix, e.g. for the track logs. It
+	// must be called before Run.
+	Subscribe(fn func(LocationData))
+}
+
+// locationFeed holds the latest position and the subscribers of a
+// LocationProvider. The providers embed it.
+type locationFeed struct {
+	mu          sync.Mutex
+	current     LocationData
+	subscribers []func(LocationData)
+}
+
+func (f *locationFeed) Current() LocationData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current
+}
+
+func (f *locationFeed) Subscribe(fn func(LocationData)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribers = append(f.subscribers, fn)
+}
+
+// publish makes loc the current fix and hands it to the subscribers.
+func (f *locationFeed) publish(loc LocationData) {
+	f.mu.Lock()
+	f.current = loc
+	subscribers := f.subscribers
+	f.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(loc)
+	}
+}
+
+// lose marks the fix as lost. Only the fix flag changes, so no coordinates
+// from a report without a fix ever reach a sighting.
+func (f *locationFeed) lose() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.current.Fix = false
+}
+
+// tpv handles a gpsd TPV report, from gpsd itself or parsed from NMEA.
+func (f *locationFeed) tpv(r any) {
+	report, ok := r.(*gpsd.TPVReport)
+	if !ok || report == nil {
+		return
+	}
+	loc, fix := locationFromTPV(report, time.Now())
+	if !fix {
+		f.lose()
+		logDebug("GPS update: no fix (mode %d)", report.Mode)
+		return
+	}
+	f.publish(loc)
+}
+
+// fixedLocation is the position of a fixed installation. It never talks to
+// a GPS, and its one fix is there from the start.
+type fixedLocation struct {
+	locationFeed
+}
+
+func newFixedLocation(loc LocationData) *fixedLocation {
+	loc.Fix = true
+	loc.Received = time.Now()
+	f := &fixedLocation{}
+	f.current = loc
+	return f
+}
+
+// Run does nothing until ctx is cancelled.
+func (f *fixedLocation) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// mockFix is one step of a mockLocation script.
+type mockFix struct {
+	After    time.Duration // wait this long after the previous step
+	Location LocationData  // Fix false loses the fix
+}
+
+// mockLocation is a scriptable LocationProvider. Run plays its fixes in
+// order and Set publishes one at once. Tests drive the pipeline with it,
+// and --replay feeds it the recorded positions.
+type mockLocation struct {
+	locationFeed
+	fixes []mockFix
+}
+
+// Run plays the script, then waits until ctx is cancelled.
+func (m *mockLocation) Run(ctx context.Context) error {
+	for _, f := range m.fixes {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(f.After):
+		}
+		m.Set(f.Location)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// Set publishes loc, stamped as received now unless it says otherwise, or
+// loses the fix if loc has none.
+func (m *mockLocation) Set(loc LocationData) {
+	if !loc.Fix {
+		m.lose()
+		return
+	}
+	if loc.Received.IsZero() {
+		loc.Received = time.Now()
+	}
+	m.publish(loc)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stratoberry/go-gpsd"
+)
+
+// recordAt sets loc as the mock's fix, records a BLE sighting and returns
+// the rows written for it.
+func recordAt(p *pipeline, sink *captureSink, loc LocationData) []Sighting {
+	p.location.(*mockLocation).Set(loc)
+	p.record(Sighting{Address: "00:11:22:33:44:55", AddressType: addressPublic, RSSI: -60, Type: "BLE"})
+	return sink.rows()
+}
+
+func TestRecordNoFix(t *testing.T) {
+	p, sink := newTestPipeline(t, testConfig(t), &mockLocation{})
+	if rows := recordAt(p, sink, LocationData{}); len(rows) != 0 {
+		t.Errorf("wrote %d rows without a fix", len(rows))
+	}
+	// A lost fix keeps its coordinates, which mustn't be used.
+	p.location.(*mockLocation).Set(testFix(1, 2))
+	if rows := recordAt(p, sink, LocationData{}); len(rows) != 0 {
+		t.Errorf("wrote %d rows after the fix was lost", len(rows))
+	}
+	if n := p.noFix.Load(); n != 2 {
+		t.Errorf("%d sightings counted without a fix, want 2", n)
+	}
+}
+
+func TestRecordAccuracyGate(t *testing.T) {
+	p, sink := newTestPipeline(t, testConfig(t, "--max-accuracy", "20"), &mockLocation{})
+	for _, tt := range []struct {
+		err   float64
+		write bool
+	}{
+		{10, true},
+		{20, true},
+		{20.5, false},
+		{0, false}, // unknown
+	} {
+		loc := testFix(1, 2)
+		loc.Error = tt.err
+		if rows := recordAt(p, sink, loc); (len(rows) == 1) != tt.write {
+			t.Errorf("error %.1f m: wrote %d rows, want written %v", tt.err, len(rows), tt.write)
+		}
+	}
+	if n := p.inaccurateFix.Load(); n != 2 {
+		t.Errorf("%d sightings counted as inaccurate, want 2", n)
+	}
+}
+
+func TestRecordStaleFix(t *testing.T) {
+	p, sink := newTestPipeline(t, testConfig(t, "--fix-max-age", "10s"), &mockLocation{})
+
+	loc := testFix(1, 2)
+	loc.Received = time.Now().Add(-time.Minute)
+	if rows := recordAt(p, sink, loc); len(rows) != 0 {
+		t.Errorf("wrote %d rows with a fix a minute old", len(rows))
+	}
+	if n := p.staleFix.Load(); n != 1 {
+		t.Errorf("%d sightings counted with a stale fix, want 1", n)
+	}
+
+	loc.Received = time.Now().Add(-5 * time.Second)
+	if rows := recordAt(p, sink, loc); len(rows) != 1 {
+		t.Errorf("wrote %d rows with a fix 5s old, want 1", len(rows))
+	}
+}
+
+// movingFix returns a fix at lat, lon heading north at 30 m/s, taken at
+// the given GPS time.
+func movingFix(lat, lon float64, at time.Time) LocationData {
+	loc := testFix(lat, lon)
+	loc.Speed, loc.Track, loc.Time = 30, 0, at
+	return loc
+}
+
+func TestRecordInterpolation(t *testing.T) {
+	loc := &mockLocation{}
+	p, sink := newTestPipeline(t, testConfig(t), loc)
+
+	// The clock follows GPS time, so the sighting is stamped a little
+	// after the fix it is made with.
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+	cur := movingFix(10, 20, at)
+	if rows := recordAt(p, sink, cur); len(rows) != 0 {
+		t.Fatalf("wrote %d rows before the next fix, want them held", len(rows))
+	}
+	time.Sleep(50 * time.Millisecond)
+	p.record(Sighting{Address: "00:11:22:33:44:66", AddressType: addressPublic, RSSI: -60, Type: "BLE"})
+
+	next := movingFix(10.0003, 20, at.Add(time.Second))
+	next.Altitude = 22
+	loc.Set(next)
+	rows := sink.rows()
+	if len(rows) != 2 {
+		t.Fatalf("wrote %d rows once the next fix came, want 2", len(rows))
+	}
+	for _, s := range rows {
+		f := s.Timestamp.Sub(at).Seconds()
+		if f <= 0 || f >= 1 {
+			t.Fatalf("sighting stamped %v, not between the fixes at %v and %v", s.Timestamp, at, next.Time)
+		}
+		got := s.Location
+		lat, alt := 10+f*0.0003, 12+f*10
+		if math.Abs(got.Latitude-lat) > 1e-9 || got.Longitude != 20 || math.Abs(got.Altitude-alt) > 1e-9 {
+			t.Errorf("position %.7f,%.7f %.2f m at %.3f s, want %.7f,20 %.2f m", got.Latitude, got.Longitude, got.Altitude, f, lat, alt)
+		}
+	}
+	if rows[1].Location.Latitude <= rows[0].Location.Latitude {
+		t.Errorf("later sighting placed at %.7f, not north of the earlier one at %.7f",
+			rows[1].Location.Latitude, rows[0].Location.Latitude)
+	}
+}
+
+func TestRecordInterpolationOff(t *testing.T) {
+	loc := &mockLocation{}
+	p, sink := newTestPipeline(t, testConfig(t, "--interpolate=false"), loc)
+	rows := recordAt(p, sink, movingFix(10, 20, time.Now()))
+	if len(rows) != 1 || rows[0].Location.Latitude != 10 {
+		t.Errorf("rows %+v, want one at the fix", rows)
+	}
+}
+
+func TestRecordStationary(t *testing.T) {
+	// Without a speed there is nothing to interpolate, so nothing waits.
+	p, sink := newTestPipeline(t, testConfig(t), &mockLocation{})
+	if rows := recordAt(p, sink, testFix(10, 20)); len(rows) != 1 {
+		t.Errorf("wrote %d rows while stationary, want 1", len(rows))
+	}
+}
+
+// TestRecordExtrapolation covers held sightings whose next fix doesn't
+// come: they are projected from the fix they were made with.
+func TestRecordExtrapolation(t *testing.T) {
+	loc := &mockLocation{}
+	p, sink := newTestPipeline(t, testConfig(t), loc)
+	at := time.Now().Add(-time.Hour)
+	recordAt(p, sink, movingFix(10, 20, at))
+	time.Sleep(50 * time.Millisecond)
+
+	// At shutdown.
+	p.release()
+	rows := sink.rows()
+	if len(rows) != 1 {
+		t.Fatalf("wrote %d rows at release, want 1", len(rows))
+	}
+	moved := (rows[0].Location.Latitude - 10) * math.Pi / 180 * earthRadius
+	want := 30 * rows[0].Timestamp.Sub(at).Seconds()
+	if math.Abs(moved-want) > 0.01 || rows[0].Location.Longitude != 20 {
+		t.Errorf("position %.7f,%.7f is %.2f m north of the fix, want %.2f m",
+			rows[0].Location.Latitude, rows[0].Location.Longitude, moved, want)
+	}
+
+	// Once overdue, by the next sighting.
+	p.record(Sighting{Address: "00:11:22:33:44:66", AddressType: addressPublic, RSSI: -60, Type: "BLE"})
+	p.held.mu.Lock()
+	p.held.pending[0].Timestamp = p.held.pending[0].Timestamp.Add(-2 * extrapolationHorizon)
+	p.held.mu.Unlock()
+	rows = recordAt(p, sink, loc.Current())
+	if len(rows) != 1 || rows[0].Address != "00:11:22:33:44:66" {
+		t.Errorf("rows %+v, want only the overdue sighting", rows)
+	}
+
+	// A fix after a long dropout isn't interpolated towards.
+	p.release()
+	sink.rows()
+	recordAt(p, sink, movingFix(10, 20, at))
+	loc.Set(movingFix(11, 20, at.Add(time.Minute)))
+	rows = sink.rows()
+	if len(rows) != 1 || rows[0].Location.Latitude > 10.01 {
+		t.Errorf("rows %+v, want one near the fix it was made with", rows)
+	}
+}
+
+func TestRecordGeofence(t *testing.T) {
+	cfg := testConfig(t,
+		"--geofence-include", "51,-1,52,0",
+		"--geofence-exclude-radius", "51.5,-0.5,1000")
+	p, sink := newTestPipeline(t, cfg, &mockLocation{})
+	for _, tt := range []struct {
+		name     string
+		lat, lon float64
+		write    bool
+	}{
+		{"inside", 51.2, -0.2, true},
+		{"outside the box", 50.5, -0.2, false},
+		{"in the exclusion", 51.5005, -0.5005, false},
+	} {
+		if rows := recordAt(p, sink, testFix(tt.lat, tt.lon)); (len(rows) == 1) != tt.write {
+			t.Errorf("%s: wrote %d rows, want written %v", tt.name, len(rows), tt.write)
+		}
+	}
+	if n := p.geofenced.Load(); n != 2 {
+		t.Errorf("%d sightings counted outside the geofence, want 2", n)
+	}
+	// The first-seen time is kept from the first sighting, wherever it was.
+	devicesMu.Lock()
+	dev := devices["00:11:22:33:44:55"]
+	devicesMu.Unlock()
+	if dev == nil || dev.Sightings != 1 {
+		t.Errorf("device state %+v, want one sighting", dev)
+	}
+}
+
+func TestMockLocationRun(t *testing.T) {
+	m := &mockLocation{fixes: []mockFix{
+		{Location: testFix(1, 2)},
+		{After: 10 * time.Millisecond, Location: testFix(3, 4)},
+		{After: 10 * time.Millisecond, Location: LocationData{}},
+	}}
+	var published []LocationData
+	m.Subscribe(func(loc LocationData) { published = append(published, loc) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Current().Latitude != 3 || m.Current().Fix {
+		if time.Now().After(deadline) {
+			t.Fatalf("script not played, current fix %+v", m.Current())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(published) != 2 {
+		t.Fatalf("%d fixes published, want 2", len(published))
+	}
+	if first := published[0]; first.Latitude != 1 || !first.Fix {
+		t.Errorf("first fix %+v, want the script's", first)
+	}
+	if published[1].Received.IsZero() {
+		t.Error("fix not stamped with the time it was received")
+	}
+}
+
+func TestLocationFeedTPV(t *testing.T) {
+	var f locationFeed
+	var published []LocationData
+	f.Subscribe(func(loc LocationData) { published = append(published, loc) })
+
+	f.tpv(&gpsd.TPVReport{Mode: gpsd.Mode3D, Lat: 1, Lon: 2, Eph: 5})
+	if cur := f.Current(); !cur.Fix || cur.Latitude != 1 || cur.Received.IsZero() {
+		t.Fatalf("current %+v after a fix", cur)
+	}
+
+	// Reports that aren't TPV are ignored, without losing the fix.
+	for _, r := range []any{&gpsd.SKYReport{}, nil, (*gpsd.TPVReport)(nil)} {
+		f.tpv(r)
+		if !f.Current().Fix {
+			t.Fatalf("fix lost to %#v", r)
+		}
+	}
+
+	// Reports without a usable fix lose it, keeping the coordinates.
+	for _, r := range []*gpsd.TPVReport{
+		{Mode: gpsd.NoFix, Lat: math.NaN(), Lon: math.NaN()},
+		{Mode: gpsd.Mode2D, Lat: 95, Lon: 2},
+		{Mode: gpsd.Mode2D, Lat: 1, Lon: math.NaN()},
+	} {
+		f.tpv(&gpsd.TPVReport{Mode: gpsd.Mode3D, Lat: 1, Lon: 2})
+		f.tpv(r)
+		if cur := f.Current(); cur.Fix || cur.Latitude != 1 || cur.Longitude != 2 {
+			t.Errorf("current %+v after %+v, want the last fix, lost", cur, *r)
+		}
+	}
+
+	if len(published) != 4 {
+		t.Errorf("%d fixes published, want 4", len(published))
+	}
+	for _, loc := range published {
+		if !loc.Fix || loc.Latitude != 1 || loc.Longitude != 2 {
+			t.Errorf("published %+v", loc)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFileName is the lock file in the output directory that keeps two
+// copies from scanning into it at once.
+const lockFileName = "wigle-bluetooth.lock"
+
+// errLocked is returned by lockOutputDir when another process holds the
+// lock.
+var errLocked = errors.New("locked by another process")
+
+// lockOutputDir takes an exclusive flock on the lock file at path and
+// writes our PID into it. The lock lasts as long as the returned file is
+// open; the kernel drops it when the process exits, however it exits, so a
+// crash never leaves a stale lock. When another process holds it the error
+// wraps errLocked and names that process if it can.
+func lockOutputDir(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		defer f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			data, _ := os.ReadFile(path)
+			if pid, perr := strconv.Atoi(strings.TrimSpace(string(data))); perr == nil {
+				return nil, fmt.Errorf("%w (PID %d)", errLocked, pid)
+			}
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
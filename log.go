@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logLevel is the lowest level printed; --log-level sets it.
+var logLevel = new(slog.LevelVar)
+
+// logger is where every log line goes. Until setupLogging runs it prints
+// plain text, as the console always has.
+var logger = slog.New(&plainHandler{w: stdout{}, level: logLevel})
+
+// stdout writes to whatever os.Stdout is at the time, so the TUI, which
+// swaps it for a pipe, still collects the log.
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+// parseLogLevel parses a --log-level value.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid --log-level %q: want debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// setupLogging selects the level and format, "text" or "json", of the log.
+func setupLogging(level slog.Level, format string) {
+	logLevel.Set(level)
+	if format == "json" {
+		logger = slog.New(slog.NewJSONHandler(stdout{}, &slog.HandlerOptions{Level: logLevel}))
+	}
+}
+
+func logDebug(format string, args ...any) { logAt(slog.LevelDebug, format, args...) }
+func logInfo(format string, args ...any)  { logAt(slog.LevelInfo, format, args...) }
+func logWarn(format string, args ...any)  { logAt(slog.LevelWarn, format, args...) }
+func logError(format string, args ...any) { logAt(slog.LevelError, format, args...) }
+
+func logAt(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// plainHandler prints just the message, the way the console output has
+// always looked. Warnings and errors are marked as such.
+type plainHandler struct {
+	w     io.Writer
+	level slog.Leveler
+	mu    sync.Mutex
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("ERROR: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("WARNING: ")
+	}
+	b.WriteString(r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	})
+	b.WriteString("\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// Nothing logs with attributes or groups bound in advance.
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *plainHandler) WithGroup(string) slog.Handler      { return h }
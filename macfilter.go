@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// macList is a set of MAC addresses and prefixes such as "AA:BB:CC", given
+// either inline as a comma-separated list or as a file with one entry per
+// line. File-backed lists can be reloaded while running.
+type macList struct {
+	path string // "" for inline lists
+
+	mu      sync.RWMutex
+	entries []string
+}
+
+// newMACList parses source, treating it as a file if one exists at that path.
+func newMACList(source string) (*macList, error) {
+	l := &macList{}
+	if _, err := os.Stat(source); err == nil {
+		l.path = source
+		return l, l.Reload()
+	}
+	l.entries = parseMACEntries(strings.Split(source, ","))
+	if len(l.entries) == 0 {
+		return nil, errors.New("no MAC addresses given")
+	}
+	return l, nil
+}
+
+// Reload re-reads a file-backed list. Inline lists are left alone.
+func (l *macList) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	entries := parseMACEntries(lines)
+	l.mu.Lock()
+	l.entries = entries
+	l.mu.Unlock()
+	return nil
+}
+
+// Match reports whether addr equals or starts with any entry, ignoring case.
+func (l *macList) Match(addr string) bool {
+	addr = strings.ToUpper(addr)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, e := range l.entries {
+		if strings.HasPrefix(addr, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of entries.
+func (l *macList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries)
+}
+
+func parseMACEntries(raw []string) []string {
+	var entries []string
+	for _, e := range raw {
+		e = strings.ToUpper(strings.TrimSpace(e))
+		if e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
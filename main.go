@@ -1,199 +1,1193 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/stratoberry/go-gpsd"
-	"tinygo.org/x/bluetooth"
 )
 
-var adapter = bluetooth.DefaultAdapter
-
 type LocationData struct {
 	Fix       bool
 	Latitude  float64
 	Longitude float64
 	Altitude  float64
-	Error     float64
+	Error     float64   // horizontal error estimate in metres; 0 if unknown
+	Track     float64   // course over ground in degrees from true north
+	Speed     float64   // speed over ground in m/s
+	Time      time.Time // GPS time of the report, zero if not given
+	Received  time.Time // system time the report arrived
+}
+
+// stale reports whether the fix is older than maxAge; 0 never goes stale.
+func (l LocationData) stale(maxAge time.Duration) bool {
+	return maxAge > 0 && time.Since(l.Received) > maxAge
+}
+
+// Sighting is a single logged observation of a device.
+type Sighting struct {
+	Address      string
+	Adapter      string // controller that heard it, e.g. hci0
+	AddressType  string
+	Name         string
+	Vendor       string
+	Class        uint32
+	Capabilities string
+	RSSI         int16
+	SmoothedRSSI float64  // moving average, see rssiSmoother
+	MfgrID       string   // first of MfgrIDs, as WiGLE expects
+	MfgrIDs      []uint16 // every company ID advertised
+	MfgrNames    []string // names for MfgrIDs
+	Type         string
+	FirstSeen    time.Time
+	Timestamp    time.Time
+	TimeFromGPS  bool // Timestamp was corrected by GPS time rather than the system clock
+	Location     LocationData
+	Distance     float64 // estimated metres, 0 if unknown
+	Raw          []byte  // rebuilt advertisement, only kept for --raw-log
+	Backfilled   bool    // seen before the fix it was stamped with
+	Connectable  bool    // advertised a discoverable mode, see connectable
+	AdvFlags     string  // advertised Flags, see describeFlags; "" if none
+	Channel      int     // WiFi only
+	Frequency    int     // WiFi only, MHz
+}
+
+// deviceState is what is remembered about each device address.
+type deviceState struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Sightings int
+	MinRSSI   int16
+	MaxRSSI   int16
+	LastRSSI  int16
+	Name      string
+	Class     uint32
+	Type      string
+
+	// Best is where the strongest sighting, of BestRSSI, was made; Fix is
+	// false until there has been one. Unlike MaxRSSI it carries over from
+	// the previous run.
+	Best     LocationData
+	BestRSSI int16
+
+	// Written holds the last row written per transport (BT/BLE), so a
+	// dual-mode device is deduplicated separately on each.
+	Written map[string]writeMark
+}
+
+// update folds a sighting into the per-device statistics.
+func (d *deviceState) update(s Sighting) {
+	if d.Sightings == 0 {
+		d.MinRSSI, d.MaxRSSI = s.RSSI, s.RSSI
+	}
+	d.LastSeen = s.Timestamp
+	d.Sightings++
+	d.MinRSSI = min(d.MinRSSI, s.RSSI)
+	d.MaxRSSI = max(d.MaxRSSI, s.RSSI)
+	d.LastRSSI = s.RSSI
+	if s.Name != "" {
+		d.Name = s.Name
+	}
+	if s.Class != 0 {
+		d.Class = s.Class
+	}
+	d.Type = s.Type
+	if s.Location.Fix && (!d.Best.Fix || s.RSSI > d.BestRSSI) {
+		d.Best, d.BestRSSI = s.Location, s.RSSI
+	}
+}
+
+type writeMark struct {
+	At   time.Time
+	RSSI float64 // smoothed
 }
 
+// devices tracks every device address observed this session.
 var (
-	currentLocation LocationData
-	locationMu      sync.Mutex
+	devices   = make(map[string]*deviceState)
+	devicesMu sync.Mutex
 )
 
-// firstSeen tracks the first time each device address was observed.
-var firstSeen = make(map[string]time.Time)
-
 func main() {
-	must("enable BLE stack", adapter.Enable())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "update-oui":
+			os.Exit(runUpdateOUI(os.Args[2:]))
+		case "update-companies":
+			os.Exit(runUpdateCompanies(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "merge":
+			os.Exit(runMerge(os.Args[2:]))
+		case "convert":
+			os.Exit(runConvert(os.Args[2:]))
+		case "stats":
+			os.Exit(runStats(os.Args[2:]))
+		}
+	}
 
-	var gps *gpsd.Session
+	cfg, err := parseConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// This is cursed, I'm sorry.
-	must("gpsd dial", func() error {
-		gpsSession, err := gpsd.Dial("localhost:2947")
-		gps = gpsSession
-		return err
-	}())
-
-	tpvFilter := func(r any) {
-		report := r.(*gpsd.TPVReport)
-		fix := report.Mode >= 2
-		locationMu.Lock()
-		currentLocation = LocationData{
-			Fix:       fix,
-			Latitude:  report.Lat,
-			Longitude: report.Lon,
-			Altitude:  report.Alt,
-			Error:     report.Eph,
-		}
-		locationMu.Unlock()
-		fmt.Printf("GPS update: Fix %t Lat %.6f Lon %.6f Alt %.1f m Acc %.1f m\n",
-			fix, report.Lat, report.Lon, report.Alt, report.Eph)
-	}
-
-	gps.AddFilter("TPV", tpvFilter)
-
-	// Connect to system D-Bus for BlueZ device properties.
-	dbusConn, err := dbus.SystemBus()
-	must("connect to system dbus", err)
-
-	// Create CSV in /root/loot/wigle-bluetooth/
-	must("create loot directory", os.MkdirAll("/root/loot/wigle-bluetooth", 0755))
-	csvPath := fmt.Sprintf("/root/loot/wigle-bluetooth/wigle-bluetooth-%s.csv",
-		time.Now().UTC().Format("2006-01-02T150405.000000000-0700"))
-	csvFile, err := os.Create(csvPath)
-	must("create CSV file", err)
-	defer csvFile.Close()
-
-	writer := csv.NewWriter(csvFile)
-	defer writer.Flush()
-
-	// CSV pre-header
-	// Firmware version is first line in /etc/pineapplepager/version
-	// Second line is branch, discard it.
-	versionFile, err := os.ReadFile("/etc/pineapplepager/version")
-	firmwareVersion := "unknown"
-	if err == nil {
-		lines := strings.SplitN(string(versionFile), "\n", 2)
-		if len(lines) > 0 {
-			firmwareVersion = lines[0]
-		}
-	}
-
-	writer.Write([]string{
-		"WigleWifi-1.6", fmt.Sprintf("apprelease=%s", firmwareVersion), "model=pineapplepager",
-		fmt.Sprintf("release=%s", firmwareVersion), "device=pineapplepager", "display=na", "board=na",
-		"brand=Hak5", "star=Sol", "body=3", "subBody=0",
-	})
+	level, _ := parseLogLevel(cfg.LogLevel) // checked by validate
+	if cfg.Verbose {
+		level = slog.LevelDebug
+	}
+	setupLogging(level, cfg.LogFormat)
 
-	// WiGLE Bluetooth CSV header.
-	writer.Write([]string{
-		"MAC", "SSID", "AuthMode", "FirstSeen", "Channel",
-		"Frequency", "RSSI", "CurrentLatitude", "CurrentLongitude",
-		"AltitudeMeters", "AccuracyMeters", "RCOIs", "MfgrId", "Type",
+	// A dry run doesn't even create the output directory.
+	var lock *os.File
+	if !cfg.DryRun {
+		if err := checkOutputDir(cfg.OutputDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Output directory %s is not usable: %v\n", cfg.OutputDir, err)
+			os.Exit(1)
+		}
+		// Two copies would fight over BlueZ discovery and interleave their rows.
+		lockPath := filepath.Join(cfg.OutputDir, lockFileName)
+		lock, err = lockOutputDir(lockPath)
+		switch {
+		case errors.Is(err, errLocked) && cfg.Force:
+			logWarn("%s is %v, carrying on because of --force", cfg.OutputDir, err)
+		case errors.Is(err, errLocked):
+			fmt.Fprintf(os.Stderr, "Another wiglebluetooth is already writing to %s: %s is %v; stop it first or use --force\n",
+				cfg.OutputDir, lockPath, err)
+			os.Exit(1)
+		case err != nil:
+			logWarn("failed to lock %s: %v", lockPath, err)
+		}
+	}
+	if cfg.PIDFile != "" {
+		must("write --pidfile", writePIDFile(cfg.PIDFile))
+	}
+
+	// Parsing the OUI and company tables takes a moment on the Pager; get it out of the
+	// way before the first sighting.
+	go ouiOnce.Do(loadOUI)
+	go companiesOnce.Do(loadCompanies)
+
+	// Panics in callbacks are logged here rather than killing the process.
+	crashPath := filepath.Join(cfg.OutputDir, "wigle-bluetooth-crash.log")
+	if cfg.DryRun {
+		crashPath = ""
+	}
+	guard := newCrashGuard(crashPath, cfg.ExitOnPanic)
+
+	// ctx is cancelled on SIGINT/SIGTERM or when the scanner or gpsd fails.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	// Everything else that ends the session goes through stop.
+	stop := &stopper{cancel: cancel}
+
+	// Connect to system D-Bus for BlueZ device properties. Long-running
+	// users get the connection from bus, which reconnects if it drops.
+	// A replay needs neither, so it runs without BlueZ.
+	bus := &systemBus{}
+	var dbusConn *dbus.Conn
+	var scanners []*leScanner
+	if cfg.Replay == "" {
+		dbusConn, err = bus.Conn()
+		must("connect to system dbus", err)
+
+		for _, id := range cfg.Adapters() {
+			scanner, err := bringUpAdapter(ctx, dbusConn, id, cfg.AdapterWait)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to bring up Bluetooth adapter %s: %v\n", id, err)
+				if ids, lerr := adapterIDs(dbusConn); lerr == nil {
+					fmt.Fprintf(os.Stderr, "Available adapters: %s\n", strings.Join(ids, ", "))
+				}
+				os.Exit(1)
+			}
+			scanners = append(scanners, scanner)
+		}
+	}
+	multiAdapter := len(scanners) > 1
+
+	// The scanners keep device properties up to date from BlueZ's signals,
+	// so the scan callback only needs a map lookup.
+	deviceProps := newPropCache(bus)
+	discoveryFilter, _ := cfg.DiscoveryFilter() // checked by validate
+	passive := cfg.ScanMode == "passive"
+	for _, scanner := range scanners {
+		scanner.props = deviceProps
+		scanner.filter = discoveryFilter
+		scanner.passive = passive
+		if cfg.ScanInterval > 0 {
+			interval := time.Duration(cfg.ScanInterval * float64(time.Millisecond))
+			window := time.Duration(cfg.ScanWindow * float64(time.Millisecond))
+			must("set scan interval and window on "+scanner.id, setScanTiming(scanner.id, passive, interval, window))
+		}
+	}
+	if dbusConn != nil {
+		go deviceProps.Run(ctx)
+	}
+	// With BR/EDR discovery on, results are told apart by transport, see
+	// transportTracker.
+	var transports *transportTracker
+	if dbusConn != nil && (cfg.Classic || cfg.ScanTransport != "le") {
+		transports = newTransportTracker()
+		go func() {
+			if err := transports.Run(ctx); err != nil {
+				logWarn("can't tell BT from BLE results by transport, guessing from the advertised data: %v", err)
+			}
+		}()
+	}
+	// Devices removed from BlueZ keep their FirstSeen in devices.
+	janitor := newJanitor(bus, deviceProps, cfg.DeviceMaxAge)
+	if cfg.DeviceMaxAge > 0 && dbusConn != nil {
+		go janitor.Run(ctx, cfg.Adapters())
+	}
+	var resolver *nameResolver
+	if cfg.ResolveNames {
+		resolver = newNameResolver(bus, cfg.ResolveWorkers, cfg.ResolveCooldown, cfg.ResolveInterval)
+		go resolver.Run(ctx)
+	}
+
+	// The previous run's devices keep their FirstSeen. Privacy mode doesn't
+	// follow devices across sessions, so it neither loads nor saves them. A
+	// dry run loads them but saves nothing.
+	statePath := filepath.Join(cfg.OutputDir, stateFileName)
+	if !cfg.Privacy {
+		if !cfg.Fresh {
+			n, err := loadDeviceState(statePath, cfg.DeviceRetention)
+			if err != nil {
+				logWarn("failed to load device state from %s: %v", statePath, err)
+			} else if n > 0 {
+				logInfo("Loaded %d devices from the previous run", n)
+			}
+		}
+		if !cfg.DryRun {
+			go runStateSaver(ctx, statePath)
+		}
+	}
+
+	// BlueZ remembers devices from earlier sessions with their class and
+	// name, so the first sighting of a known device needn't go without.
+	var known, seeded int
+	for _, scanner := range scanners {
+		props, err := knownDevices(dbusConn, scanner.id)
+		if err != nil {
+			logWarn("failed to list devices known on %s: %v", scanner.id, err)
+			continue
+		}
+		var times map[string]time.Time
+		if cfg.SeedFirstSeen {
+			if times, err = storedDeviceTimes(dbusConn, scanner.id); err != nil {
+				logWarn("failed to read BlueZ storage for %s: %v", scanner.id, err)
+			}
+		}
+		for _, p := range props {
+			deviceProps.Update(scanner.id, p)
+			known++
+			addr, _ := p["Address"].Value().(string)
+			t, ok := times[addr]
+			if !ok {
+				continue
+			}
+			devicesMu.Lock()
+			switch dev := devices[addr]; {
+			case dev == nil:
+				// Eviction counts from now, not from the stored time.
+				devices[addr] = &deviceState{FirstSeen: t, LastSeen: time.Now(), Written: make(map[string]writeMark)}
+				seeded++
+			case t.Before(dev.FirstSeen):
+				dev.FirstSeen = t
+				seeded++
+			}
+			devicesMu.Unlock()
+		}
+	}
+	if known > 0 {
+		msg := fmt.Sprintf("Loaded %d devices known to BlueZ", known)
+		if cfg.SeedFirstSeen {
+			msg += fmt.Sprintf(", %d with a stored first-seen time", seeded)
+		}
+		logInfo("%s", msg)
+	}
+
+	// gpsd runs from here on, so the GPX writer and the pipeline set up
+	// further down are handed over atomically.
+	var gpxTrack atomic.Pointer[gpxWriter]
+	var route atomic.Pointer[trackLog]
+	var interpolator atomic.Pointer[pipeline]
+	clock := newGPSClock()
+	fence := &geofence{include: cfg.GeofenceInclude, exclude: cfg.GeofenceExclude}
+	var priv *privacyFilter
+	if cfg.Privacy {
+		priv = newPrivacyFilter(cfg.PrivacyDecimals)
+	}
+
+	sky := &skyMonitor{minSats: cfg.MinSatellites, maxHDOP: cfg.MaxHDOP}
+	skyFilter := func(r any) {
+		defer guard.Recover("GPS sky handler")
+		report, ok := r.(*gpsd.SKYReport)
+		if !ok {
+			return
+		}
+		sky.Update(report)
+		logDebug("GPS sky: %s", sky)
+	}
+
+	// The service manager is told the payload is ready once the GPS is
+	// connected.
+	gpsReady := make(chan struct{})
+	gpsConnected := sync.OnceFunc(func() { close(gpsReady) })
+	var location LocationProvider
+	var replayLocation *mockLocation
+	fixed, isFixed := cfg.FixedPosition()
+	switch {
+	case isFixed:
+		// A fixed installation never talks to gpsd, and its one "fix"
+		// never goes stale.
+		location = newFixedLocation(fixed)
+		cfg.FixMaxAge = 0
+		gpsConnected()
+		logInfo("Using fixed location %.6f, %.6f", fixed.Latitude, fixed.Longitude)
+	case cfg.Replay != "":
+		// The replay brings its own positions, stamped with the recorded
+		// time, which is bound to be far off the system clock.
+		replayLocation = &mockLocation{}
+		location = replayLocation
+		clock.warned = true
+		gpsConnected()
+	case cfg.NMEA != "":
+		device, baud, _ := parseNMEASource(cfg.NMEA)
+		location = &nmeaSource{device: device, baud: baud, onSKY: skyFilter, onReady: gpsConnected}
+	default:
+		location = &gpsClient{
+			addr:    cfg.GPSD,
+			retries: cfg.GPSDRetries,
+			backoff: cfg.GPSDBackoff,
+			timeout: cfg.GPSDTimeout,
+			onSKY:   skyFilter,
+			onReady: gpsConnected,
+		}
+	}
+
+	location.Subscribe(func(loc LocationData) {
+		defer guard.Recover("GPS report handler")
+		if !loc.Time.IsZero() {
+			clock.Sync(loc.Time)
+		}
+		if p := interpolator.Load(); p != nil {
+			p.fix(loc)
+		}
+		// Positions outside the geofence are kept out of the track and
+		// the log alike.
+		if !fence.Allows(loc) {
+			logDebug("GPS update: outside the geofence")
+			return
+		}
+		now, _ := clock.Now()
+		if gpx := gpxTrack.Load(); gpx != nil {
+			pt := loc
+			if priv != nil {
+				pt = priv.Location(loc)
+			}
+			if err := gpx.AddTrackPoint(pt, now); err != nil {
+				logWarn("failed to write GPX track point: %v", err)
+			}
+		}
+		if t := route.Load(); t != nil {
+			if err := t.Add(loc, now); err != nil {
+				logWarn("failed to write track point: %v", err)
+			}
+		}
+		logDebug("GPS update: Lat %.6f Lon %.6f Alt %.1f m Acc %.1f m",
+			loc.Latitude, loc.Longitude, loc.Altitude, loc.Error)
 	})
-	writer.Flush()
 
-	fmt.Println("Writing to", csvPath)
+	// Scanning starts once the outputs are open; sightings are skipped
+	// until there is a fix.
+	gpsErr := make(chan error, 1)
+	go func() {
+		if err := location.Run(ctx); err != nil && ctx.Err() == nil {
+			gpsErr <- err
+		}
+	}()
+	if !isFixed && cfg.Replay == "" {
+		// Name the outputs by GPS time if there is a fix within a few seconds.
+		clock.Wait(ctx, gpsTimeWait)
+	}
+	startTime, _ := clock.Now()
+	outputBase := filepath.Join(cfg.OutputDir, expandFilenameTemplate(cfg.FilenameTemplate, cfg.FilenameTimeFormat, startTime,
+		strings.Join(cfg.Adapters(), "+")))
+	sinks := newTeeSink()
+
+	// A dry run opens no outputs at all, so it creates no files.
+	var uploader *wigleUploader
+	var csvOut *wigleCSV
+	var bestOut *bestCSV
+	if cfg.DryRun {
+		logWarn("DRY RUN: scanning without writing anything")
+	} else {
+		if cfg.WigleUpload {
+			uploader = newWigleUploader(cfg.WigleAPIName, cfg.WigleAPIToken)
+		}
+
+		// The raw CSV gets a row per sighting; the best CSV one row per
+		// device, written at shutdown and whenever the raw CSV rotates. Only
+		// one of them is uploaded to WiGLE, preferring the raw stream.
+		if cfg.DedupeOutput != "best" {
+			csvOut, err = newWigleCSV(outputBase, cfg.Compress, cfg.Precision(), int64(cfg.RotateSize), cfg.RotateInterval)
+			must("create CSV file", err)
+			sinks.Add("CSV", csvOut)
+			logInfo("Writing to %s", csvOut.Path())
 
-	gps.Watch()
+			if uploader != nil {
+				must("queue CSV for WiGLE upload", uploader.markPending(csvOut.Path()))
+				go uploader.uploadPending(cfg.OutputDir, csvOut.Path())
 
-	err = adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
-		locationMu.Lock()
-		loc := currentLocation
-		locationMu.Unlock()
+				csvOut.onRotate = func(finished, next string) {
+					if err := uploader.markPending(next); err != nil {
+						logWarn("failed to queue CSV for WiGLE upload: %v", err)
+					}
+					go uploader.uploadFinished(finished)
+				}
+			}
+		}
+
+		if cfg.DedupeOutput != "raw" {
+			bestOut = newBestCSV(outputBase+"-best", cfg.Compress, cfg.Precision())
+			sinks.Add("best CSV", bestOut)
+			if csvOut == nil {
+				logInfo("Writing the best sighting per device at shutdown")
+			} else {
+				logInfo("Writing the best sighting per device at each CSV rotation and at shutdown")
+				// Each raw file gets a best file for the same stretch. The
+				// best CSV has its own queue, which is written out first so
+				// no sighting from the finished raw file ends up in the next
+				// best file. Sightings queued after the one that rotated the
+				// raw file may still make it into this one.
+				uploadRotated := csvOut.onRotate
+				csvOut.onRotate = func(finished, next string) {
+					if uploadRotated != nil {
+						uploadRotated(finished, next)
+					}
+					if err := sinks.FlushSink(bestOut); err != nil {
+						logWarn("failed to flush best CSV: %v", err)
+					}
+					if err := bestOut.Rotate(); err != nil {
+						logWarn("failed to write best CSV: %v", err)
+					}
+				}
+			}
+
+			if uploader != nil && csvOut == nil {
+				go uploader.uploadPending(cfg.OutputDir, "")
+			}
+		}
+
+		if cfg.KML {
+			kmlPath := outputBase + ".kml"
+			kml, err := newKMLWriter(kmlPath)
+			must("create KML file", err)
+			sinks.Add("KML", kml)
+			logInfo("Writing to %s", kmlPath)
+		}
+
+		if cfg.SQLite != "" {
+			db, err := newSQLiteWriter(cfg.SQLite)
+			must("open SQLite database", err)
+			sinks.Add("SQLite", db)
+			logInfo("Writing to %s", cfg.SQLite)
+		}
+
+		if cfg.JSONL != "" {
+			jsonl, err := newJSONLWriter(cfg.JSONL)
+			must("open JSON Lines file", err)
+			sinks.Add("JSON Lines", jsonl)
+			logInfo("Writing to %s", cfg.JSONL)
+		}
+
+		if cfg.GeoJSON {
+			geojsonPath := outputBase + ".geojson"
+			geojson, err := newGeoJSONWriter(geojsonPath)
+			must("create GeoJSON file", err)
+			sinks.Add("GeoJSON", geojson)
+			logInfo("Writing to %s", geojsonPath)
+		}
+
+		if cfg.MQTTBroker != "" {
+			sinks.Add("MQTT", newMQTTPublisher(ctx, cfg.MQTTBroker, cfg.MQTTTopic, cfg.MQTTUser, cfg.MQTTPass))
+			logInfo("Publishing to MQTT topic %s on %s", cfg.MQTTTopic, cfg.MQTTBroker)
+		}
+
+		if cfg.PostURL != "" {
+			poster, err := newHTTPPoster(ctx, cfg.PostURL, cfg.PostAuth, cfg.PostSpool)
+			must("create POST spool directory", err)
+			sinks.Add("HTTP POST", poster)
+			logInfo("Posting sightings to %s", cfg.PostURL)
+		}
+
+		if cfg.RawLog != "" {
+			rawLog, err := newRawLogWriter(cfg.RawLog)
+			must("open raw advertisement log", err)
+			sinks.Add("raw log", rawLog)
+			logInfo("Writing raw advertisements to %s", cfg.RawLog)
+		}
+
+		if cfg.GPX {
+			gpxPath := outputBase + ".gpx"
+			gpx, err := newGPXWriter(gpxPath)
+			must("create GPX file", err)
+			sinks.Add("GPX", gpx)
+			gpxTrack.Store(gpx)
+			logInfo("Writing to %s", gpxPath)
+		}
+	}
+
+	var webHub *eventHub
+	if cfg.HTTP != "" {
+		webHub = newEventHub()
+		sinks.Add("web map", webHub)
+	}
+	// All sinks are added by now.
+	guard.FlushWith(sinks.Flush)
+
+	// Disk space is watched from here on; the first check runs at once.
+	var space *spaceMonitor
+	if cfg.MinFreeSpace > 0 && !cfg.DryRun {
+		space = newSpaceMonitor(cfg.OutputDir, int64(cfg.MinFreeSpace), cfg.LowSpaceAction, csvOut)
+		go space.Run(ctx)
+	}
 
-		if !loc.Fix {
-			fmt.Println("No GPS fix, skipping device:", device.Address.String())
+	if cfg.Track != "" && !cfg.DryRun {
+		t, err := newTrackLog(outputBase, cfg.Track, cfg.TrackMinDistance)
+		must("create track file", err)
+		t.privacy = priv
+		route.Store(t)
+		logInfo("Writing route to %s", t.Path())
+	}
+
+	var ignoreMACs, onlyMACs *macList
+	if cfg.IgnoreMACs != "" {
+		ignoreMACs, err = newMACList(cfg.IgnoreMACs)
+		must("load --ignore-macs", err)
+		logInfo("Ignoring %d MAC addresses/prefixes", ignoreMACs.Len())
+	}
+	if cfg.OnlyMACs != "" {
+		onlyMACs, err = newMACList(cfg.OnlyMACs)
+		must("load --only-macs", err)
+		logInfo("Only logging %d MAC addresses/prefixes", onlyMACs.Len())
+	}
+	if (ignoreMACs != nil && ignoreMACs.path != "") || (onlyMACs != nil && onlyMACs.path != "") {
+		// Re-read the list files on SIGHUP so entries can be added mid-capture.
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				for _, l := range []*macList{ignoreMACs, onlyMACs} {
+					if l == nil || l.path == "" {
+						continue
+					}
+					if err := l.Reload(); err != nil {
+						logWarn("failed to reload %s: %v", l.path, err)
+						continue
+					}
+					logInfo("Reloaded %d entries from %s", l.Len(), l.path)
+				}
+			}
+		}()
+	}
+
+	var selfMACs *macList
+	if !cfg.IncludeSelf && dbusConn != nil {
+		addrs, err := ownAddresses(dbusConn)
+		if err != nil {
+			logWarn("failed to list own Bluetooth adapters and devices: %v", err)
+		} else if len(addrs) > 0 {
+			slices.Sort(addrs)
+			selfMACs = &macList{entries: parseMACEntries(addrs)}
+			logInfo("Excluding own adapters and paired/connected devices: %s", strings.Join(addrs, ", "))
+		}
+	}
+
+	// wanted applies --ignore-macs, --only-macs and the own-device exclusion.
+	wanted := func(addr string) bool {
+		if selfMACs != nil && selfMACs.Match(addr) {
+			return false
+		}
+		if ignoreMACs != nil && ignoreMACs.Match(addr) {
+			return false
+		}
+		return onlyMACs == nil || onlyMACs.Match(addr)
+	}
+
+	smoother := newRSSISmoother(cfg.RSSIAlpha)
+	evictor := &deviceEvictor{
+		retention:  cfg.DeviceRetention,
+		maxEntries: cfg.MaxTracked,
+		now: func() time.Time {
+			t, _ := clock.Now()
+			return t
+		},
+		smoother: smoother,
+		resolver: resolver,
+	}
+	go evictor.Run(ctx)
+
+	var follow *follower
+	if cfg.Follow != "" {
+		follow = newFollower(cfg.Follow)
+		go follow.Run(ctx)
+	}
+
+	hasFix := func() bool {
+		loc := location.Current()
+		return loc.Fix && !loc.stale(cfg.FixMaxAge)
+	}
+	// SIGUSR1 pauses and resumes scanning, e.g. while at home, without
+	// starting new files.
+	pause := newPauseSwitch()
+	togglePause := func() {
+		if !pause.Toggle() {
+			logInfo("Scanning resumed")
 			return
 		}
+		if err := sinks.Flush(); err != nil {
+			logWarn("failed to flush outputs: %v", err)
+		}
+		logInfo("Scanning paused, send SIGUSR1 again to resume")
+	}
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			togglePause()
+		}
+	}()
 
-		addr := device.Address.String()
-		now := time.Now().UTC()
+	duty, _ := parseDutyCycle(cfg.DutyCycle) // checked by validate
+	if duty != nil {
+		logInfo("Duty cycle: scanning for %s, then resting for %s", duty.on, duty.off)
+		// SIGUSR2 ends a rest early, e.g. when something of interest is
+		// nearby.
+		usr2 := make(chan os.Signal, 1)
+		signal.Notify(usr2, syscall.SIGUSR2)
+		go func() {
+			for range usr2 {
+				logInfo("Scan burst requested")
+				duty.Burst()
+			}
+		}()
+	}
+	watchdogs := make([]*scanWatchdog, len(scanners))
+	for i, scanner := range scanners {
+		watchdogs[i] = &scanWatchdog{
+			scanner:    scanner,
+			timeout:    cfg.ScanWatchdog,
+			powerCycle: cfg.WatchdogPower,
+			bus:        bus,
+			hasFix:     hasFix,
+			duty:       duty,
+			pause:      pause,
+		}
+	}
 
-		// Track first-seen time.
-		if _, seen := firstSeen[addr]; !seen {
-			firstSeen[addr] = now
+	// The pipeline turns what is heard into rows.
+	pipe := &pipeline{
+		cfg:          cfg,
+		location:     location,
+		clock:        clock,
+		sinks:        sinks,
+		guard:        guard,
+		stop:         stop,
+		pause:        pause,
+		smoother:     smoother,
+		fence:        fence,
+		props:        deviceProps,
+		janitor:      janitor,
+		wanted:       wanted,
+		transports:   transports,
+		resolver:     resolver,
+		follow:       follow,
+		priv:         priv,
+		space:        space,
+		multiAdapter: multiAdapter,
+	}
+	if cfg.BackfillWindow > 0 {
+		pipe.backfill = newBackfillBuffer(cfg.BackfillWindow)
+	}
+	if cfg.Interpolate {
+		interpolator.Store(pipe)
+	}
+
+	start := time.Now()
+	if cfg.Duration > 0 {
+		time.AfterFunc(cfg.Duration, func() {
+			stop.Stop(fmt.Sprintf("--duration %s reached", cfg.Duration))
+		})
+	}
+
+	// Each adapter scans on its own; one failing leaves the others running.
+	var scanWG sync.WaitGroup
+	for _, w := range watchdogs {
+		scanWG.Add(1)
+		go func() {
+			defer scanWG.Done()
+			defer w.Close()
+			if err := pipe.Scan(ctx, w); err != nil {
+				logWarn("scan on %s stopped: %v", w.ID(), err)
+			}
+		}()
+	}
+	scansDone := make(chan struct{})
+	go func() {
+		scanWG.Wait()
+		close(scansDone)
+	}()
+	// A replay has no scans to die.
+	scansFailed := scansDone
+	if cfg.Replay != "" {
+		scansFailed = nil
+	}
+
+	var classicWG sync.WaitGroup
+	if cfg.Classic {
+		for _, id := range cfg.Adapters() {
+			classic, err := newClassicScanner(id, pause)
+			must("connect to system dbus for classic discovery", err)
+			classicWG.Add(1)
+			go func() {
+				defer classicWG.Done()
+				err := classic.Run(ctx, func(addr, name string, class uint32, rssi int16) {
+					pipe.inquiryResult(id, addr, name, class, rssi)
+				})
+				if err != nil {
+					logWarn("classic discovery on %s stopped: %v", id, err)
+				}
+			}()
 		}
+	}
 
-		// Get device class from BlueZ over D-Bus.
-		deviceClass := getDeviceClass(dbusConn, addr)
+	if cfg.WiFi != "" {
+		wifi := &wifiScanner{iface: cfg.WiFi, interval: cfg.WiFiInterval, pause: pause}
+		classicWG.Add(1)
+		go func() {
+			defer classicWG.Done()
+			wifi.Run(ctx, pipe.network)
+		}()
+	}
 
-		// Build capabilities string.
-		capabilities := buildCapabilities(deviceClass)
+	// A replay stands in for the scanners and the GPS, and ends the
+	// session when the file does.
+	var replay *replaySource
+	replayErr := make(chan error, 1)
+	if cfg.Replay != "" {
+		replay = &replaySource{path: cfg.Replay, speed: cfg.ReplaySpeed, location: replayLocation, pause: pause}
+		logInfo("Replaying %s", cfg.Replay)
+		classicWG.Add(1)
+		go func() {
+			defer classicWG.Done()
+			err := replay.Run(ctx, pipe.replayed)
+			if err != nil {
+				replayErr <- err
+				return
+			}
+			stop.Stop("end of replay")
+		}()
+	}
 
-		// Mask to major+minor class bits only (matches Android's getDeviceClass()).
-		deviceTypeCode := deviceClass & 0x1FFC
+	var screen *tui
+	if cfg.TUI {
+		screen = &tui{
+			devices: func() []tuiDevice {
+				devicesMu.Lock()
+				defer devicesMu.Unlock()
+				rows := make([]tuiDevice, 0, len(devices))
+				for addr, d := range devices {
+					if d.Sightings == 0 {
+						continue
+					}
+					row := tuiDevice{MAC: addr, Name: d.Name, Type: d.Type, RSSI: d.LastRSSI,
+						Count: d.Sightings, LastSeen: d.LastSeen}
+					if priv != nil {
+						row.MAC, row.Name = priv.MAC(addr), deviceTypeLegend(d.Class&0x1FFC)
+					}
+					rows = append(rows, row)
+				}
+				return rows
+			},
+			status: func() string {
+				loc := location.Current()
+				gps := "no fix"
+				if loc.Fix {
+					gps = fmt.Sprintf("fix ±%.0f m", loc.Error)
+				}
+				state := "scanning"
+				if pause.Paused() {
+					state = "PAUSED"
+				}
+				return fmt.Sprintf("GPS %s, %s | %d rows written, %d skipped | %s",
+					gps, sky, pipe.rowsWritten.Load(), pipe.Skipped(), state)
+			},
+			pause: togglePause,
+			quit:  func() { stop.Stop("quit from the TUI") },
+		}
+		must("start --tui", screen.Start())
+		go screen.Run(ctx)
+	}
 
-		// Extract manufacturer ID (first one found, or blank).
-		mfgrID := ""
-		for _, md := range device.AdvertisementPayload.ManufacturerData() {
-			mfgrID = fmt.Sprintf("%d", md.CompanyID)
-			break
+	var web *webServer
+	if cfg.HTTP != "" {
+		web = &webServer{
+			token:   cfg.HTTPToken,
+			hub:     webHub,
+			started: start,
+			position: func() (LocationData, bool) {
+				loc := location.Current()
+				if !loc.Fix || loc.stale(cfg.FixMaxAge) || !fence.Allows(loc) {
+					return LocationData{}, false
+				}
+				if priv != nil {
+					loc = priv.Location(loc)
+				}
+				return loc, true
+			},
+			status: func() webStatus {
+				var st webStatus
+				st.GPS.SatellitesUsed, st.GPS.SatellitesVisible, st.GPS.HDOP = sky.Counts()
+				for _, w := range watchdogs {
+					a := webAdapter{ID: w.scanner.ID(), State: "scanning", Restarts: w.Restarts()}
+					if w.resting.Load() {
+						a.State = "resting"
+					}
+					st.Scan.Adapters = append(st.Scan.Adapters, a)
+				}
+				devicesMu.Lock()
+				for _, d := range devices {
+					if d.Sightings > 0 {
+						st.UniqueDevices++
+					}
+				}
+				devicesMu.Unlock()
+				st.RowsWritten = pipe.rowsWritten.Load()
+				st.Skipped = map[string]uint64{
+					"no_fix":         pipe.noFix.Load(),
+					"stale_fix":      pipe.staleFix.Load(),
+					"inaccurate_fix": pipe.inaccurateFix.Load(),
+					"speed":          pipe.speedFiltered.Load(),
+					"geofence":       pipe.geofenced.Load(),
+					"rssi":           pipe.rssiFiltered.Load(),
+					"dedup":          pipe.suppressed.Load(),
+				}
+				if csvOut != nil {
+					st.OutputFile = csvOut.Path()
+				}
+				if space != nil {
+					if free := space.Free(); free >= 0 {
+						st.FreeBytes = &free
+					}
+					st.Scan.OutputStopped = space.Stopped()
+				}
+				return st
+			},
+			devices: func() []deviceSummary {
+				summary := summarizeDevices()
+				if priv != nil {
+					for i := range summary {
+						summary[i].MAC = priv.MAC(summary[i].MAC)
+						summary[i].Name = summary[i].Legend
+					}
+				}
+				return summary
+			},
+			pause:  togglePause,
+			paused: pause.Paused,
 		}
+		must("start --http", web.Start(ctx, cfg.HTTP))
+		logInfo("Serving the live map on %s", cfg.HTTP)
+	}
 
-		row := []string{
-			addr,               // MAC / BD_ADDR
-			device.LocalName(), // SSID / Device Name
-			capabilities,       // AuthMode / Capabilities
-			firstSeen[addr].Format("2006-01-02 15:04:05"), // FirstSeen
-			"0",                                  // Channel
-			fmt.Sprintf("%d", deviceTypeCode),    // Frequency / Device Type code
-			fmt.Sprintf("%d", device.RSSI),       // RSSI
-			fmt.Sprintf("%f", loc.Latitude),      // Latitude
-			fmt.Sprintf("%f", loc.Longitude),     // Longitude
-			fmt.Sprintf("%d", int(loc.Altitude)), // Altitude
-			fmt.Sprintf("%f", loc.Error),         // Accuracy
-			"",                                   // RCOIs (blank)
-			mfgrID,                               // MfgrId
-			"BLE",                                // Type
+	status := func() statusSnapshot {
+		loc := location.Current()
+		st := statusSnapshot{
+			Rows:      pipe.rowsWritten.Load(),
+			Sightings: pipe.sightings.Load(),
+			NoFix:     pipe.noFix.Load(),
+			Fix:       loc.Fix && !loc.stale(cfg.FixMaxAge),
+			Accuracy:  loc.Error,
+			Free:      -1,
 		}
+		st.SatellitesUsed, st.SatellitesVisible, st.HDOP = sky.Counts()
+		if space != nil {
+			st.Free = space.Free()
+		}
+		devicesMu.Lock()
+		for _, d := range devices {
+			if d.Sightings > 0 {
+				st.Devices++
+			}
+		}
+		devicesMu.Unlock()
+		if csvOut != nil {
+			st.File = csvOut.Path()
+		}
+		return st
+	}
+	// The TUI has a status line of its own.
+	if cfg.StatusInterval > 0 && !cfg.TUI {
+		go runStatusLine(ctx, cfg.StatusInterval, status)
+	}
+	// The adapters are up and scanning by now.
+	go runServiceNotifier(ctx, gpsReady, status)
 
-		writer.Write(row)
-		writer.Flush()
+	// A CSV that can't be written any more makes the whole run pointless.
+	var csvFailed <-chan struct{}
+	if csvOut != nil {
+		csvFailed = csvOut.Failed()
+	}
 
-		fmt.Printf("Found device: %s (%s) Class: 0x%06X Capabilities: %s\n",
-			addr, device.LocalName(), deviceClass, capabilities)
-	})
-	if err != nil {
-		fmt.Println("failed to start scan:", err)
+	// Block until asked to stop, until every scan has died, until gpsd
+	// does or until the CSV can't be written. The scans must have returned
+	// before the sinks are closed.
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		// Stopped by a signal unless stop was called.
+		stop.Stop("interrupted")
+		logInfo("Shutting down: %s", stop.Reason())
+	case <-scansFailed:
+		stop.Stop("no adapter is scanning any more")
+		logError("%s", stop.Reason())
+		exitCode = 1
+	case err := <-gpsErr:
+		stop.Stop("giving up on gpsd: " + err.Error())
+		logError("%s", stop.Reason())
+		exitCode = 1
+	case err := <-replayErr:
+		stop.Stop("replay failed: " + err.Error())
+		logError("%s", stop.Reason())
+		exitCode = 1
+	case <-guard.Crashed():
+		stop.Stop("panic (--exit-on-panic)")
+		logError("shutting down after a panic (--exit-on-panic)")
+		exitCode = 1
+	case <-csvFailed:
+		stop.Stop("the CSV can't be written")
+		logError("shutting down because the CSV can't be written")
+		exitCode = 1
+	}
+	if screen != nil {
+		screen.Stop()
 	}
+	if web != nil {
+		web.Close()
+	}
+	<-scansDone
+	classicWG.Wait()
 
-	for {
+	if p := interpolator.Swap(nil); p != nil {
+		p.release()
+	}
+	// The GPX writer is one of the sinks; a fix arriving from now on
+	// mustn't reach it.
+	gpxTrack.Swap(nil)
+	if err := sinks.Close(); err != nil {
+		logWarn("failed to close outputs: %v", err)
+	}
+	if !cfg.Privacy && !cfg.DryRun {
+		if err := saveDeviceState(statePath); err != nil {
+			logWarn("failed to save device state: %v", err)
+		}
+	}
+	if t := route.Swap(nil); t != nil {
+		if err := t.Close(); err != nil {
+			logWarn("failed to close track file: %v", err)
+		}
+		logInfo("%s", t.Summary())
+	}
+	var rows uint64
+	if csvOut != nil {
+		rows = csvOut.Rows()
+		if uploader != nil {
+			uploader.uploadFinished(csvOut.Path())
+		}
+	}
+	if bestOut != nil && bestOut.Path() != "" {
+		logInfo("Wrote %d devices to %s", bestOut.Rows(), bestOut.Path())
+		if csvOut == nil {
+			rows = bestOut.Rows()
+			if uploader != nil {
+				if err := uploader.markPending(bestOut.Path()); err != nil {
+					logWarn("failed to queue CSV for WiGLE upload: %v", err)
+				}
+				uploader.uploadFinished(bestOut.Path())
+			}
+		}
 	}
-}
 
-// getDeviceClass queries BlueZ via D-Bus for the device's Class of Device value.
-func getDeviceClass(conn *dbus.Conn, addr string) uint32 {
-	sanitized := strings.ReplaceAll(addr, ":", "_")
-	path := dbus.ObjectPath("/org/bluez/hci0/dev_" + sanitized)
-	obj := conn.Object("org.bluez", path)
+	summary := summarizeDevices()
+	named, bleDevices := namedDevices(summary, "BLE")
+	if priv != nil {
+		for i := range summary {
+			summary[i].MAC = priv.MAC(summary[i].MAC)
+			summary[i].Name = summary[i].Legend
+		}
+	}
+	printDeviceSummary(summary, 20)
+	if cfg.DryRun {
+		rows = pipe.rowsWritten.Load()
+		logWarn("DRY RUN: nothing was written, this session is not saved anywhere")
+		logInfo("Session summary (dry run): %d unique devices, %d rows would have been written, duration %s, stopped: %s",
+			len(summary), rows, time.Since(start).Round(time.Second), stop.Reason())
+	} else {
+		summaryPath := outputBase + "-summary.json"
+		err = writeDeviceSummary(summaryPath, sessionSummary{
+			StopReason: stop.Reason(),
+			Started:    start,
+			Duration:   time.Since(start).Seconds(),
+			Rows:       rows,
+			Devices:    summary,
+		})
+		if err != nil {
+			logWarn("failed to write device summary: %v", err)
+		} else {
+			logInfo("Wrote device summary to %s", summaryPath)
+		}
 
-	v, err := obj.GetProperty("org.bluez.Device1.Class")
-	if err == nil {
-		if class, ok := v.Value().(uint32); ok {
-			return class
+		logInfo("Session summary: %d unique devices, %d rows written, duration %s, stopped: %s",
+			len(summary), rows, time.Since(start).Round(time.Second), stop.Reason())
+	}
+	if n := evictor.Evicted(); n > 0 {
+		logInfo("%d devices unseen for --device-retention or beyond --max-tracked-devices were evicted and aren't summarised", n)
+	}
+	if priv != nil {
+		logInfo("%s", priv)
+	}
+	if bleDevices > 0 {
+		// Scan responses carry many devices' names, so this is the main
+		// thing --scan-mode trades for power and stealth.
+		logInfo("Names resolved for %d of %d BLE devices (%.0f%%), %d blank, scanning %s",
+			named, bleDevices, 100*float64(named)/float64(bleDevices), bleDevices-named, cfg.ScanMode)
+	}
+	if hits, misses := deviceProps.Stats(); hits+misses > 0 {
+		logInfo("Device property cache: %d hits, %d misses", hits, misses)
+	}
+	if resolver != nil {
+		attempts, resolved := resolver.Stats()
+		logInfo("Names resolved over GATT for %d of %d devices connected to", resolved, attempts)
+	}
+	if webHub != nil {
+		_, connections, dropped := webHub.Stats()
+		logInfo("%d HTTP stream connections, %d events dropped for slow clients", connections, dropped)
+	}
+	if csvOut != nil {
+		if n := csvOut.Lost(); n > 0 {
+			logWarn("%d CSV rows lost to write errors", n)
 		}
 	}
-	return 0
+	if n := pipe.lowSpace.Load(); n > 0 {
+		logWarn("%d sightings not written for lack of disk space", n)
+	}
+	if space != nil && space.Deleted() > 0 {
+		logWarn("%d old CSV files deleted to free space", space.Deleted())
+	}
+	if n := janitor.Removed(); n > 0 {
+		logInfo("%d stale devices removed from BlueZ", n)
+	}
+	if n := guard.Count(); n > 0 {
+		if guard.path != "" {
+			logWarn("%d panics recovered, see %s", n, guard.path)
+		} else {
+			logWarn("%d panics recovered", n)
+		}
+	}
+	if n := pipe.suppressed.Load(); n > 0 {
+		logInfo("%d repeat sightings suppressed by --dedup-interval", n)
+	}
+	if n := pipe.backfilled.Load(); n > 0 {
+		logInfo("%d sightings made before a fix back-filled with its position", n)
+	}
+	if pipe.backfill != nil {
+		if n := pipe.backfill.Dropped(); n > 0 {
+			logInfo("%d sightings waiting for a fix discarded", n)
+		}
+	}
+	if n := pipe.noFix.Load(); n > 0 {
+		logInfo("%d sightings skipped without a GPS fix", n)
+	}
+	if n := pipe.staleFix.Load(); n > 0 {
+		logInfo("%d sightings skipped because the GPS fix was older than %s", n, cfg.FixMaxAge)
+	}
+	if n := pipe.inaccurateFix.Load(); n > 0 {
+		logInfo("%d sightings skipped because the GPS error was above %.0f m or unknown", n, cfg.MaxAccuracy)
+	}
+	if n := pipe.speedFiltered.Load(); n > 0 {
+		logInfo("%d sightings skipped by --only-moving/--only-stationary", n)
+	}
+	if n := pipe.geofenced.Load(); n > 0 {
+		logInfo("%d sightings skipped outside the geofence", n)
+	}
+	if n := pipe.rssiFiltered.Load(); n > 0 {
+		logInfo("%d sightings below %d dBm dropped", n, cfg.MinRSSI)
+	}
+	var restarts uint64
+	for _, w := range watchdogs {
+		restarts += w.Restarts()
+	}
+	if n := restarts; n > 0 {
+		logInfo("Scan restarted %d times by the watchdog", n)
+	}
+	if replay != nil {
+		logInfo("Replayed %d records from %s", replay.Records(), cfg.Replay)
+	}
+	if n := pipe.enAddresses.Len(); n > 0 {
+		logInfo("Exposure Notification beacons seen from %d addresses", n)
+	}
+	if cfg.PIDFile != "" {
+		os.Remove(cfg.PIDFile)
+	}
+	if lock != nil {
+		lock.Close()
+	}
+	os.Exit(exitCode)
+}
+
+// getDeviceProperties fetches BlueZ's Device1 properties for a device in one
+// D-Bus call. It returns nil if BlueZ doesn't know the device.
+func getDeviceProperties(conn *dbus.Conn, adapterID, addr string) map[string]dbus.Variant {
+	obj := conn.Object("org.bluez", devicePath(adapterID, addr))
+
+	var props map[string]dbus.Variant
+	if err := callTimeout(obj, dbusTimeout, "org.freedesktop.DBus.Properties.GetAll", "org.bluez.Device1").Store(&props); err != nil {
+		// Usually the device has just been removed.
+		logDebug("failed to read properties of %s on %s: %v", addr, adapterID, err)
+		return nil
+	}
+	return props
 }
 
 // buildCapabilities returns a WiGLE-style capabilities string from the
 // Bluetooth Class of Device, matching the Android app's DEVICE_TYPE_LEGEND.
 // Uses getDeviceClass() equivalent: (class & 0x1FFC) for major+minor lookup.
-func buildCapabilities(class uint32) string {
+func buildCapabilities(class uint32, le bool) string {
 	deviceClass := class & 0x1FFC
 	name := deviceTypeLegend(deviceClass)
 
 	// Append [LE] for BLE scan type, matching WiGLE convention.
+	if !le {
+		return name
+	}
 	if name != "" {
 		return name + " [LE]"
 	}
@@ -331,6 +1325,19 @@ func deviceTypeLegend(deviceClass uint32) string {
 	}
 }
 
+// checkOutputDir creates dir if needed and verifies files can be created in it.
+func checkOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 func must(action string, err error) {
 	if err != nil {
 		panic("failed to " + action + ": " + err.Error())
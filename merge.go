@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// sqliteMagic starts every SQLite database file.
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// sqliteSelectDevices reads the devices table, which already holds one row
+// per device merged across sessions. It has no device class or channel, so
+// those columns come out as 0.
+const sqliteSelectDevices = `
+SELECT mac, name, capabilities, first_seen, mfgr_id, type,
+	best_rssi, best_lat, best_lon, best_alt, best_accuracy
+FROM devices`
+
+// mergedDevices dedupes records per device, keeping the earliest FirstSeen
+// and the strongest observation, as the SQLite devices table does.
+type mergedDevices map[string]*wigle.Record // keyed by type and address
+
+func (m mergedDevices) add(r wigle.Record) {
+	r.SSID = sanitizeName(r.SSID)
+	key := r.Type + " " + strings.ToUpper(r.MAC)
+	prev, ok := m[key]
+	if !ok {
+		m[key] = &r
+		return
+	}
+	best, other := *prev, r
+	if r.RSSI > prev.RSSI {
+		best, other = r, *prev
+	}
+	if other.FirstSeen.Before(best.FirstSeen) {
+		best.FirstSeen = other.FirstSeen
+	}
+	if best.SSID == "" {
+		best.SSID = other.SSID
+	}
+	if best.MfgrID == "" {
+		best.MfgrID = other.MfgrID
+	}
+	*prev = best
+}
+
+// sorted returns the devices in the order they were first seen.
+func (m mergedDevices) sorted() []*wigle.Record {
+	records := make([]*wigle.Record, 0, len(m))
+	for _, r := range m {
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b *wigle.Record) int {
+		return cmp.Or(a.FirstSeen.Compare(b.FirstSeen), cmp.Compare(a.MAC, b.MAC))
+	})
+	return records
+}
+
+// isSQLite reports whether path is a SQLite database rather than a CSV.
+func isSQLite(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(sqliteMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(magic, sqliteMagic), nil
+}
+
+// mergeCSV streams the rows of a WiGLE CSV file into m. Rows that can't be
+// read are reported and skipped. It returns the pre-header and the number
+// of rows merged and skipped.
+func mergeCSV(m mergedDevices, path string) (info wigle.DeviceInfo, rows, skipped int, err error) {
+	f, err := openCapture(path)
+	if err != nil {
+		return info, 0, 0, err
+	}
+	defer f.Close()
+
+	r := wigle.NewReader(f)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return r.DeviceInfo(), rows, skipped, nil
+		}
+		var re *wigle.RowError
+		if errors.As(err, &re) {
+			fmt.Fprintf(os.Stderr, "merge: %s:%d: skipped, %v\n", path, re.Line, re.Err)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return info, rows, skipped, err
+		}
+		m.add(rec)
+		rows++
+	}
+}
+
+// mergeSQLite merges the devices table of a --sqlite database into m.
+func mergeSQLite(m mergedDevices, path string) (int, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	result, err := db.Query(sqliteSelectDevices)
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+
+	rows := 0
+	for result.Next() {
+		var (
+			rec       wigle.Record
+			firstSeen string
+		)
+		err := result.Scan(&rec.MAC, &rec.SSID, &rec.AuthMode, &firstSeen, &rec.MfgrID, &rec.Type,
+			&rec.RSSI, &rec.Latitude, &rec.Longitude, &rec.Altitude, &rec.Accuracy)
+		if err != nil {
+			return rows, err
+		}
+		if rec.FirstSeen, err = time.Parse(wigle.TimeLayout, firstSeen); err != nil {
+			return rows, fmt.Errorf("device %s: first_seen %q: %w", rec.MAC, firstSeen, err)
+		}
+		m.add(rec)
+		rows++
+	}
+	return rows, result.Err()
+}
+
+// writeMerged writes the merged devices to path, gzipped if its name ends in
+// .gz. It writes to a temporary file first so path may be one of the inputs.
+func writeMerged(path string, info wigle.DeviceInfo, records []*wigle.Record) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	var out io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(f)
+		out = gz
+	}
+	w := wigle.NewWriter(out)
+	err = w.WriteHeader(info)
+	for i := 0; err == nil && i < len(records); i++ {
+		err = w.Write(*records[i])
+	}
+	err = errors.Join(err, w.Flush())
+	if gz != nil {
+		err = errors.Join(err, gz.Close())
+	}
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runMerge implements the merge subcommand: it combines captures and
+// --sqlite databases into one CSV with a row per device. Inputs are
+// streamed; only one record per device is held in memory.
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("out", "", "merged CSV to write, gzipped if it ends in .gz")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s merge -out FILE INPUT...\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var (
+		m       = make(mergedDevices)
+		info    wigle.DeviceInfo
+		hasInfo bool
+		total   int
+		skipped int
+	)
+	for _, path := range fs.Args() {
+		db, err := isSQLite(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "merge:", err)
+			return 1
+		}
+		var rows int
+		if db {
+			rows, err = mergeSQLite(m, path)
+		} else {
+			var (
+				fileInfo wigle.DeviceInfo
+				bad      int
+			)
+			fileInfo, rows, bad, err = mergeCSV(m, path)
+			if err == nil && !hasInfo {
+				info, hasInfo = fileInfo, true
+			}
+			skipped += bad
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "merge: %s: %v\n", path, err)
+			return 1
+		}
+		total += rows
+	}
+	if !hasInfo {
+		info = detectDeviceInfo()
+	}
+
+	if err := writeMerged(*out, info, m.sorted()); err != nil {
+		fmt.Fprintln(os.Stderr, "merge:", err)
+		return 1
+	}
+	fmt.Printf("Merged %d inputs: %d rows, %d unique devices", fs.NArg(), total, len(m))
+	if skipped > 0 {
+		fmt.Printf(", %d rows skipped", skipped)
+	}
+	fmt.Printf(", written to %s\n", *out)
+	return 0
+}
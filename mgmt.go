@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Bluetooth management (mgmt) protocol, spoken by the kernel on the HCI
+// control channel. BlueZ's D-Bus API doesn't expose scan timing, so it is set
+// here directly, the same way bluetoothd applies the [LE] section of
+// main.conf.
+const (
+	mgmtSetDefSystemConfig = 0x004C
+	mgmtEvCmdComplete      = 0x0001
+	mgmtEvCmdStatus        = 0x0002
+
+	mgmtEvDeviceFound           = 0x0012
+	mgmtEvAdvMonitorDeviceFound = 0x002F // a Device Found behind a monitor handle
+
+	// Address type of a Device Found event; the others are LE.
+	bdaddrBREDR = 0x00
+
+	// Default system configuration parameters, in units of 0.625 ms.
+	mgmtLEScanIntervalDiscovery  = 0x0011
+	mgmtLEScanWindowDiscovery    = 0x0012
+	mgmtLEScanIntervalAdvMonitor = 0x0013
+	mgmtLEScanWindowAdvMonitor   = 0x0014
+
+	hciDevNone = 0xFFFF
+)
+
+var mgmtStatuses = map[byte]string{
+	0x01: "unknown command",
+	0x03: "failed",
+	0x0A: "busy",
+	0x0C: "not supported",
+	0x0D: "invalid parameters",
+	0x11: "invalid index",
+	0x14: "permission denied",
+}
+
+// setScanTiming sets the LE scan interval and window the kernel uses on the
+// controller id, either for discovery (active scanning) or for advertisement
+// monitors (passive scanning). The setting lasts until the controller is
+// reset or bluetoothd restarts.
+func setScanTiming(id string, passive bool, interval, window time.Duration) error {
+	index, err := strconv.ParseUint(strings.TrimPrefix(id, "hci"), 10, 16)
+	if err != nil {
+		return fmt.Errorf("no controller index in %q", id)
+	}
+	intervalType, windowType := uint16(mgmtLEScanIntervalDiscovery), uint16(mgmtLEScanWindowDiscovery)
+	if passive {
+		intervalType, windowType = mgmtLEScanIntervalAdvMonitor, mgmtLEScanWindowAdvMonitor
+	}
+	var params []byte
+	for _, p := range []struct {
+		typ uint16
+		d   time.Duration
+	}{{intervalType, interval}, {windowType, window}} {
+		params = binary.LittleEndian.AppendUint16(params, p.typ)
+		params = append(params, 2)
+		params = binary.LittleEndian.AppendUint16(params, uint16(p.d/(625*time.Microsecond)))
+	}
+	return mgmtCommand(uint16(index), mgmtSetDefSystemConfig, params)
+}
+
+// mgmtSocket opens a management socket whose reads time out after timeout.
+// Binding it needs CAP_NET_ADMIN.
+func mgmtSocket(timeout time.Duration) (int, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return -1, fmt.Errorf("open management socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrHCI{Dev: hciDevNone, Channel: unix.HCI_CHANNEL_CONTROL}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("bind management socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// mgmtCommand sends a management command for a controller and waits for the
+// kernel's answer. It needs CAP_NET_ADMIN.
+func mgmtCommand(index, opcode uint16, params []byte) error {
+	fd, err := mgmtSocket(dbusSlowTimeout)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	cmd := binary.LittleEndian.AppendUint16(nil, opcode)
+	cmd = binary.LittleEndian.AppendUint16(cmd, index)
+	cmd = binary.LittleEndian.AppendUint16(cmd, uint16(len(params)))
+	if _, err := unix.Write(fd, append(cmd, params...)); err != nil {
+		return err
+	}
+
+	// Other sockets' events arrive here too; wait for the answer to ours.
+	deadline := time.Now().Add(dbusSlowTimeout)
+	buf := make([]byte, 512)
+	for time.Now().Before(deadline) {
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			return err
+		}
+		if n < 9 {
+			continue
+		}
+		event := binary.LittleEndian.Uint16(buf[0:])
+		if (event != mgmtEvCmdComplete && event != mgmtEvCmdStatus) ||
+			binary.LittleEndian.Uint16(buf[2:]) != index || binary.LittleEndian.Uint16(buf[6:]) != opcode {
+			continue
+		}
+		if status := buf[8]; status != 0 {
+			if msg, ok := mgmtStatuses[status]; ok {
+				return fmt.Errorf("management command 0x%04X: %s", opcode, msg)
+			}
+			return fmt.Errorf("management command 0x%04X: status 0x%02X", opcode, status)
+		}
+		return nil
+	}
+	return fmt.Errorf("management command 0x%04X: no reply", opcode)
+}
+
+// watchDeviceFound calls found for every device the kernel reports while
+// discovering or monitoring, with whether it was heard over LE or BR/EDR,
+// until ctx is cancelled. The kernel only sends these events to sockets
+// with CAP_NET_ADMIN.
+func watchDeviceFound(ctx context.Context, found func(addr string, le bool)) error {
+	fd, err := mgmtSocket(time.Second) // to notice ctx
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	buf := make([]byte, 512) // only the address is read; the rest may be cut
+	for ctx.Err() == nil {
+		n, err := unix.Read(fd, buf)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n < 6 {
+			continue
+		}
+		params := buf[6:n]
+		switch binary.LittleEndian.Uint16(buf[0:]) {
+		case mgmtEvDeviceFound:
+		case mgmtEvAdvMonitorDeviceFound:
+			if len(params) < 2 {
+				continue
+			}
+			params = params[2:]
+		default:
+			continue
+		}
+		if len(params) < 7 {
+			continue
+		}
+		// The address is sent least significant byte first.
+		a := params[:6]
+		addr := fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", a[5], a[4], a[3], a[2], a[1], a[0])
+		found(addr, params[6] != bdaddrBREDR)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// BlueZ's D-Bus discovery always scans actively. Passive scanning is only
+// available through advertisement monitors: while one is registered the
+// kernel runs a passive scan and BlueZ reports the devices whose
+// advertisements match its patterns through the usual Device1 objects.
+// There is no match-everything pattern, so the monitor matches any first
+// byte of the AD structures nearly every advertisement has: Flags for
+// anything connectable or discoverable, and manufacturer or service data for
+// beacons that are neither.
+var passiveADTypes = []byte{0x01, 0x16, 0xFF}
+
+// monitorPattern is an advertisement monitor pattern: content must appear in
+// the AD structure of type adType, starting at start.
+type monitorPattern struct {
+	Start   byte
+	ADType  byte
+	Content []byte
+}
+
+// advMonitor is the org.bluez.AdvertisementMonitor1 object. BlueZ calls these
+// methods to tell it about matches, but the scanner already follows the
+// device objects they are about, so they have nothing to do.
+type advMonitor struct{}
+
+func (advMonitor) Release() *dbus.Error                    { return nil }
+func (advMonitor) Activate() *dbus.Error                   { return nil }
+func (advMonitor) DeviceFound(dbus.ObjectPath) *dbus.Error { return nil }
+func (advMonitor) DeviceLost(dbus.ObjectPath) *dbus.Error  { return nil }
+
+// monitorApp is the object manager BlueZ queries for the monitors an
+// application registers.
+type monitorApp struct {
+	monitor dbus.ObjectPath
+	props   map[string]dbus.Variant
+}
+
+func (a monitorApp) GetManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+	return map[dbus.ObjectPath]map[string]map[string]dbus.Variant{
+		a.monitor: {"org.bluez.AdvertisementMonitor1": a.props},
+	}, nil
+}
+
+// startPassive registers an advertisement monitor on the scanner's
+// controller, which starts a passive scan. The returned function unregisters
+// it again.
+func (s *leScanner) startPassive() (func(), error) {
+	var patterns []monitorPattern
+	for _, t := range passiveADTypes {
+		for b := range 256 {
+			patterns = append(patterns, monitorPattern{ADType: t, Content: []byte{byte(b)}})
+		}
+	}
+	root := dbus.ObjectPath("/org/wiglebt/" + s.id)
+	app := monitorApp{
+		monitor: root + "/monitor0",
+		props: map[string]dbus.Variant{
+			"Type":     dbus.MakeVariant("or_patterns"),
+			"Patterns": dbus.MakeVariant(patterns),
+		},
+	}
+
+	if err := s.conn.Export(app, root, "org.freedesktop.DBus.ObjectManager"); err != nil {
+		return nil, err
+	}
+	unexport := func() {
+		s.conn.Export(nil, root, "org.freedesktop.DBus.ObjectManager")
+		s.conn.Export(nil, app.monitor, "org.bluez.AdvertisementMonitor1")
+		s.conn.Export(nil, app.monitor, "org.freedesktop.DBus.Properties")
+	}
+	if err := s.conn.Export(advMonitor{}, app.monitor, "org.bluez.AdvertisementMonitor1"); err != nil {
+		unexport()
+		return nil, err
+	}
+	props := make(map[string]*prop.Prop, len(app.props))
+	for k, v := range app.props {
+		props[k] = &prop.Prop{Value: v.Value(), Emit: prop.EmitFalse}
+	}
+	if _, err := prop.Export(s.conn, app.monitor, prop.Map{"org.bluez.AdvertisementMonitor1": props}); err != nil {
+		unexport()
+		return nil, err
+	}
+
+	if err := callTimeout(s.adapter, dbusSlowTimeout, "org.bluez.AdvertisementMonitorManager1.RegisterMonitor", root).Err; err != nil {
+		unexport()
+		var dbusErr dbus.Error
+		if errors.As(err, &dbusErr) && (dbusErr.Name == "org.freedesktop.DBus.Error.UnknownMethod" ||
+			dbusErr.Name == "org.freedesktop.DBus.Error.UnknownInterface") {
+			return nil, fmt.Errorf("passive scanning needs advertisement monitor support in BlueZ "+
+				"(older versions only offer it with bluetoothd --experimental): %w", err)
+		}
+		return nil, err
+	}
+	return func() {
+		callTimeout(s.adapter, dbusSlowTimeout, "org.bluez.AdvertisementMonitorManager1.UnregisterMonitor", root)
+		unexport()
+	}, nil
+}
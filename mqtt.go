@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// mqttBufferSize is how many sightings are held while the broker is
+	// unreachable. The oldest are dropped once it fills up.
+	mqttBufferSize = 1000
+
+	mqttMaxBackoff = 2 * time.Minute
+)
+
+// mqttPublisher streams sightings to an MQTT broker. Write never blocks: the
+// payload goes into a bounded buffer that a background goroutine drains
+// whenever the broker connection is up.
+type mqttPublisher struct {
+	client  mqtt.Client
+	topic   string
+	queue   chan []byte
+	dropped atomic.Uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newMQTTPublisher starts connecting to broker in the background. Cancelling
+// ctx abandons a connection attempt that hasn't succeeded yet.
+func newMQTTPublisher(ctx context.Context, broker, topic, user, pass string) *mqttPublisher {
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	hostname, _ := os.Hostname()
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("wigle-bluetooth-" + hostname).
+		SetUsername(user).
+		SetPassword(pass).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttMaxBackoff).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logWarn("MQTT connection lost: %v", err)
+		}).
+		SetOnConnectHandler(func(mqtt.Client) {
+			logInfo("MQTT connected to %s", broker)
+		})
+
+	m := &mqttPublisher{
+		client: mqtt.NewClient(opts),
+		topic:  topic,
+		queue:  make(chan []byte, mqttBufferSize),
+		done:   make(chan struct{}),
+	}
+	m.wg.Add(2)
+	go m.connect(ctx)
+	go m.run()
+	return m
+}
+
+// connect makes the initial broker connection, backing off exponentially.
+// Once connected, the client's auto-reconnect takes over. An attempt still
+// in progress is abandoned on Close, which waits for connect to return.
+func (m *mqttPublisher) connect(ctx context.Context) {
+	defer m.wg.Done()
+
+	backoff := time.Second
+	for {
+		token := m.client.Connect()
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.done:
+			return
+		case <-token.Done():
+		}
+		if token.Error() == nil {
+			return
+		}
+		logWarn("MQTT connect failed, retrying in %s: %v", backoff, token.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, mqttMaxBackoff)
+	}
+}
+
+func (m *mqttPublisher) run() {
+	defer m.wg.Done()
+
+	for {
+		// Leave messages buffered until the broker is reachable.
+		for !m.client.IsConnectionOpen() {
+			select {
+			case <-m.done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		select {
+		case <-m.done:
+			return
+		case payload := <-m.queue:
+			token := m.client.Publish(m.topic, 1, false, payload)
+			if !token.WaitTimeout(10 * time.Second) {
+				logWarn("MQTT publish timed out")
+			} else if err := token.Error(); err != nil {
+				logWarn("MQTT publish failed: %v", err)
+			}
+		}
+	}
+}
+
+func (m *mqttPublisher) Write(s Sighting) error {
+	payload, err := json.Marshal(newJSONLRecord(s))
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case m.queue <- payload:
+			return nil
+		default:
+			// Buffer full: drop the oldest message to make room.
+			select {
+			case <-m.queue:
+				m.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+func (m *mqttPublisher) Close() error {
+	close(m.done)
+	m.wg.Wait()
+	m.client.Disconnect(250)
+	if n := m.dropped.Load(); n > 0 {
+		logWarn("MQTT dropped %d sightings while the broker was unreachable", n)
+	}
+	return nil
+}
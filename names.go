@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxNameLength caps device names and SSIDs, in runes. GAP allows names of
+// 248 bytes, but ones that long are garbage and swamp the console and
+// spreadsheets.
+const maxNameLength = 64
+
+// sanitizeName makes a device name or SSID safe for WiGLE's importer and
+// line-based tools. Invalid UTF-8 becomes U+FFFD. Control characters such as
+// newlines and NULs, line and paragraph separators, and the bidi controls
+// that reorder the text around them are removed. Surrounding space is
+// trimmed and the result capped at maxNameLength runes.
+func sanitizeName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name { // invalid bytes come out as utf8.RuneError, U+FFFD
+		if unicode.IsControl(r) || unicode.In(r, unicode.Zl, unicode.Zp, unicode.Bidi_Control) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	clean := strings.TrimSpace(b.String())
+	if r := []rune(clean); len(r) > maxNameLength {
+		clean = strings.TrimSpace(string(r[:maxNameLength]))
+	}
+	return clean
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeName(t *testing.T) {
+	long := strings.Repeat("é", maxNameLength+10)
+	for _, tt := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Galaxy Buds2", "Galaxy Buds2"},
+		{"emoji", "Lisa’s 🎧 AirPods 👩\u200d👩\u200d👧", "Lisa’s 🎧 AirPods 👩\u200d👩\u200d👧"},
+		{"CJK", "小米手环 6", "小米手环 6"},
+		{"newline injection", "Speaker\n00:11:22:33:44:55,evil", "Speaker00:11:22:33:44:55,evil"},
+		{"carriage return", "TV\r\nFAKE", "TVFAKE"},
+		{"NUL padding", "Tile\x00\x00\x00", "Tile"},
+		{"NUL inside", "Ti\x00le", "Tile"},
+		{"tab", "a\tb", "ab"},
+		{"RTL override", "Phone\u202egnp.exe", "Phonegnp.exe"},
+		{"bidi isolates", "\u2066Car\u2069", "Car"},
+		{"line and paragraph separators", "a\u2028b\u2029c", "abc"},
+		{"invalid UTF-8", "Beacon\xff\xfe", "Beacon\ufffd\ufffd"},
+		{"truncated rune", "Caf\xc3", "Caf\ufffd"},
+		{"surrounding space", "  Watch \n", "Watch"},
+		{"only controls", "\x00\x01\n", ""},
+		{"long", long, strings.Repeat("é", maxNameLength)},
+		{"space at the cut", strings.Repeat("a", maxNameLength-1) + " b", strings.Repeat("a", maxNameLength-1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeName(tt.in)
+			if got != tt.want {
+				t.Errorf("sanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("sanitizeName(%q) = %q is not valid UTF-8", tt.in, got)
+			}
+			if n := utf8.RuneCountInString(got); n > maxNameLength {
+				t.Errorf("sanitizeName(%q) is %d runes long", tt.in, n)
+			}
+		})
+	}
+}
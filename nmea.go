@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stratoberry/go-gpsd"
+	"golang.org/x/sys/unix"
+)
+
+// nmeaUERE is the user equivalent range error, in metres, that HDOP is
+// multiplied by to estimate horizontal accuracy. NMEA has no error estimate
+// of its own; a few metres is typical for a consumer receiver.
+const nmeaUERE = 5.0
+
+// nmeaRetry is how long to wait before reopening a serial port that failed.
+const nmeaRetry = 5 * time.Second
+
+// baudRates maps the supported serial speeds to their termios constants.
+var baudRates = map[int]uint32{
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+}
+
+// parseNMEASource splits "/dev/ttyACM0@9600" into a device and baud rate,
+// defaulting to 9600 baud.
+func parseNMEASource(s string) (string, int, error) {
+	dev, rate, found := strings.Cut(s, "@")
+	if dev == "" {
+		return "", 0, errors.New("missing device")
+	}
+	if !found {
+		return dev, 9600, nil
+	}
+	baud, err := strconv.Atoi(rate)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid baud rate %q", rate)
+	}
+	if _, ok := baudRates[baud]; !ok {
+		return "", 0, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	return dev, baud, nil
+}
+
+// nmeaSource is the LocationProvider for a GPS receiver on a serial port,
+// for rigs without gpsd. Its NMEA sentences are turned into gpsd reports so
+// they take the same path as gpsd's.
+type nmeaSource struct {
+	locationFeed
+	device  string
+	baud    int
+	onSKY   gpsd.Filter
+	onReady func() // called once the port is open; may be nil
+}
+
+// Run reads from the port until ctx is cancelled, reopening it whenever it
+// fails, e.g. because the receiver was unplugged.
+func (n *nmeaSource) Run(ctx context.Context) error {
+	for {
+		err := n.read(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		n.lose()
+		logWarn("NMEA input %s failed (%v), reopening in %s", n.device, err, nmeaRetry)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(nmeaRetry):
+		}
+	}
+}
+
+func (n *nmeaSource) read(ctx context.Context) error {
+	f, err := openSerial(n.device, n.baud)
+	if err != nil {
+		return err
+	}
+	// Closing the port is the only way to interrupt a blocked read.
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+	defer f.Close()
+	logInfo("Reading NMEA from %s at %d baud", n.device, n.baud)
+	if n.onReady != nil {
+		n.onReady()
+	}
+
+	var p nmeaParser
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		tpv, sky := p.Parse(sc.Text())
+		if tpv != nil {
+			n.tpv(tpv)
+		}
+		if sky != nil && n.onSKY != nil {
+			n.onSKY(sky)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return errors.New("end of input")
+}
+
+// openSerial opens a serial port in raw mode at the given speed.
+func openSerial(device string, baud int) (*os.File, error) {
+	f, err := os.OpenFile(device, os.O_RDONLY|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	t, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a serial port: %w", device, err)
+	}
+	speed := baudRates[baud]
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CBAUD
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(f.Fd()), unix.TCSETS, t); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// nmeaParser turns GGA, RMC and GSA sentences into gpsd-style reports.
+// Each sentence only carries part of the picture, so it keeps the latest
+// values of each and emits a TPV report on every GGA or RMC.
+type nmeaParser struct {
+	tpv   gpsd.TPVReport
+	date  time.Time // from RMC; GGA only has the time of day
+	mode  gpsd.Mode // from GSA, 0 until one is seen
+	hdop  float64
+	valid bool // the last GGA or RMC had a fix
+}
+
+// Parse handles one line of input. Lines that aren't complete sentences
+// with a valid checksum, such as the partial line usually read first after
+// opening the port, are ignored.
+func (p *nmeaParser) Parse(line string) (*gpsd.TPVReport, *gpsd.SKYReport) {
+	fields, ok := nmeaFields(line)
+	if !ok || len(fields[0]) < 5 {
+		return nil, nil
+	}
+
+	switch fields[0][len(fields[0])-3:] {
+	case "GGA":
+		// $GPGGA,time,lat,N,lon,E,quality,sats,hdop,alt,M,...
+		if len(fields) < 10 {
+			return nil, nil
+		}
+		quality, _ := strconv.Atoi(fields[6])
+		p.valid = quality > 0 && p.position(fields[2:6])
+		if p.valid {
+			p.tpv.Alt, _ = strconv.ParseFloat(fields[9], 64)
+		}
+		if hdop, err := strconv.ParseFloat(fields[8], 64); err == nil {
+			p.hdop = hdop
+		}
+		p.setTime(fields[1])
+		return p.report(), nil
+
+	case "RMC":
+		// $GPRMC,time,status,lat,N,lon,E,knots,course,date,...
+		if len(fields) < 10 {
+			return nil, nil
+		}
+		p.valid = fields[2] == "A" && p.position(fields[3:7])
+		if p.valid {
+			knots, _ := strconv.ParseFloat(fields[7], 64)
+			p.tpv.Speed = knots * 0.514444
+			p.tpv.Track, _ = strconv.ParseFloat(fields[8], 64)
+		}
+		if d, err := time.Parse("020106", fields[9]); err == nil {
+			p.date = d
+		}
+		p.setTime(fields[1])
+		return p.report(), nil
+
+	case "GSA":
+		// $GPGSA,auto,mode,prn x12,pdop,hdop,vdop
+		if len(fields) < 18 {
+			return nil, nil
+		}
+		mode, _ := strconv.Atoi(fields[2])
+		p.mode = gpsd.Mode(mode)
+		sky := &gpsd.SKYReport{Class: "SKY"}
+		sky.Pdop, _ = strconv.ParseFloat(fields[15], 64)
+		sky.Hdop, _ = strconv.ParseFloat(fields[16], 64)
+		sky.Vdop, _ = strconv.ParseFloat(fields[17], 64)
+		for _, prn := range fields[3:15] {
+			if n, err := strconv.Atoi(prn); err == nil {
+				sky.Satellites = append(sky.Satellites, gpsd.Satellite{PRN: float64(n), Used: true})
+			}
+		}
+		return nil, sky
+	}
+	return nil, nil
+}
+
+// position sets the coordinates from a sentence's lat, N/S, lon, E/W
+// fields. It reports false, leaving them alone, if either doesn't parse: a
+// sentence claiming a fix without a position has none, rather than one at
+// 0,0.
+func (p *nmeaParser) position(fields []string) bool {
+	lat, err := nmeaCoordinate(fields[0], fields[1])
+	if err != nil {
+		return false
+	}
+	lon, err := nmeaCoordinate(fields[2], fields[3])
+	if err != nil {
+		return false
+	}
+	p.tpv.Lat, p.tpv.Lon = lat, lon
+	return true
+}
+
+// setTime combines an hhmmss.ss time of day with the last RMC date.
+func (p *nmeaParser) setTime(hms string) {
+	if p.date.IsZero() || len(hms) < 6 {
+		p.tpv.Time = time.Time{}
+		return
+	}
+	t, err := time.Parse("150405.999999999", hms)
+	if err != nil {
+		p.tpv.Time = time.Time{}
+		return
+	}
+	p.tpv.Time = p.date.Add(t.Sub(t.Truncate(24 * time.Hour)))
+}
+
+func (p *nmeaParser) report() *gpsd.TPVReport {
+	r := p.tpv
+	r.Class = "TPV"
+	switch {
+	case !p.valid:
+		r.Mode = gpsd.NoFix
+	case p.mode >= gpsd.Mode2D:
+		r.Mode = p.mode
+	default:
+		r.Mode = gpsd.Mode2D
+	}
+	r.Eph = p.hdop * nmeaUERE
+	return &r
+}
+
+// nmeaFields checks a sentence's checksum and splits it into fields, the
+// first being the address such as "GPGGA".
+func nmeaFields(line string) ([]string, bool) {
+	line = strings.TrimSpace(line)
+	start := strings.LastIndexByte(line, '$')
+	if start < 0 {
+		return nil, false
+	}
+	body, sum, found := strings.Cut(line[start+1:], "*")
+	if !found || len(sum) != 2 {
+		return nil, false
+	}
+	want, err := strconv.ParseUint(sum, 16, 8)
+	if err != nil {
+		return nil, false
+	}
+	var got byte
+	for i := 0; i < len(body); i++ {
+		got ^= body[i]
+	}
+	if got != byte(want) {
+		return nil, false
+	}
+	return strings.Split(body, ","), true
+}
+
+// nmeaCoordinate converts a ddmm.mmmm or dddmm.mmmm value and its
+// hemisphere to signed decimal degrees.
+func nmeaCoordinate(value, hemisphere string) (float64, error) {
+	dot := strings.IndexByte(value, '.')
+	if dot < 0 {
+		dot = len(value)
+	}
+	if dot < 3 {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	deg, err := strconv.ParseFloat(value[:dot-2], 64)
+	if err != nil {
+		return 0, err
+	}
+	min, err := strconv.ParseFloat(value[dot-2:], 64)
+	if err != nil {
+		return 0, err
+	}
+	v := deg + min/60
+	if hemisphere == "S" || hemisphere == "W" {
+		v = -v
+	}
+	return v, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stratoberry/go-gpsd"
+)
+
+// parseNMEAFile runs each line of a file in testdata through one parser.
+func parseNMEAFile(t *testing.T, name string, fn func(line int, tpv *gpsd.TPVReport, sky *gpsd.SKYReport)) {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var p nmeaParser
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		tpv, sky := p.Parse(sc.Text())
+		fn(line, tpv, sky)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNMEAParse reads a receiver getting a fix, losing it and finding it
+// again; see the comments for what each line of fix.nmea holds.
+func TestNMEAParse(t *testing.T) {
+	const (
+		lat = 48 + 7.038/60
+		lon = 11 + 31.0/60
+	)
+	type want struct {
+		tpv      bool
+		mode     gpsd.Mode
+		lat, lon float64 // checked with a fix
+		eph      float64
+		sats     int // satellites in a SKY report, -1 for none
+	}
+	wants := []want{
+		{sats: -1},                              // partial first line
+		{tpv: true, mode: gpsd.NoFix, sats: -1}, // RMC, status V
+		{tpv: true, mode: gpsd.NoFix, eph: 499.95, sats: -1}, // GGA, quality 0
+		{sats: 5}, // GSA, 3D
+		{tpv: true, mode: gpsd.Mode3D, lat: lat, lon: lon, eph: 4.5, sats: -1}, // GGA
+		{tpv: true, mode: gpsd.Mode3D, lat: lat, lon: lon, eph: 4.5, sats: -1}, // RMC
+		{sats: -1}, // GGA with a bad checksum
+		{sats: 3},  // GSA, 2D
+		{tpv: true, mode: gpsd.Mode2D, lat: -lat, lon: -lon, eph: 4.5, sats: -1}, // GGA, S and W
+		{tpv: true, mode: gpsd.NoFix, eph: 4.5, sats: -1},                        // GGA, quality 1 but no position
+		{tpv: true, mode: gpsd.NoFix, eph: 4.5, sats: -1},                        // RMC, status A but no longitude
+		{sats: 0}, // GSA, no fix
+		// GGA with a fix, which the GSA before it doesn't know of yet.
+		{tpv: true, mode: gpsd.Mode2D, lat: lat, lon: lon, eph: 10, sats: -1},
+	}
+
+	var lines int
+	parseNMEAFile(t, "fix.nmea", func(line int, tpv *gpsd.TPVReport, sky *gpsd.SKYReport) {
+		lines = line
+		if line > len(wants) {
+			t.Fatalf("line %d not expected", line)
+		}
+		w := wants[line-1]
+		if (tpv != nil) != w.tpv {
+			t.Fatalf("line %d: TPV %+v, want one %v", line, tpv, w.tpv)
+		}
+		if tpv != nil {
+			if tpv.Mode != w.mode {
+				t.Errorf("line %d: mode %d, want %d", line, tpv.Mode, w.mode)
+			}
+			if w.mode >= gpsd.Mode2D && (math.Abs(tpv.Lat-w.lat) > 1e-9 || math.Abs(tpv.Lon-w.lon) > 1e-9) {
+				t.Errorf("line %d: position %f,%f, want %f,%f", line, tpv.Lat, tpv.Lon, w.lat, w.lon)
+			}
+			if math.Abs(tpv.Eph-w.eph) > 1e-9 {
+				t.Errorf("line %d: eph %f, want %f", line, tpv.Eph, w.eph)
+			}
+		}
+		if sky == nil && w.sats >= 0 || sky != nil && len(sky.Satellites) != w.sats {
+			t.Errorf("line %d: SKY %+v, want %d satellites", line, sky, w.sats)
+		}
+	})
+	if lines != len(wants) {
+		t.Errorf("read %d lines, want %d", lines, len(wants))
+	}
+}
+
+func TestNMEAParseFields(t *testing.T) {
+	var fix []*gpsd.TPVReport
+	parseNMEAFile(t, "fix.nmea", func(line int, tpv *gpsd.TPVReport, sky *gpsd.SKYReport) {
+		if tpv != nil && tpv.Mode >= gpsd.Mode2D {
+			fix = append(fix, tpv)
+		}
+		if line == 4 && (sky.Hdop != 1.3 || sky.Pdop != 2.5 || sky.Vdop != 2.1) {
+			t.Errorf("GSA DOPs %v %v %v, want 2.5 1.3 2.1", sky.Pdop, sky.Hdop, sky.Vdop)
+		}
+	})
+	if len(fix) != 4 {
+		t.Fatalf("%d fixes, want 4", len(fix))
+	}
+	gga, rmc := fix[0], fix[1]
+	if want := time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC); !gga.Time.Equal(want) {
+		t.Errorf("GGA time %v, want %v", gga.Time, want)
+	}
+	if gga.Alt != 545.4 {
+		t.Errorf("GGA altitude %v, want 545.4", gga.Alt)
+	}
+	if math.Abs(rmc.Speed-22.4*0.514444) > 1e-9 || rmc.Track != 84.4 {
+		t.Errorf("RMC speed %v, track %v, want 11.52 m/s at 84.4", rmc.Speed, rmc.Track)
+	}
+}
+
+// TestNMEANoFixAtZero feeds fix.nmea to a locationFeed: the sentences that
+// claim a fix without a position must lose it, never publish one at 0,0.
+func TestNMEANoFixAtZero(t *testing.T) {
+	var feed locationFeed
+	var published []LocationData
+	feed.Subscribe(func(loc LocationData) { published = append(published, loc) })
+	parseNMEAFile(t, "fix.nmea", func(line int, tpv *gpsd.TPVReport, sky *gpsd.SKYReport) {
+		if tpv != nil {
+			feed.tpv(tpv)
+		}
+		if line == 10 && feed.Current().Fix {
+			t.Errorf("fix kept after a GGA without a position: %+v", feed.Current())
+		}
+	})
+	if len(published) != 4 {
+		t.Errorf("%d fixes published, want 4", len(published))
+	}
+	for _, loc := range published {
+		if math.Abs(loc.Latitude) < 1 || math.Abs(loc.Longitude) < 1 {
+			t.Errorf("published %+v", loc)
+		}
+	}
+}
+
+func TestNMEAFields(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		ok   bool
+	}{
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", true},
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n", true},
+		{"$gpgga,1*4f", false},
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48", false}, // wrong checksum
+		{"$GPGGA,123519,4807.039,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", false}, // corrupted
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,", false},    // no checksum
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*4", false},  // cut short
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*ZZ", false},
+		{"4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", false},
+		// Noise from before the port was opened is skipped.
+		{"\x00\xff5.4,M,$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", true},
+		{"", false},
+	} {
+		if _, ok := nmeaFields(tt.line); ok != tt.ok {
+			t.Errorf("nmeaFields(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+		}
+	}
+}
+
+func TestNMEACoordinate(t *testing.T) {
+	for _, tt := range []struct {
+		value, hemisphere string
+		want              float64
+		ok                bool
+	}{
+		{"4807.038", "N", 48 + 7.038/60, true},
+		{"4807.038", "S", -(48 + 7.038/60), true},
+		{"01131.000", "E", 11 + 31.0/60, true},
+		{"01131.000", "W", -(11 + 31.0/60), true},
+		{"4807", "N", 48 + 7.0/60, true},
+		{"", "N", 0, false},
+		{"07.038", "N", 0, false},
+		{"48a7.038", "N", 0, false},
+		{"4807.0x8", "N", 0, false},
+	} {
+		got, err := nmeaCoordinate(tt.value, tt.hemisphere)
+		if (err == nil) != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("nmeaCoordinate(%q, %q) = %v, %v, want %v", tt.value, tt.hemisphere, got, err, tt.want)
+		}
+	}
+}
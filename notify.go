@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceStatusInterval is how often the service manager's status text is
+// updated.
+const serviceStatusInterval = 10 * time.Second
+
+// sdNotify sends state to the service manager over $NOTIFY_SOCKET, as
+// systemd's sd_notify does. It does nothing when not run as a notify
+// service.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often the service manager wants WATCHDOG=1,
+// half its timeout, or 0 if it isn't watching this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runServiceNotifier tells the service manager the payload is ready once
+// ready is closed, then keeps its status text up to date and pings its
+// watchdog until ctx is cancelled. It returns at once outside a notify
+// service.
+func runServiceNotifier(ctx context.Context, ready <-chan struct{}, status func() statusSnapshot) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	notify := func(state string) {
+		if err := sdNotify(state); err != nil {
+			logDebug("failed to notify the service manager: %v", err)
+		}
+	}
+
+	// The watchdog is pinged while waiting too, as gpsd may take a while.
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	notify("STATUS=Waiting for the GPS")
+	for ready != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-watchdog:
+			notify("WATCHDOG=1")
+		case <-ready:
+			ready = nil
+		}
+	}
+	notify("READY=1\nSTATUS=" + serviceStatus(status()))
+
+	ticker := time.NewTicker(serviceStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			notify("STOPPING=1")
+			return
+		case <-watchdog:
+			notify("WATCHDOG=1")
+		case <-ticker.C:
+			notify("STATUS=" + serviceStatus(status()))
+		}
+	}
+}
+
+// serviceStatus is the one-line status shown by e.g. systemctl status.
+func serviceStatus(s statusSnapshot) string {
+	gps := "no GPS fix"
+	if s.Fix {
+		gps = fmt.Sprintf("GPS ±%.0f m", s.Accuracy)
+	}
+	parts := []string{
+		"Scanning",
+		fmt.Sprintf("%d devices", s.Devices),
+		fmt.Sprintf("%d rows", s.Rows),
+		fmt.Sprintf("%d skipped without a fix", s.NoFix),
+		gps,
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writePIDFile writes the process ID to path for init systems, like
+// OpenWrt's procd, that track daemons by PID file.
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
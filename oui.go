@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ouiURL is the IEEE MA-L registry that update-oui downloads.
+const ouiURL = "https://standards-oui.ieee.org/oui/oui.csv"
+
+// defaultOUIPath is where update-oui saves a fresh registry. If present it is
+// used instead of the copy built into the binary.
+const defaultOUIPath = "/etc/wigle-bt/oui.csv"
+
+// embeddedOUI is the IEEE registry trimmed to the columns we use.
+//
+//go:embed oui.csv.gz
+var embeddedOUI []byte
+
+var (
+	ouiOnce   sync.Once
+	ouiVendor map[uint32]string
+)
+
+// ouiLookup returns the organization an address's OUI is assigned to. Only
+// public addresses have meaningful OUIs; callers must not pass random ones.
+func ouiLookup(addr string) string {
+	ouiOnce.Do(loadOUI)
+
+	hex := strings.ReplaceAll(addr, ":", "")
+	if len(hex) < 6 {
+		return ""
+	}
+	prefix, err := strconv.ParseUint(hex[:6], 16, 32)
+	if err != nil {
+		return ""
+	}
+	return ouiVendor[uint32(prefix)]
+}
+
+// loadOUI reads the on-disk registry if update-oui has written one, falling
+// back to the embedded copy.
+func loadOUI() {
+	if f, err := os.Open(defaultOUIPath); err == nil {
+		defer f.Close()
+		vendors, err := parseOUI(f)
+		if err == nil {
+			ouiVendor = vendors
+			return
+		}
+		logWarn("ignoring %s: %v", defaultOUIPath, err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(embeddedOUI))
+	if err == nil {
+		ouiVendor, err = parseOUI(zr)
+	}
+	if err != nil {
+		logWarn("failed to load built-in OUI table: %v", err)
+	}
+}
+
+// parseOUI reads the IEEE oui.csv format, locating columns by header name.
+func parseOUI(r io.Reader) (map[uint32]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	assignment, org := -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(name) {
+		case "Assignment":
+			assignment = i
+		case "Organization Name":
+			org = i
+		}
+	}
+	if assignment < 0 || org < 0 {
+		return nil, errors.New("missing Assignment or Organization Name column")
+	}
+
+	vendors := make(map[uint32]string)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) <= assignment || len(row) <= org {
+			continue
+		}
+		prefix, err := strconv.ParseUint(row[assignment], 16, 32)
+		if err != nil || len(row[assignment]) != 6 {
+			continue
+		}
+		vendors[uint32(prefix)] = strings.TrimSpace(row[org])
+	}
+	if len(vendors) == 0 {
+		return nil, errors.New("no OUI assignments found")
+	}
+	return vendors, nil
+}
+
+// runUpdateOUI implements the update-oui subcommand: it downloads the IEEE
+// registry and installs it as the on-disk override.
+func runUpdateOUI(args []string) int {
+	fs := flag.NewFlagSet("update-oui", flag.ContinueOnError)
+	url := fs.String("url", ouiURL, "registry to download")
+	out := fs.String("out", defaultOUIPath, "where to save it")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if err := updateOUI(*url, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "update-oui:", err)
+		return 1
+	}
+	return 0
+}
+
+func updateOUI(url, out string) error {
+	n, err := downloadRegistry(url, out, func(r io.Reader) (int, error) {
+		vendors, err := parseOUI(r)
+		return len(vendors), err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d OUI assignments to %s\n", n, out)
+	return nil
+}
+
+// downloadRegistry fetches url and installs it at out, but only if parse
+// accepts it, so a bad download never replaces a working table. It returns
+// the number of entries parse found.
+func downloadRegistry(url, out string, parse func(io.Reader) (int, error)) (int, error) {
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := parse(bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("downloaded registry is unusable: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return 0, err
+	}
+	tmp := out + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// pauseSwitch pauses and resumes scanning on every adapter. While paused the
+// scans are stopped, but GPS and the outputs stay open so resuming carries on
+// where it left off, in the same files.
+type pauseSwitch struct {
+	mu      sync.Mutex
+	paused  bool
+	changed chan struct{} // closed and replaced on every toggle
+}
+
+func newPauseSwitch() *pauseSwitch {
+	return &pauseSwitch{changed: make(chan struct{})}
+}
+
+// Toggle pauses or resumes scanning and returns whether it is now paused.
+func (p *pauseSwitch) Toggle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = !p.paused
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return p.paused
+}
+
+// Paused reports whether scanning is paused.
+func (p *pauseSwitch) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *pauseSwitch) state() (bool, chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, p.changed
+}
+
+// Wait blocks while scanning is paused. It returns false if ctx ended first.
+func (p *pauseSwitch) Wait(ctx context.Context) bool {
+	for {
+		paused, changed := p.state()
+		if !paused {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// Context returns a context for one scan, cancelled when ctx is or when
+// scanning is paused.
+func (p *pauseSwitch) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	paused, changed := p.state()
+	if paused {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-changed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
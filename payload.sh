@@ -43,7 +43,8 @@ while true; do
             LOG yellow "wiglebluetooth is already running."
         else
             LOG green "Starting wiglebluetooth..."
-            ./wiglebluetooth &
+            mkdir -p /root/loot/wigle-bluetooth
+            ./wiglebluetooth >> /root/loot/wigle-bluetooth/wiglebluetooth.log 2>&1 &
             LOG green "wiglebluetooth started. Logging in /root/loot/wigle-bluetooth"
         fi
     elif [ "$choice" == "DOWN" ]; then
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// pipeline turns what the scanners hear into rows. It filters each result,
+// stamps it with the time and a position from location, dedups it and hands
+// it to sinks, counting everything it skips. The scanners, classic
+// discovery, the WiFi scan and --replay all feed it.
+type pipeline struct {
+	cfg      *config
+	location LocationProvider
+	clock    *gpsClock
+	sinks    Sink
+	guard    *crashGuard
+	stop     *stopper
+	pause    *pauseSwitch
+	smoother *rssiSmoother
+	fence    *geofence
+	props    *propCache
+	janitor  *janitor
+
+	// The rest are optional.
+	wanted       func(addr string) bool // nil logs every address
+	transports   *transportTracker
+	resolver     *nameResolver
+	follow       *follower
+	backfill     *backfillBuffer
+	priv         *privacyFilter
+	space        *spaceMonitor
+	multiAdapter bool // name the adapter in the console line
+
+	// Counts for the status and the session summary.
+	sightings, rowsWritten, lowSpace, backfilled atomic.Uint64
+	rssiFiltered, suppressed, noFix, staleFix    atomic.Uint64
+	inaccurateFix, speedFiltered, geofenced      atomic.Uint64
+	uniqueDevices                                atomic.Int64 // devices logged this session, for --max-devices
+
+	// enAddresses counts the addresses Exposure Notification beacons were
+	// seen from, as a rough measure of how many phones were around.
+	enAddresses addressSet
+
+	// held are the sightings waiting for the next fix, with --interpolate.
+	held heldSightings
+}
+
+// Skipped returns the number of sightings filtered out or suppressed.
+func (p *pipeline) Skipped() uint64 {
+	return p.suppressed.Load() + p.noFix.Load() + p.staleFix.Load() + p.inaccurateFix.Load() +
+		p.speedFiltered.Load() + p.geofenced.Load() + p.rssiFiltered.Load()
+}
+
+// Scan logs what scanner hears until its scan ends.
+func (p *pipeline) Scan(ctx context.Context, scanner Scanner) error {
+	return scanner.Scan(ctx, p.advertisement)
+}
+
+// tooWeak reports whether a sighting falls below --min-rssi, counting the
+// ones that do. RSSI is widened to int so the comparison can't wrap.
+func (p *pipeline) tooWeak(rssi int16) bool {
+	if p.cfg.MinRSSI != 0 && int(rssi) < p.cfg.MinRSSI {
+		p.rssiFiltered.Add(1)
+		return true
+	}
+	return false
+}
+
+// isWanted applies --ignore-macs, --only-macs and the own-device exclusion.
+func (p *pipeline) isWanted(addr string) bool {
+	return p.wanted == nil || p.wanted(addr)
+}
+
+// record stamps a sighting with the current time and location and writes
+// it. Every source calls it. Names are sanitized here, so no sink sees the
+// raw one; --raw-log keeps the advertised bytes.
+func (p *pipeline) record(s Sighting) {
+	// Drop results still in flight when the scans were paused.
+	if p.pause.Paused() {
+		return
+	}
+	p.sightings.Add(1)
+	s.Name = sanitizeName(s.Name)
+	s.Timestamp, s.TimeFromGPS = p.clock.Now()
+
+	cfg := p.cfg
+	loc := p.location.Current()
+	if cfg.Interpolate {
+		for _, h := range p.held.Expired(s.Timestamp) {
+			h.Location = heldPosition(h, LocationData{})
+			p.write(h)
+		}
+	}
+
+	// Without a usable fix, hold on to the sighting for the next one.
+	if (!loc.Fix || loc.stale(cfg.FixMaxAge)) && p.backfill != nil && p.backfill.Add(s) {
+		return
+	}
+	if !loc.Fix {
+		p.noFix.Add(1)
+		logDebug("No GPS fix, skipping device: %s", s.Address)
+		return
+	}
+	if loc.stale(cfg.FixMaxAge) {
+		p.staleFix.Add(1)
+		logDebug("GPS fix is stale, skipping device: %s", s.Address)
+		return
+	}
+	if cfg.OnlyMoving && loc.Speed < cfg.MovingSpeed || cfg.OnlyStationary && loc.Speed >= cfg.MovingSpeed {
+		p.speedFiltered.Add(1)
+		return
+	}
+	// An unknown error estimate doesn't pass the gate either.
+	if cfg.MaxAccuracy > 0 && (loc.Error == 0 || loc.Error > cfg.MaxAccuracy) {
+		p.inaccurateFix.Add(1)
+		logDebug("GPS accuracy %.1f m above --max-accuracy, skipping device: %s", loc.Error, s.Address)
+		return
+	}
+
+	if p.backfill != nil {
+		for _, b := range p.backfill.Take(s.Timestamp) {
+			b.Location = backfillLocation(loc, s.Timestamp.Sub(b.Timestamp))
+			b.Backfilled = true
+			p.write(b)
+			p.backfilled.Add(1)
+		}
+	}
+	s.Location = loc
+	if cfg.Interpolate {
+		// While moving, wait for the next fix to place the sighting
+		// between the two.
+		if loc.Speed > 0 && p.held.Add(s) {
+			return
+		}
+		s.Location = heldPosition(s, LocationData{})
+	}
+	p.write(s)
+}
+
+// fix writes the sightings held for the next fix, now that it is here.
+// main calls it for every fix.
+func (p *pipeline) fix(next LocationData) {
+	for _, s := range p.held.Take() {
+		s.Location = heldPosition(s, next)
+		p.write(s)
+	}
+}
+
+// release writes the sightings still waiting for a fix, projected from the
+// one they were made with. main calls it at shutdown, before closing the
+// sinks.
+func (p *pipeline) release() {
+	for _, s := range p.held.Take() {
+		s.Location = heldPosition(s, LocationData{})
+		p.write(s)
+	}
+}
+
+// write stamps a sighting with its first-seen time and hands it to the
+// sinks, unless it is outside the geofence or dedup suppresses it.
+func (p *pipeline) write(s Sighting) {
+	cfg := p.cfg
+
+	// Track first-seen time, and skip the row if the device was written
+	// recently and hasn't come noticeably closer since.
+	devicesMu.Lock()
+	dev := devices[s.Address]
+	if dev == nil {
+		dev = &deviceState{FirstSeen: s.Timestamp, Written: make(map[string]writeMark)}
+		devices[s.Address] = dev
+	}
+	if !p.fence.Allows(s.Location) {
+		// Only the first-seen time is kept, so it isn't skewed if the
+		// device turns up outside the fence later.
+		devicesMu.Unlock()
+		p.geofenced.Add(1)
+		return
+	}
+	newDevice := dev.Sightings == 0
+	dev.update(s)
+	s.FirstSeen = dev.FirstSeen
+	last, written := dev.Written[s.Type]
+	if written && cfg.DedupInterval > 0 &&
+		s.Timestamp.Sub(last.At) < cfg.DedupInterval &&
+		s.SmoothedRSSI-last.RSSI <= float64(cfg.DedupRSSI) {
+		devicesMu.Unlock()
+		p.suppressed.Add(1)
+		return
+	}
+	dev.Written[s.Type] = writeMark{At: s.Timestamp, RSSI: s.SmoothedRSSI}
+	devicesMu.Unlock()
+	if newDevice {
+		if n := p.uniqueDevices.Add(1); cfg.MaxDevices > 0 && n >= int64(cfg.MaxDevices) {
+			p.stop.Stop(fmt.Sprintf("--max-devices %d reached", cfg.MaxDevices))
+		}
+	}
+
+	if s.AddressType == addressPublic {
+		s.Vendor = ouiLookup(s.Address)
+		if cfg.OUITag && s.Vendor != "" {
+			s.Capabilities += "[" + s.Vendor + "]"
+		}
+	}
+
+	if p.space != nil && p.space.Stopped() {
+		p.lowSpace.Add(1)
+		return
+	}
+	if p.priv != nil {
+		s = p.priv.Sighting(s)
+	}
+	if cfg.DryRun {
+		logDebug("Dry run, not written: %s", strings.Join(wigleRecord(s).Format(cfg.Precision()), ","))
+	}
+	p.sinks.Write(s)
+	if rows := p.rowsWritten.Add(1); cfg.MaxRows > 0 && rows >= cfg.MaxRows {
+		p.stop.Stop(fmt.Sprintf("--max-rows %d reached", cfg.MaxRows))
+	}
+
+	// In follow mode the console belongs to the follow line, and in TUI
+	// mode to the device table.
+	if p.follow != nil || cfg.TUI || cfg.Quiet {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Found %s device: %s (%s) Class: 0x%06X Capabilities: %s",
+		s.Type, s.Address, s.Name, s.Class, s.Capabilities)
+	if p.multiAdapter {
+		fmt.Fprintf(&b, " Adapter: %s", s.Adapter)
+	}
+	if s.Vendor != "" {
+		fmt.Fprintf(&b, " Vendor: %s", s.Vendor)
+	}
+	if len(s.MfgrNames) > 0 {
+		fmt.Fprintf(&b, " Manufacturer: %s", strings.Join(s.MfgrNames, "; "))
+	}
+	if s.Distance > 0 {
+		fmt.Fprintf(&b, " Distance: ~%.1f m", s.Distance)
+	}
+	logInfo("%s", b.String())
+}
+
+// classicFound logs a BR/EDR inquiry result as a BT row.
+func (p *pipeline) classicFound(adapterID, addr, name string, class uint32, rssi int16) {
+	if !p.isWanted(addr) {
+		return
+	}
+	followed := p.follow != nil && p.follow.Matches(addr)
+	if p.cfg.FollowOnly && !followed {
+		return
+	}
+	smoothed := p.smoother.Add(addr, rssi)
+	if followed {
+		p.follow.Update(rssi, smoothed, 0)
+	}
+	if p.tooWeak(rssi) {
+		return
+	}
+	p.record(Sighting{
+		Address:      addr,
+		Adapter:      adapterID,
+		AddressType:  addressPublic,
+		Name:         name,
+		Class:        class,
+		Capabilities: buildCapabilities(class, false),
+		RSSI:         rssi,
+		SmoothedRSSI: smoothed,
+		Type:         "BT",
+	})
+}
+
+// inquiryResult handles a result of classic discovery.
+func (p *pipeline) inquiryResult(adapterID, addr, name string, class uint32, rssi int16) {
+	defer p.guard.Recover("classic scan callback")
+	p.janitor.Seen(adapterID, addr)
+	// An advertisement of a dual-mode device, which the BLE scanner logs.
+	if le, ok := p.transports.Last(addr); ok && le {
+		return
+	}
+	p.classicFound(adapterID, addr, name, class, rssi)
+}
+
+// advertisement handles a result of an LE scan.
+func (p *pipeline) advertisement(adv Advertisement) {
+	defer p.guard.Recover("scan callback")
+	cfg := p.cfg
+	adapterID, addr, payload := adv.Adapter, adv.Address, adv.Payload()
+	p.janitor.Seen(adapterID, addr)
+
+	exposureNotification := isExposureNotification(payload)
+	if exposureNotification {
+		p.enAddresses.Add(addr)
+		// Nothing from the beacon, including its rolling identifier, goes
+		// to the outputs.
+		if cfg.DropEN {
+			return
+		}
+	}
+
+	if !p.isWanted(addr) {
+		return
+	}
+
+	props, known := p.props.Get(adapterID, addr)
+	deviceClass, _ := props["Class"].Value().(uint32)
+	md := adv.ManufacturerData
+
+	// BlueZ keeps one Device1 per address, so with BR/EDR discovery running
+	// this callback gets the inquiry results of classic and dual-mode
+	// devices too. The kernel says which transport a result came in on.
+	// Failing that, with --classic, results with a Class but no advertising
+	// data are taken for inquiry results, as are those whose Class is still
+	// being looked up.
+	le, ok := p.transports.Last(addr)
+	if !ok {
+		le = !cfg.Classic || deviceClass == 0 && known || len(md) > 0 || len(adv.ServiceData) > 0
+	}
+	if !le {
+		// The classic scanner logs them if it runs.
+		if !cfg.Classic {
+			p.classicFound(adapterID, addr, bluezName(props), deviceClass, adv.RSSI)
+		}
+		return
+	}
+
+	// The followed device is tracked however weak it is.
+	followed := p.follow != nil && p.follow.Matches(addr)
+	if cfg.FollowOnly && !followed {
+		return
+	}
+	weak := p.tooWeak(adv.RSSI)
+	if weak && !followed {
+		return
+	}
+
+	addrType := adv.AddressType()
+	if cfg.SkipRandom && addrType != addressPublic && addrType != addressStatic {
+		return
+	}
+	smoothed := p.smoother.Add(addr, adv.RSSI)
+
+	// WiGLE's MfgrId column takes a single number, so it gets the first ID;
+	// the other outputs get all of them, with names for people reading them.
+	mfgrIDs := manufacturerIDs(md)
+	mfgrID := ""
+	var mfgrNames []string
+	for i, id := range mfgrIDs {
+		if i == 0 {
+			mfgrID = fmt.Sprintf("%d", id)
+		}
+		mfgrNames = append(mfgrNames, companyName(id))
+	}
+
+	// The RSSI expected at 1 m: from an iBeacon's calibration byte if there
+	// is one, else from the advertised TX power.
+	measuredPower := cfg.DefaultTxPower
+	if tx, ok := props["TxPower"].Value().(int16); ok {
+		measuredPower = int(tx) - txPowerPathLoss
+	}
+
+	label := buildCapabilities(deviceClass, true)
+	// Class of Device wins when there is one, as in WiGLE Android.
+	if legend := appearanceLegend(props); deviceClass == 0 && legend != "" {
+		label = legend + " [LE]"
+	}
+	if tracker, ok := matchTracker(payload); ok {
+		label = tracker + " Tracker [LE]"
+	}
+	if exposureNotification {
+		label = "Exposure Notification [LE]"
+	}
+	tags := addressTypeTag(addrType) + serviceTag(adv.ServiceUUIDs)
+	isConnectable := connectable(props)
+	if isConnectable {
+		tags += "[conn]"
+	}
+	var advFlags string
+	if flags, ok := advertisingFlags(props); ok {
+		advFlags = describeFlags(flags)
+	}
+	for _, m := range md {
+		if m.CompanyID != appleCompanyID {
+			continue
+		}
+		// The tag also reaches the console through the "Found" line.
+		if beacon, ok := parseIBeacon(m.Data); ok {
+			tags += fmt.Sprintf("[iBeacon %s tx %d]", beacon, beacon.TxPower)
+			measuredPower = int(beacon.TxPower)
+		}
+		if fm, ok := parseFindMy(m.Data); ok {
+			label = "FindMy Tracker [LE]"
+			state := "with owner"
+			if fm.Separated {
+				state = "separated"
+			}
+			tags += "[" + state + "]"
+			logInfo("*** FindMy tracker %s (%s) RSSI %d battery %s ***",
+				addr, state, adv.RSSI, fm.Battery)
+		}
+	}
+
+	// The advertisement often carries no name although BlueZ has one from a
+	// scan response or an earlier connection. Names that turn up later
+	// reach the cache and so the device's next row.
+	name := adv.Name
+	if name == "" {
+		name = bluezName(props)
+	}
+	for _, sd := range adv.ServiceData {
+		if !sd.UUID.Is16Bit() || sd.UUID.Get16Bit() != eddystoneUUID {
+			continue
+		}
+		frame, ok := parseEddystone(sd.Data)
+		if !ok {
+			continue
+		}
+		switch frame.Type {
+		case eddystoneUID:
+			tags += "[Eddystone UID]"
+		case eddystoneURL:
+			tags += "[Eddystone URL]"
+		case eddystoneTLM:
+			tags += "[Eddystone TLM]"
+			logDebug("Eddystone TLM %s: battery %d mV, temperature %.1f °C",
+				addr, frame.BatteryMV, frame.Temperature)
+		}
+		// Name otherwise anonymous beacons by what they broadcast.
+		if name == "" {
+			name = frame.ID
+		}
+	}
+	// Failing all that, ask the device. The answer comes in the background,
+	// in time for a later row.
+	if name == "" && p.resolver != nil {
+		if resolved, ok := p.resolver.Lookup(addr); ok {
+			name = resolved
+		} else if isConnectable {
+			p.resolver.Request(adapterID, addr)
+		}
+	}
+
+	var distance float64
+	if measuredPower != 0 {
+		distance = estimateDistance(adv.RSSI, measuredPower, cfg.PathLossExponent)
+	}
+
+	if followed {
+		p.follow.Update(adv.RSSI, smoothed, distance)
+		if weak {
+			return
+		}
+	}
+
+	var raw []byte
+	if cfg.RawLog != "" {
+		raw = rawAdvertisement(payload, advertisingData(props))
+	}
+
+	p.record(Sighting{
+		Address:      addr,
+		Adapter:      adapterID,
+		AddressType:  addrType,
+		Name:         name,
+		Class:        deviceClass,
+		Capabilities: label + tags,
+		RSSI:         adv.RSSI,
+		SmoothedRSSI: smoothed,
+		MfgrID:       mfgrID,
+		MfgrIDs:      mfgrIDs,
+		MfgrNames:    mfgrNames,
+		Type:         "BLE",
+		Distance:     distance,
+		Raw:          raw,
+		Connectable:  isConnectable,
+		AdvFlags:     advFlags,
+	})
+}
+
+// network handles a result of the WiFi scan.
+func (p *pipeline) network(n wifiNetwork) {
+	defer p.guard.Recover("WiFi scan callback")
+	if !p.isWanted(n.BSSID) {
+		return
+	}
+	smoothed := p.smoother.Add(n.BSSID, n.Signal)
+	if p.tooWeak(n.Signal) {
+		return
+	}
+	addrType := addressPublic
+	if b, err := strconv.ParseUint(n.BSSID[:min(2, len(n.BSSID))], 16, 8); err == nil && b&0x02 != 0 {
+		addrType = addressLocal
+	}
+	p.record(Sighting{
+		Address:      n.BSSID,
+		Adapter:      p.cfg.WiFi,
+		AddressType:  addrType,
+		Name:         n.SSID,
+		Capabilities: n.AuthMode,
+		RSSI:         n.Signal,
+		SmoothedRSSI: smoothed,
+		Type:         "WIFI",
+		Channel:      wifiChannel(n.Frequency),
+		Frequency:    n.Frequency,
+	})
+}
+
+// replayed handles a sighting read back by --replay.
+func (p *pipeline) replayed(s Sighting) {
+	defer p.guard.Recover("replay callback")
+	if !p.isWanted(s.Address) {
+		return
+	}
+	followed := p.follow != nil && p.follow.Matches(s.Address)
+	if p.cfg.FollowOnly && !followed {
+		return
+	}
+	s.SmoothedRSSI = p.smoother.Add(s.Address, s.RSSI)
+	if followed {
+		p.follow.Update(s.RSSI, s.SmoothedRSSI, s.Distance)
+	}
+	if p.tooWeak(s.RSSI) {
+		return
+	}
+	p.record(s)
+}
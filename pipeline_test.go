@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// testConfig parses args over the defaults, leaving out any config file on
+// the machine running the tests.
+func testConfig(t *testing.T, args ...string) *config {
+	t.Helper()
+	empty := filepath.Join(t.TempDir(), "empty.toml")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig(append([]string{"--config", empty, "--output-dir", t.TempDir(), "--quiet"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// captureSink collects the sightings that reach the sinks.
+type captureSink struct {
+	ch chan Sighting
+}
+
+func (c *captureSink) Write(s Sighting) error {
+	c.ch <- s
+	return nil
+}
+
+func (c *captureSink) Close() error { return nil }
+
+// rows returns the sightings written so far.
+func (c *captureSink) rows() []Sighting {
+	var rows []Sighting
+	for {
+		select {
+		case s := <-c.ch:
+			rows = append(rows, s)
+		default:
+			return rows
+		}
+	}
+}
+
+// wait returns the next n sightings, failing the test if they don't come.
+func (c *captureSink) wait(t *testing.T, n int) []Sighting {
+	t.Helper()
+	var rows []Sighting
+	timeout := time.After(5 * time.Second)
+	for len(rows) < n {
+		select {
+		case s := <-c.ch:
+			rows = append(rows, s)
+		case <-timeout:
+			t.Fatalf("got %d rows, want %d", len(rows), n)
+		}
+	}
+	return rows
+}
+
+// newTestPipeline returns a pipeline over loc writing to a captureSink, with
+// the devices of earlier tests forgotten. The test fails if the pipeline
+// recovers a panic.
+func newTestPipeline(t *testing.T, cfg *config, loc LocationProvider) (*pipeline, *captureSink) {
+	t.Helper()
+	devicesMu.Lock()
+	devices = make(map[string]*deviceState)
+	devicesMu.Unlock()
+
+	sink := &captureSink{ch: make(chan Sighting, 1000)}
+	props := newPropCache(nil)
+	p := &pipeline{
+		cfg:      cfg,
+		location: loc,
+		clock:    newGPSClock(),
+		sinks:    sink,
+		guard:    newCrashGuard("", false),
+		stop:     &stopper{cancel: func() {}},
+		pause:    newPauseSwitch(),
+		smoother: newRSSISmoother(cfg.RSSIAlpha),
+		fence:    &geofence{include: cfg.GeofenceInclude, exclude: cfg.GeofenceExclude},
+		props:    props,
+		janitor:  newJanitor(nil, props, 0),
+	}
+	// As main does, every fix sets the clock and places the sightings
+	// held for it.
+	loc.Subscribe(func(fix LocationData) {
+		if !fix.Time.IsZero() {
+			p.clock.Sync(fix.Time)
+		}
+		if cfg.Interpolate {
+			p.fix(fix)
+		}
+	})
+	t.Cleanup(func() {
+		if n := p.guard.Count(); n > 0 {
+			t.Errorf("%d panics recovered", n)
+		}
+	})
+	return p, sink
+}
+
+// testFix returns a fix at lat, lon with a 5 m error.
+func testFix(lat, lon float64) LocationData {
+	return LocationData{Fix: true, Latitude: lat, Longitude: lon, Altitude: 12, Error: 5}
+}
+
+// scan runs p over scanner in the background; the returned function stops
+// the scan and waits for it to end.
+func scan(t *testing.T, p *pipeline, scanner Scanner) (stop func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Scan(ctx, scanner) }()
+	return func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Scan: %v", err)
+		}
+	}
+}
+
+func TestPipelineScan(t *testing.T) {
+	cfg := testConfig(t, "--drop-en", "--min-rssi", "-90")
+	loc := &mockLocation{}
+	loc.Set(testFix(51.5007, -0.1246))
+	p, sink := newTestPipeline(t, cfg, loc)
+
+	en := []bluetooth.UUID{bluetooth.New16BitUUID(exposureNotificationUUID)}
+	scanner := &fakeScanner{id: "hci0", script: []fakeAdvertisement{
+		{Advertisement: Advertisement{Address: "4A:11:22:33:44:55", Random: true, RSSI: -60, ServiceUUIDs: en}},
+		{Advertisement: Advertisement{Address: "00:11:22:33:44:01", RSSI: -95, Name: "far away"}},
+		{Advertisement: Advertisement{Address: "00:11:22:33:44:02", RSSI: -70, Name: "Sensor\r\nFAKE,ROW",
+			ManufacturerData: []bluetooth.ManufacturerDataElement{{CompanyID: 0x0006, Data: []byte{1, 2}}}}},
+		{Advertisement: Advertisement{Address: "00:11:22:33:44:03", RSSI: -50, Name: "Headphones"}},
+	}}
+	stop := scan(t, p, scanner)
+	rows := sink.wait(t, 2)
+	stop()
+	if extra := sink.rows(); len(extra) > 0 {
+		t.Fatalf("unexpected rows %+v", extra)
+	}
+
+	s := rows[0]
+	if s.Address != "00:11:22:33:44:02" || s.Type != "BLE" || s.Adapter != "hci0" {
+		t.Errorf("row 0 is %s %s on %s, want 00:11:22:33:44:02 BLE on hci0", s.Address, s.Type, s.Adapter)
+	}
+	if s.Name != "SensorFAKE,ROW" {
+		t.Errorf("name %q not sanitized", s.Name)
+	}
+	if s.MfgrID != "6" {
+		t.Errorf("MfgrID = %q, want 6", s.MfgrID)
+	}
+	if !s.Location.Fix || s.Location.Latitude != 51.5007 || s.Location.Longitude != -0.1246 {
+		t.Errorf("location = %+v, want the mock fix", s.Location)
+	}
+	if s.FirstSeen.IsZero() || s.FirstSeen != s.Timestamp {
+		t.Errorf("FirstSeen %v, Timestamp %v: want both set and equal on the first sighting", s.FirstSeen, s.Timestamp)
+	}
+	if rows[1].Address != "00:11:22:33:44:03" {
+		t.Errorf("row 1 is %s, want 00:11:22:33:44:03", rows[1].Address)
+	}
+
+	if n := p.enAddresses.Len(); n != 1 {
+		t.Errorf("%d Exposure Notification addresses counted, want 1", n)
+	}
+	if n := p.rssiFiltered.Load(); n != 1 {
+		t.Errorf("%d sightings dropped by --min-rssi, want 1", n)
+	}
+	if n := p.rowsWritten.Load(); n != 2 {
+		t.Errorf("%d rows counted, want 2", n)
+	}
+}
+
+func TestPipelineScanStop(t *testing.T) {
+	cfg := testConfig(t)
+	loc := &mockLocation{}
+	loc.Set(testFix(1, 2))
+	p, sink := newTestPipeline(t, cfg, loc)
+
+	scanner := &fakeScanner{id: "hci0", script: []fakeAdvertisement{
+		{Advertisement: Advertisement{Address: "00:11:22:33:44:01", RSSI: -60}},
+		{After: time.Hour, Advertisement: Advertisement{Address: "00:11:22:33:44:02", RSSI: -60}},
+	}}
+	done := make(chan error, 1)
+	go func() { done <- p.Scan(context.Background(), scanner) }()
+	sink.wait(t, 1)
+	if err := scanner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Scan: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scan still running after Stop")
+	}
+}
+
+// TestPipelineScanAdapters runs several adapters at once, as --adapter
+// hci0,hci1 does.
+func TestPipelineScanAdapters(t *testing.T) {
+	cfg := testConfig(t)
+	loc := &mockLocation{}
+	loc.Set(testFix(1, 2))
+	p, sink := newTestPipeline(t, cfg, loc)
+	p.multiAdapter = true
+
+	const perAdapter = 200
+	en := []bluetooth.UUID{bluetooth.New16BitUUID(exposureNotificationUUID)}
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := range 2 {
+		scanner := &fakeScanner{id: fmt.Sprintf("hci%d", i)}
+		for j := range perAdapter {
+			addr := fmt.Sprintf("4A:00:00:00:%02X:%02X", i, j)
+			scanner.script = append(scanner.script, fakeAdvertisement{
+				Advertisement: Advertisement{Address: addr, Random: true, RSSI: -60, ServiceUUIDs: en},
+			})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Scan(ctx, scanner)
+		}()
+	}
+	sink.wait(t, 2*perAdapter)
+	cancel()
+	wg.Wait()
+
+	if n := p.enAddresses.Len(); n != 2*perAdapter {
+		t.Errorf("%d Exposure Notification addresses counted, want %d", n, 2*perAdapter)
+	}
+}
@@ -0,0 +1,198 @@
+package wigle
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader reads the records of a WiGLE CSV file. Columns are found by name,
+// so files with the columns in another order, or with extra ones, read
+// too, and so do files without a pre-header.
+type Reader struct {
+	r       *csv.Reader
+	info    DeviceInfo
+	columns map[string]int
+	fields  int // in the column header
+	line    int
+	err     error // from reading the headers; sticks
+}
+
+// RowError is a row that couldn't be read. Reading can carry on past it.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	cr := csv.NewReader(r)
+	// The pre-header and the header differ in length.
+	cr.FieldsPerRecord = -1
+	return &Reader{r: cr}
+}
+
+// DeviceInfo returns the capture device from the pre-header. It is only
+// filled in once the first record, or the end of the file, has been read,
+// and is zero if the file has no pre-header.
+func (r *Reader) DeviceInfo() DeviceInfo {
+	return r.info
+}
+
+// Line returns the line number of the last row read.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// readHeaders reads the pre-header, if there is one, and the column header.
+func (r *Reader) readHeaders() error {
+	row, err := r.next()
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("empty file")
+		}
+		return fmt.Errorf("header: %v", err)
+	}
+	if strings.HasPrefix(row[0], "WigleWifi-") {
+		r.info = parsePreHeader(row)
+		row, err = r.next()
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("no column header")
+			}
+			return fmt.Errorf("column header: %v", err)
+		}
+	}
+	r.fields = len(row)
+	r.columns = make(map[string]int, len(row))
+	for i, name := range row {
+		r.columns[name] = i
+	}
+	for _, name := range []string{"MAC", "FirstSeen", "CurrentLatitude", "CurrentLongitude", "Type"} {
+		if _, ok := r.columns[name]; !ok {
+			return fmt.Errorf("line %d: column header has no %s", r.line, name)
+		}
+	}
+	return nil
+}
+
+func (r *Reader) next() ([]string, error) {
+	row, err := r.r.Read()
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return nil, &RowError{pe.StartLine, pe.Err}
+	}
+	if err == nil {
+		r.line, _ = r.r.FieldPos(0)
+	}
+	return row, err
+}
+
+// Read returns the next record, or io.EOF at the end of the file. A row
+// that can't be read is reported as a *RowError and the next Read carries
+// on after it; any other error ends the file.
+func (r *Reader) Read() (Record, error) {
+	if r.columns == nil {
+		if r.err == nil {
+			r.err = r.readHeaders()
+		}
+		if r.err != nil {
+			return Record{}, r.err
+		}
+	}
+	row, err := r.next()
+	if err != nil {
+		return Record{}, err
+	}
+	rec, err := r.parse(row)
+	if err != nil {
+		return Record{}, &RowError{r.line, err}
+	}
+	return rec, nil
+}
+
+// ReadAll returns all remaining records.
+func (r *Reader) ReadAll() ([]Record, error) {
+	var records []Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+func (r *Reader) parse(row []string) (Record, error) {
+	if len(row) != r.fields {
+		return Record{}, fmt.Errorf("%d fields, want %d", len(row), r.fields)
+	}
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+	var errs []error
+	integer := func(name string) int {
+		s := field(name)
+		if s == "" {
+			return 0
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q is not a number", name, s))
+		}
+		return v
+	}
+	float := func(name string) float64 {
+		s := field(name)
+		if s == "" {
+			return 0
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q is not a number", name, s))
+		}
+		return v
+	}
+
+	rec := Record{
+		MAC:       field("MAC"),
+		SSID:      field("SSID"),
+		AuthMode:  field("AuthMode"),
+		Channel:   integer("Channel"),
+		Frequency: integer("Frequency"),
+		RSSI:      integer("RSSI"),
+		Latitude:  float("CurrentLatitude"),
+		Longitude: float("CurrentLongitude"),
+		Altitude:  float("AltitudeMeters"),
+		Accuracy:  float("AccuracyMeters"),
+		RCOIs:     field("RCOIs"),
+		MfgrID:    field("MfgrId"),
+		Type:      field("Type"),
+	}
+	if s := field("FirstSeen"); s != "" {
+		t, err := time.Parse(TimeLayout, s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("FirstSeen %q is not YYYY-MM-DD HH:MM:SS", s))
+		}
+		rec.FirstSeen = t
+	}
+	return rec, errors.Join(errs...)
+}
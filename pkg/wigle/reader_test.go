@@ -0,0 +1,169 @@
+package wigle
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The files in testdata are laid out as the WiGLE Android app exports
+// them: its pre-header, the WigleWifi-1.6 columns, \n line endings and
+// quotes only where a field needs them.
+
+// TestRoundTrip reads each file in testdata and writes it back, which must
+// give the same bytes.
+func TestRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.csv")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no test files: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewReader(bytes.NewReader(want))
+			records, err := r.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got bytes.Buffer
+			w := NewWriter(&got)
+			if err := w.WriteHeader(r.DeviceInfo()); err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range records {
+				if err := w.Write(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("round trip differs\ngot:\n%s\nwant:\n%s", got.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestReadAndroidFile(t *testing.T) {
+	f, err := os.Open("testdata/android-bt.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := NewReader(f)
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantInfo := DeviceInfo{
+		AppRelease: "2.79",
+		Model:      "Pixel 7",
+		Release:    "14",
+		Device:     "panther",
+		Display:    "AP2A.240805.005",
+		Board:      "panther",
+		Brand:      "google",
+	}
+	if info := r.DeviceInfo(); info != wantInfo {
+		t.Errorf("DeviceInfo = %+v, want %+v", info, wantInfo)
+	}
+	if len(records) != 4 {
+		t.Fatalf("read %d records, want 4", len(records))
+	}
+	want := Record{
+		MAC:       "00:1E:7C:8B:02:4F",
+		SSID:      "Honda HFT, 2.0",
+		AuthMode:  "Car Audio [BT]",
+		FirstSeen: time.Date(2024, 9, 14, 16, 2, 15, 0, time.UTC),
+		Frequency: 1032,
+		RSSI:      -79,
+		Latitude:  47.60625,
+		Longitude: -122.332102,
+		Altitude:  54.1,
+		Accuracy:  4,
+		Type:      TypeBT,
+	}
+	if records[2] != want {
+		t.Errorf("record 2 = %+v, want %+v", records[2], want)
+	}
+	if r.Line() != 6 {
+		t.Errorf("Line = %d after the last row, want 6", r.Line())
+	}
+}
+
+func TestReadColumnsByName(t *testing.T) {
+	// No pre-header, columns reordered and an extra one.
+	in := "Type,MAC,Extra,FirstSeen,CurrentLatitude,CurrentLongitude,RSSI\n" +
+		"BLE,AA:BB:CC:DD:EE:FF,x,2024-01-02 03:04:05,1.5,-2.5,-60\n"
+	records, err := NewReader(strings.NewReader(in)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Record{
+		MAC:       "AA:BB:CC:DD:EE:FF",
+		FirstSeen: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		RSSI:      -60,
+		Latitude:  1.5,
+		Longitude: -2.5,
+		Type:      TypeBLE,
+	}
+	if len(records) != 1 || records[0] != want {
+		t.Errorf("records = %+v, want [%+v]", records, want)
+	}
+}
+
+func TestReadBadRows(t *testing.T) {
+	in := strings.Join([]string{
+		strings.Join(DeviceInfo{}.PreHeader(), ","),
+		strings.Join(Header, ","),
+		"AA:BB:CC:DD:EE:01,,,2024-01-02 03:04:05,0,0,-60,1,2,0,0,,,BLE",
+		"AA:BB:CC:DD:EE:02,,,2024-01-02 03:04:05,0,0,-60,1,2",
+		"AA:BB:CC:DD:EE:03,,,yesterday,0,0,loud,1,2,0,0,,,BLE",
+		"AA:BB:CC:DD:EE:04,,,2024-01-02 03:04:05,0,0,-60,1,2,0,0,,,BLE",
+	}, "\n") + "\n"
+	r := NewReader(strings.NewReader(in))
+
+	var good []string
+	var bad []int
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			var re *RowError
+			if !errors.As(err, &re) {
+				break
+			}
+			bad = append(bad, re.Line)
+			continue
+		}
+		good = append(good, rec.MAC)
+	}
+	if len(good) != 2 || good[0] != "AA:BB:CC:DD:EE:01" || good[1] != "AA:BB:CC:DD:EE:04" {
+		t.Errorf("read %v, want the first and last rows", good)
+	}
+	if len(bad) != 2 || bad[0] != 4 || bad[1] != 5 {
+		t.Errorf("bad rows on lines %v, want [4 5]", bad)
+	}
+}
+
+func TestReadNoHeader(t *testing.T) {
+	for _, in := range []string{"", strings.Join(DeviceInfo{}.PreHeader(), ",") + "\n", "MAC,SSID\n"} {
+		r := NewReader(strings.NewReader(in))
+		if _, err := r.Read(); err == nil {
+			t.Errorf("Read of %q succeeded", in)
+		}
+		// The error sticks.
+		if _, err := r.Read(); err == nil {
+			t.Errorf("second Read of %q succeeded", in)
+		}
+	}
+}
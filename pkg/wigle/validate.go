@@ -0,0 +1,165 @@
+package wigle
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is something in a file that WiGLE would reject or misread.
+type Problem struct {
+	Line    int
+	Message string
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// Validate checks a WiGLE CSV file: the pre-header, the column header, and
+// for every row the field count, MAC, timestamp, coordinates, numbers and
+// type. It returns the number of rows and every problem found; err is only
+// set if reading failed.
+func Validate(r io.Reader) (rows int, problems []Problem, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	report := func(line int, format string, args ...any) {
+		problems = append(problems, Problem{line, fmt.Sprintf(format, args...)})
+	}
+
+	var (
+		header  []string
+		columns map[string]int
+	)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			report(pe.StartLine, "%v", pe.Err)
+			continue
+		}
+		if err != nil {
+			return rows, problems, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		switch {
+		case header == nil && line == 1 && strings.HasPrefix(row[0], "WigleWifi-"):
+			continue
+		case header == nil:
+			if line == 1 {
+				report(line, "no WigleWifi pre-header")
+			}
+			header, columns = row, validateHeader(row, line, report)
+			continue
+		}
+
+		rows++
+		if len(row) != len(header) {
+			report(line, "%d fields, want %d", len(row), len(header))
+			continue
+		}
+		validateRow(row, columns, line, report)
+	}
+
+	if header == nil {
+		report(1, "no column header")
+	}
+	return rows, problems, nil
+}
+
+// validateHeader checks the column header and maps the known columns to
+// their index.
+func validateHeader(row []string, line int, report func(int, string, ...any)) map[string]int {
+	columns := make(map[string]int, len(row))
+	for i, name := range row {
+		if !slices.Contains(Header, name) {
+			report(line, "unknown column %q", name)
+			continue
+		}
+		columns[name] = i
+	}
+	missing := false
+	for _, name := range Header {
+		if _, ok := columns[name]; !ok {
+			report(line, "no %s column", name)
+			missing = true
+		}
+	}
+	if !missing && len(row) == len(Header) && !slices.Equal(row, Header) {
+		report(line, "columns out of order, want %s", strings.Join(Header, ","))
+	}
+	return columns
+}
+
+func validateRow(row []string, columns map[string]int, line int, report func(int, string, ...any)) {
+	field := func(name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok {
+			return "", false
+		}
+		return row[i], true
+	}
+
+	if mac, ok := field("MAC"); ok && !validMAC(mac) {
+		report(line, "MAC %q is not of the form AA:BB:CC:DD:EE:FF", mac)
+	}
+	if s, ok := field("FirstSeen"); ok {
+		if _, err := time.Parse(TimeLayout, s); err != nil {
+			report(line, "FirstSeen %q is not YYYY-MM-DD HH:MM:SS", s)
+		}
+	}
+	coordinate := func(name string, limit float64) {
+		s, ok := field(name)
+		if !ok {
+			return
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		switch {
+		case err != nil:
+			report(line, "%s %q is not a number", name, s)
+		case v < -limit || v > limit:
+			report(line, "%s %s is out of range", name, s)
+		}
+	}
+	coordinate("CurrentLatitude", 90)
+	coordinate("CurrentLongitude", 180)
+	for _, name := range []string{"Channel", "Frequency", "RSSI", "AltitudeMeters", "AccuracyMeters"} {
+		if s, ok := field(name); ok && s != "" {
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				report(line, "%s %q is not a number", name, s)
+			}
+		}
+	}
+	if t, ok := field("Type"); ok && t != TypeBLE && t != TypeBT && t != TypeWiFi {
+		report(line, "Type %q is not %s, %s or %s", t, TypeBLE, TypeBT, TypeWiFi)
+	}
+}
+
+// validMAC reports whether s is six colon-separated hex octets.
+func validMAC(s string) bool {
+	if len(s) != 17 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if i%3 == 2 {
+			if c != ':' {
+				return false
+			}
+			continue
+		}
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+// Package wigle reads and writes the CSV files WiGLE takes as uploads, in
+// the WigleWifi-1.6 format of the WiGLE Android app: a pre-header row
+// describing the capture device, a column header, then one row per
+// observation.
+package wigle
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the first field of the pre-header row.
+const FormatVersion = "WigleWifi-1.6"
+
+// TimeLayout is the layout of the FirstSeen column. WiGLE reads it as UTC.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// Values of the Type column.
+const (
+	TypeBLE  = "BLE"
+	TypeBT   = "BT" // classic Bluetooth
+	TypeWiFi = "WIFI"
+)
+
+// Header is the column header row.
+var Header = []string{
+	"MAC", "SSID", "AuthMode", "FirstSeen", "Channel",
+	"Frequency", "RSSI", "CurrentLatitude", "CurrentLongitude",
+	"AltitudeMeters", "AccuracyMeters", "RCOIs", "MfgrId", "Type",
+}
+
+// DeviceInfo describes the capture device in the pre-header row, which
+// WiGLE uses to attribute the upload.
+type DeviceInfo struct {
+	AppRelease string
+	Model      string
+	Release    string
+	Device     string
+	Display    string
+	Board      string
+	Brand      string
+}
+
+// PreHeader returns the pre-header row.
+func (d DeviceInfo) PreHeader() []string {
+	return []string{
+		FormatVersion,
+		"appRelease=" + d.AppRelease,
+		"model=" + d.Model,
+		"release=" + d.Release,
+		"device=" + d.Device,
+		"display=" + d.Display,
+		"board=" + d.Board,
+		"brand=" + d.Brand,
+		"star=Sol",
+		"body=3",
+		"subBody=0",
+	}
+}
+
+// parsePreHeader reads the device fields of a pre-header row. Unknown keys
+// are ignored.
+func parsePreHeader(row []string) DeviceInfo {
+	var d DeviceInfo
+	for _, field := range row[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "appRelease":
+			d.AppRelease = value
+		case "model":
+			d.Model = value
+		case "release":
+			d.Release = value
+		case "device":
+			d.Device = value
+		case "display":
+			d.Display = value
+		case "board":
+			d.Board = value
+		case "brand":
+			d.Brand = value
+		}
+	}
+	return d
+}
+
+// Record is one row: an observation of a network or device.
+type Record struct {
+	MAC       string // BSSID or Bluetooth address
+	SSID      string // network name, or device name for Bluetooth
+	AuthMode  string // security, or capabilities for Bluetooth
+	FirstSeen time.Time
+	Channel   int
+	// Frequency is in MHz for WiFi. For Bluetooth it carries the major and
+	// minor device class bits, as the Android app writes it.
+	Frequency int
+	RSSI      int
+	Latitude  float64
+	Longitude float64
+	Altitude  float64 // metres
+	Accuracy  float64 // metres
+	RCOIs     string  // Passpoint roaming consortium OIs; blank for Bluetooth
+	MfgrID    string  // Bluetooth manufacturer ID, blank if none
+	Type      string  // TypeBLE, TypeBT or TypeWiFi
+}
+
+// Precision is the number of decimals written for the coordinates, the
+// altitude and the accuracy.
+type Precision struct {
+	Coordinates int
+	Altitude    int
+	Accuracy    int
+}
+
+// DefaultPrecision matches the Android app: six decimals, about 10 cm, for
+// latitude and longitude, and one for altitude and accuracy.
+var DefaultPrecision = Precision{Coordinates: 6, Altitude: 1, Accuracy: 1}
+
+// Fields formats the record as a row with DefaultPrecision.
+func (r Record) Fields() []string {
+	return r.Format(DefaultPrecision)
+}
+
+// Format formats the record as a row with the given precision.
+func (r Record) Format(p Precision) []string {
+	return []string{
+		r.MAC,
+		r.SSID,
+		r.AuthMode,
+		r.FirstSeen.Format(TimeLayout),
+		strconv.Itoa(r.Channel),
+		strconv.Itoa(r.Frequency),
+		strconv.Itoa(r.RSSI),
+		strconv.FormatFloat(r.Latitude, 'f', p.Coordinates, 64),
+		strconv.FormatFloat(r.Longitude, 'f', p.Coordinates, 64),
+		strconv.FormatFloat(r.Altitude, 'f', p.Altitude, 64),
+		strconv.FormatFloat(r.Accuracy, 'f', p.Accuracy, 64),
+		r.RCOIs,
+		r.MfgrID,
+		r.Type,
+	}
+}
@@ -0,0 +1,68 @@
+package wigle
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// androidRecord is the observation in testdata/android-row.golden, with the
+// full precision of the fix it was made at.
+var androidRecord = Record{
+	MAC:       "F4:7B:09:2C:51:E8",
+	SSID:      "Galaxy Buds2 (51E8)",
+	AuthMode:  "Headphones [LE]",
+	FirstSeen: time.Date(2024, 9, 14, 16, 2, 11, 0, time.UTC),
+	Frequency: 1028,
+	RSSI:      -71,
+	Latitude:  47.60620949,
+	Longitude: -122.33207118,
+	Altitude:  54.31,
+	Accuracy:  3.79,
+	MfgrID:    "117",
+	Type:      TypeBLE,
+}
+
+// TestFormatGolden compares a row written with DefaultPrecision to the same
+// observation as the WiGLE Android app lays it out.
+func TestFormatGolden(t *testing.T) {
+	want, err := os.ReadFile("testdata/android-row.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	w := NewWriter(&got)
+	if err := w.Write(androidRecord); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("got  %q\nwant %q", got.Bytes(), want)
+	}
+}
+
+func TestFormatPrecision(t *testing.T) {
+	for _, tt := range []struct {
+		p                  Precision
+		lat, lon, alt, acc string
+	}{
+		{DefaultPrecision, "47.606209", "-122.332071", "54.3", "3.8"},
+		{Precision{Coordinates: 3, Altitude: 1, Accuracy: 1}, "47.606", "-122.332", "54.3", "3.8"},
+		{Precision{Coordinates: 0, Altitude: 0, Accuracy: 0}, "48", "-122", "54", "4"},
+		{Precision{Coordinates: 6, Altitude: 2, Accuracy: 2}, "47.606209", "-122.332071", "54.31", "3.79"},
+	} {
+		fields := androidRecord.Format(tt.p)
+		got := fields[7:11]
+		want := []string{tt.lat, tt.lon, tt.alt, tt.acc}
+		if !slices.Equal(got, want) {
+			t.Errorf("Format(%+v) position = %v, want %v", tt.p, got, want)
+		}
+	}
+	if got := androidRecord.Fields(); !slices.Equal(got, androidRecord.Format(DefaultPrecision)) {
+		t.Errorf("Fields() = %v, want the DefaultPrecision format", got)
+	}
+}
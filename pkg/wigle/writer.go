@@ -0,0 +1,43 @@
+package wigle
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// Writer writes a WiGLE CSV file. Rows are buffered; call Flush to push
+// them to the underlying writer.
+type Writer struct {
+	// Precision of the rows; DefaultPrecision unless changed before the
+	// first Write.
+	Precision Precision
+
+	w *csv.Writer
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{Precision: DefaultPrecision, w: csv.NewWriter(w)}
+}
+
+// WriteHeader writes the pre-header and column header rows. It must be
+// called once, before the first record.
+func (w *Writer) WriteHeader(info DeviceInfo) error {
+	if err := w.w.Write(info.PreHeader()); err != nil {
+		return err
+	}
+	return w.w.Write(Header)
+}
+
+// Write writes one record.
+func (w *Writer) Write(r Record) error {
+	return w.w.Write(r.Format(w.Precision))
+}
+
+// Flush writes any buffered rows to the underlying writer and reports any
+// error from this or an earlier write. Once a write has failed, every
+// later one fails too.
+func (w *Writer) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	postBatchSize     = 100
+	postBatchInterval = 30 * time.Second
+
+	// postQueueSize bounds the sightings waiting to be batched. Anything past
+	// it is dropped so the scan callback never blocks.
+	postQueueSize = 1000
+)
+
+// httpPoster batches sightings and POSTs them as a JSON array to a remote
+// collector. Batches that can't be delivered are spooled to disk and replayed
+// in order once the collector is reachable again.
+type httpPoster struct {
+	ctx      context.Context
+	url      string
+	auth     string
+	spoolDir string
+	client   *http.Client
+
+	records chan jsonlRecord
+	dropped atomic.Uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newHTTPPoster starts the sender goroutine. Cancelling ctx aborts in-flight
+// requests, so batches still pending at shutdown go straight to the spool.
+func newHTTPPoster(ctx context.Context, url, auth, spoolDir string) (*httpPoster, error) {
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return nil, err
+	}
+	p := &httpPoster{
+		ctx:      ctx,
+		url:      url,
+		auth:     auth,
+		spoolDir: spoolDir,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		records:  make(chan jsonlRecord, postQueueSize),
+		done:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+func (p *httpPoster) Write(s Sighting) error {
+	select {
+	case p.records <- newJSONLRecord(s):
+	default:
+		p.dropped.Add(1)
+	}
+	return nil
+}
+
+func (p *httpPoster) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(postBatchInterval)
+	defer ticker.Stop()
+
+	var batch []jsonlRecord
+	for {
+		select {
+		case r := <-p.records:
+			batch = append(batch, r)
+			if len(batch) < postBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-p.done:
+			// Send whatever is still queued as one last batch.
+			for len(p.records) > 0 {
+				batch = append(batch, <-p.records)
+			}
+			p.send(batch)
+			return
+		}
+
+		p.send(batch)
+		batch = nil
+	}
+}
+
+// send delivers a batch, replaying spooled batches first so the collector
+// receives everything in order. Undeliverable batches go to the spool.
+func (p *httpPoster) send(batch []jsonlRecord) {
+	delivered := p.replaySpool()
+
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logWarn("failed to encode POST batch: %v", err)
+		return
+	}
+
+	if delivered {
+		err = p.post(body)
+		if err == nil {
+			return
+		}
+		logWarn("POST to collector failed, spooling batch: %v", err)
+	}
+
+	name := fmt.Sprintf("%020d.json", time.Now().UnixNano())
+	if err := os.WriteFile(filepath.Join(p.spoolDir, name), body, 0644); err != nil {
+		logWarn("failed to spool POST batch: %v", err)
+	}
+}
+
+// replaySpool posts spooled batches oldest first, stopping at the first
+// failure. It reports whether the spool is now empty.
+func (p *httpPoster) replaySpool() bool {
+	files, err := filepath.Glob(filepath.Join(p.spoolDir, "*.json"))
+	if err != nil {
+		logWarn("failed to list POST spool: %v", err)
+		return false
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			logWarn("failed to read spooled batch: %v", err)
+			return false
+		}
+		if err := p.post(body); err != nil {
+			return false
+		}
+		os.Remove(path)
+	}
+	return true
+}
+
+func (p *httpPoster) post(body []byte) error {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.auth != "" {
+		req.Header.Set("Authorization", p.auth)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (p *httpPoster) Close() error {
+	close(p.done)
+	p.wg.Wait()
+	if n := p.dropped.Load(); n > 0 {
+		logWarn("POST queue dropped %d sightings", n)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// version is the application release, injected at build time with
+// -ldflags "-X main.version=1.2.3". When unset the module version from the
+// build info is used instead.
+var version string
+
+// detectDeviceInfo describes the capture device for the WiGLE pre-header
+// from the build and the host, falling back to Pineapple Pager defaults for
+// anything it can't find.
+func detectDeviceInfo() wigle.DeviceInfo {
+	info := wigle.DeviceInfo{
+		AppRelease: appRelease(),
+		Model:      "pineapplepager",
+		Release:    "unknown",
+		Device:     "pineapplepager",
+		Display:    "na",
+		Board:      "na",
+		Brand:      "Hak5",
+	}
+
+	osRelease := readOSRelease("/etc/os-release")
+	if v := osRelease["OPENWRT_DEVICE_PRODUCT"]; v != "" {
+		info.Model = v
+	}
+	if v := osRelease["OPENWRT_BOARD"]; v != "" {
+		info.Board = v
+	}
+	if v := osRelease["OPENWRT_DEVICE_MANUFACTURER"]; v != "" {
+		info.Brand = v
+	}
+
+	// Firmware version is first line in /etc/pineapplepager/version
+	// Second line is branch, discard it.
+	if versionFile, err := os.ReadFile("/etc/pineapplepager/version"); err == nil {
+		if line, _, _ := strings.Cut(string(versionFile), "\n"); line != "" {
+			info.Release = strings.TrimSpace(line)
+		}
+	} else if v := osRelease["VERSION_ID"]; v != "" {
+		info.Release = v
+	} else if procVersion, err := os.ReadFile("/proc/version"); err == nil {
+		// "Linux version 6.6.73 (...)": the kernel release is the third field.
+		if fields := strings.Fields(string(procVersion)); len(fields) >= 3 {
+			info.Release = fields[2]
+		}
+	}
+
+	return info
+}
+
+func appRelease() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "dev"
+}
+
+// readOSRelease parses an os-release(5) file into a map. A missing or
+// unreadable file yields an empty map.
+func readOSRelease(path string) map[string]string {
+	values := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[key] = strings.Trim(value, `"'`)
+	}
+	return values
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// privacyFilter anonymises sightings for capture files that will be shared.
+// MACs are replaced by a salted hash, formatted as a MAC so WiGLE tooling
+// still parses the file; the salt only lives in memory, so the same device
+// can be followed within a session but not across sessions or files from
+// other people. Names are replaced by the device type and coordinates are
+// truncated to a fixed number of decimal places.
+type privacyFilter struct {
+	salt   [32]byte
+	places int
+	scale  float64
+}
+
+func newPrivacyFilter(places int) *privacyFilter {
+	p := &privacyFilter{places: places, scale: math.Pow(10, float64(places))}
+	rand.Read(p.salt[:])
+	return p
+}
+
+// MAC returns the anonymised form of a MAC address.
+func (p *privacyFilter) MAC(addr string) string {
+	sum := sha256.Sum256(append(p.salt[:], strings.ToUpper(addr)...))
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", sum[0], sum[1], sum[2], sum[3], sum[4], sum[5])
+}
+
+// Location truncates a position's coordinates.
+func (p *privacyFilter) Location(loc LocationData) LocationData {
+	loc.Latitude = math.Trunc(loc.Latitude*p.scale) / p.scale
+	loc.Longitude = math.Trunc(loc.Longitude*p.scale) / p.scale
+	return loc
+}
+
+// Sighting returns the anonymised sighting. The rebuilt advertisement is
+// dropped as it carries the name and often addresses.
+func (p *privacyFilter) Sighting(s Sighting) Sighting {
+	s.Address = p.MAC(s.Address)
+	s.Name = deviceTypeLegend(s.Class & 0x1FFC)
+	if s.Type == "WIFI" {
+		s.Name = "" // an SSID often names its owner
+	}
+	s.Location = p.Location(s.Location)
+	s.Raw = nil
+	return s
+}
+
+// String describes what was done, for the session summary.
+func (p *privacyFilter) String() string {
+	return fmt.Sprintf("Privacy mode was active: MACs are salted hashes, names are device types, "+
+		"coordinates are truncated to %d decimal places", p.places)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// propCacheTTL is how long a device's BlueZ properties are reused
+	// before they are fetched again.
+	propCacheTTL = 30 * time.Second
+	// propCacheNegativeTTL is how long a failed lookup, usually a device
+	// BlueZ has already forgotten, is remembered.
+	propCacheNegativeTTL = 5 * time.Second
+	// propCacheQueue bounds the lookups waiting for the worker; misses
+	// beyond it are retried on the device's next advertisement.
+	propCacheQueue = 256
+)
+
+// propCache holds BlueZ Device1 properties per device so the scan callback
+// never waits on D-Bus. The LE scanners keep it up to date from the
+// InterfacesAdded and PropertiesChanged signals they already follow, which
+// also brings in properties BlueZ learns late, such as a Class that turns
+// up a few seconds after the first advertisement. Anything else, e.g. a
+// device only classic discovery found, is fetched: a lookup that misses
+// returns whatever is cached, possibly nothing, and queues a fetch for a
+// background worker so the device's next advertisement finds it.
+type propCache struct {
+	bus *systemBus
+
+	mu      sync.Mutex
+	entries map[propKey]propEntry
+	pending map[propKey]bool
+	queue   chan propKey
+
+	hits, misses atomic.Uint64
+}
+
+type propKey struct {
+	adapter, addr string
+}
+
+type propEntry struct {
+	props   map[string]dbus.Variant // nil if the lookup failed
+	expires time.Time               // zero for entries kept up to date by signals
+}
+
+func newPropCache(bus *systemBus) *propCache {
+	return &propCache{
+		bus:     bus,
+		entries: make(map[propKey]propEntry),
+		pending: make(map[propKey]bool),
+		queue:   make(chan propKey, propCacheQueue),
+	}
+}
+
+// Get returns the cached properties of a device and whether there was a
+// fresh entry for it.
+func (c *propCache) Get(adapterID, addr string) (map[string]dbus.Variant, bool) {
+	key := propKey{adapterID, addr}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		c.hits.Add(1)
+		return e.props, true
+	}
+	c.misses.Add(1)
+	if !c.pending[key] {
+		select {
+		case c.queue <- key:
+			c.pending[key] = true
+		default:
+		}
+	}
+	return e.props, false
+}
+
+// Update stores a device's properties as sent by BlueZ. props is copied, so
+// the caller may keep changing it.
+func (c *propCache) Update(adapterID string, props map[string]dbus.Variant) {
+	addr, _ := props["Address"].Value().(string)
+	if addr == "" {
+		return
+	}
+	c.mu.Lock()
+	c.entries[propKey{adapterID, addr}] = propEntry{props: maps.Clone(props)}
+	c.mu.Unlock()
+}
+
+// Remove forgets a device BlueZ has removed.
+func (c *propCache) Remove(adapterID, addr string) {
+	c.mu.Lock()
+	delete(c.entries, propKey{adapterID, addr})
+	c.mu.Unlock()
+}
+
+// Run fetches queued lookups until ctx is cancelled.
+func (c *propCache) Run(ctx context.Context) {
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-c.queue:
+			// While the bus is down this fails fast and is cached as a
+			// failed lookup, leaving the device's class unknown.
+			var props map[string]dbus.Variant
+			if conn, err := c.bus.Conn(); err == nil {
+				props = getDeviceProperties(conn, key.adapter, key.addr)
+			}
+			ttl := propCacheTTL
+			if props == nil {
+				ttl = propCacheNegativeTTL
+			}
+			c.mu.Lock()
+			// A scanner may have filled the entry in the meantime.
+			if e, ok := c.entries[key]; !ok || !e.expires.IsZero() {
+				c.entries[key] = propEntry{props: props, expires: time.Now().Add(ttl)}
+			}
+			delete(c.pending, key)
+			c.mu.Unlock()
+		case now := <-prune.C:
+			// Random addresses rotate every few minutes; drop fetched
+			// entries long expired so the map doesn't grow all session.
+			c.mu.Lock()
+			for key, e := range c.entries {
+				if !e.expires.IsZero() && now.Sub(e.expires) > 10*propCacheTTL {
+					delete(c.entries, key)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns the number of cache hits and misses.
+func (c *propCache) Stats() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
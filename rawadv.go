@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+// AD types used when rebuilding an advertisement.
+const (
+	adCompleteUUID16  = 0x03
+	adCompleteUUID128 = 0x07
+	adCompleteName    = 0x09
+	adServiceData16   = 0x16
+	adServiceData128  = 0x21
+	adManufacturer    = 0xFF
+)
+
+// rawAdvertisement returns the advertisement as a sequence of AD structures.
+// BlueZ doesn't hand over the PDU itself, and it merges the scan response
+// into the advertisement, so the structures are rebuilt from the decoded
+// fields plus whatever BlueZ exposes in AdvertisingData.
+func rawAdvertisement(payload bluetooth.AdvertisementPayload, extra map[byte][]byte) []byte {
+	if b := payload.Bytes(); b != nil {
+		return b
+	}
+
+	var out []byte
+	seen := make(map[byte]bool)
+	add := func(typ byte, data []byte) {
+		seen[typ] = true
+		// An AD structure's length byte covers the type too, so longer
+		// data is split over several structures of the same type.
+		for len(data) > 254 {
+			out = append(out, 255, typ)
+			out = append(out, data[:254]...)
+			data = data[254:]
+		}
+		out = append(out, byte(len(data)+1), typ)
+		out = append(out, data...)
+	}
+
+	var uuid16, uuid128 []byte
+	for _, u := range payload.ServiceUUIDs() {
+		if u.Is16Bit() {
+			v := u.Get16Bit()
+			uuid16 = append(uuid16, byte(v), byte(v>>8))
+			continue
+		}
+		b := u.Bytes()
+		uuid128 = append(uuid128, b[:]...)
+	}
+	if len(uuid16) > 0 {
+		add(adCompleteUUID16, uuid16)
+	}
+	if len(uuid128) > 0 {
+		add(adCompleteUUID128, uuid128)
+	}
+
+	if name := payload.LocalName(); name != "" {
+		add(adCompleteName, []byte(name))
+	}
+
+	for _, sd := range payload.ServiceData() {
+		if sd.UUID.Is16Bit() {
+			v := sd.UUID.Get16Bit()
+			add(adServiceData16, append([]byte{byte(v), byte(v >> 8)}, sd.Data...))
+			continue
+		}
+		b := sd.UUID.Bytes()
+		add(adServiceData128, append(b[:], sd.Data...))
+	}
+
+	for _, md := range payload.ManufacturerData() {
+		add(adManufacturer, append([]byte{byte(md.CompanyID), byte(md.CompanyID >> 8)}, md.Data...))
+	}
+
+	types := make([]byte, 0, len(extra))
+	for typ := range extra {
+		types = append(types, typ)
+	}
+	slices.Sort(types)
+	for _, typ := range types {
+		if !seen[typ] {
+			add(typ, extra[typ])
+		}
+	}
+	return out
+}
+
+// advertisingData extracts BlueZ's AdvertisingData property, which holds AD
+// types not otherwise decoded (such as flags), from a device's properties.
+func advertisingData(props map[string]dbus.Variant) map[byte][]byte {
+	raw, ok := props["AdvertisingData"].Value().(map[byte]dbus.Variant)
+	if !ok {
+		return nil
+	}
+	data := make(map[byte][]byte, len(raw))
+	for typ, value := range raw {
+		if b, ok := value.Value().([]byte); ok {
+			data[typ] = b
+		}
+	}
+	return data
+}
+
+// rawLogWriter writes one line per sighting with its advertisement as hex.
+type rawLogWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newRawLogWriter(path string) (*rawLogWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &rawLogWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (r *rawLogWriter) Write(s Sighting) error {
+	if s.Raw == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(r.w, "%s %s %d %s\n",
+		s.Timestamp.Format(time.RFC3339Nano), s.Address, s.RSSI, hex.EncodeToString(s.Raw))
+	if err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *rawLogWriter) Close() error {
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// resolveTimeout bounds one connection, from Connect to the last read.
+	resolveTimeout = 15 * time.Second
+	// resolveQueue bounds the devices waiting for a connection; others
+	// are asked for again on their next advertisement.
+	resolveQueue = 64
+)
+
+// GATT characteristics read from a nameless device: the GAP Device Name and
+// the Device Information Service's manufacturer and model strings.
+const (
+	gattDeviceName       = "00002a00-0000-1000-8000-00805f9b34fb"
+	gattManufacturerName = "00002a29-0000-1000-8000-00805f9b34fb"
+	gattModelNumber      = "00002a24-0000-1000-8000-00805f9b34fb"
+)
+
+// nameResolver connects to BLE devices that advertise no name and reads one
+// over GATT. Connections are made by background workers, at most one per
+// worker and no more often than the rate limit overall, so the scan never
+// waits on them. A device is tried again only after the cooldown, whether
+// the last attempt failed or found nothing.
+type nameResolver struct {
+	bus      *systemBus
+	workers  int
+	cooldown time.Duration
+	interval time.Duration // minimum time between connection attempts
+
+	mu      sync.Mutex
+	names   map[string]string    // by address
+	next    map[string]time.Time // by address, when it may be tried again
+	pending map[string]bool
+	queue   chan propKey
+
+	attempts, resolved atomic.Uint64
+}
+
+func newNameResolver(bus *systemBus, workers int, cooldown, interval time.Duration) *nameResolver {
+	return &nameResolver{
+		bus:      bus,
+		workers:  workers,
+		cooldown: cooldown,
+		interval: interval,
+		names:    make(map[string]string),
+		next:     make(map[string]time.Time),
+		pending:  make(map[string]bool),
+		queue:    make(chan propKey, resolveQueue),
+	}
+}
+
+// Lookup returns the name resolved for a device, if any.
+func (r *nameResolver) Lookup(addr string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := r.names[addr]
+	return name, name != ""
+}
+
+// Request queues a device for a connection unless it is queued already,
+// cooling down or the queue is full.
+func (r *nameResolver) Request(adapterID, addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[addr] || time.Now().Before(r.next[addr]) {
+		return
+	}
+	select {
+	case r.queue <- propKey{adapterID, addr}:
+		r.pending[addr] = true
+	default:
+	}
+}
+
+// Forget drops what is known about the given devices, once the device
+// evictor has forgotten them, along with the cooldowns that have passed.
+// Under address randomisation both maps would otherwise keep growing.
+func (r *nameResolver) Forget(addrs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, addr := range addrs {
+		delete(r.names, addr)
+	}
+	now := time.Now()
+	for addr, next := range r.next {
+		if !now.Before(next) {
+			delete(r.next, addr)
+		}
+	}
+}
+
+// Stats returns the number of connection attempts and names found.
+func (r *nameResolver) Stats() (attempts, resolved uint64) {
+	return r.attempts.Load(), r.resolved.Load()
+}
+
+// Run starts the workers and returns once ctx is cancelled and they have
+// finished.
+func (r *nameResolver) Run(ctx context.Context) {
+	limit := time.NewTicker(r.interval)
+	defer limit.Stop()
+
+	var wg sync.WaitGroup
+	for range r.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var key propKey
+				select {
+				case <-ctx.Done():
+					return
+				case key = <-r.queue:
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-limit.C:
+				}
+				name := r.resolve(ctx, key)
+				r.mu.Lock()
+				if name != "" {
+					r.names[key.addr] = name
+				}
+				r.next[key.addr] = time.Now().Add(r.cooldown)
+				delete(r.pending, key.addr)
+				r.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// resolve connects to a device and reads its name, falling back to its
+// manufacturer and model. It returns "" if it found neither.
+func (r *nameResolver) resolve(ctx context.Context, key propKey) string {
+	conn, err := r.bus.Conn()
+	if err != nil {
+		return ""
+	}
+	// Leave the host's own devices alone: disconnecting afterwards would
+	// cut off a connection someone else made.
+	props := getDeviceProperties(conn, key.adapter, key.addr)
+	if props == nil || keepDevice(props) {
+		return ""
+	}
+	r.attempts.Add(1)
+	path := devicePath(key.adapter, key.addr)
+	device := conn.Object("org.bluez", path)
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	// Disconnect even if Connect timed out, in case it went through late.
+	defer callTimeout(device, dbusSlowTimeout, "org.bluez.Device1.Disconnect")
+	if err := device.CallWithContext(ctx, "org.bluez.Device1.Connect", 0).Err; err != nil {
+		logDebug("failed to connect to %s to resolve its name: %v", key.addr, err)
+		return ""
+	}
+
+	// The characteristics only appear once BlueZ has discovered the
+	// services.
+	for {
+		v, err := getProperty(device, "org.bluez.Device1.ServicesResolved")
+		if err != nil {
+			logDebug("failed to read ServicesResolved of %s: %v", key.addr, err)
+			return ""
+		}
+		if resolved, _ := v.Value().(bool); resolved {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	objects, err := managedObjects(conn)
+	if err != nil {
+		logWarn("failed to list BlueZ objects: %v", err)
+		return ""
+	}
+	chars := make(map[string]dbus.ObjectPath)
+	for p, ifaces := range objects {
+		props, ok := ifaces["org.bluez.GattCharacteristic1"]
+		if !ok || !strings.HasPrefix(string(p), string(path)+"/") {
+			continue
+		}
+		if uuid, ok := props["UUID"].Value().(string); ok {
+			chars[strings.ToLower(uuid)] = p
+		}
+	}
+	read := func(uuid string) string {
+		p, ok := chars[uuid]
+		if !ok || ctx.Err() != nil {
+			return ""
+		}
+		var value []byte
+		err := callTimeout(conn.Object("org.bluez", p), dbusSlowTimeout,
+			"org.bluez.GattCharacteristic1.ReadValue", map[string]dbus.Variant{}).Store(&value)
+		if err != nil {
+			logDebug("failed to read characteristic %s of %s: %v", uuid, key.addr, err)
+			return ""
+		}
+		return gattString(value)
+	}
+
+	name := read(gattDeviceName)
+	if name == "" {
+		name = strings.TrimSpace(read(gattManufacturerName) + " " + read(gattModelNumber))
+	}
+	if name != "" {
+		r.resolved.Add(1)
+	}
+	return name
+}
+
+// gattString decodes a UTF-8 string characteristic. Some devices pad it
+// with NULs or spaces.
+func gattString(b []byte) string {
+	return strings.TrimSpace(strings.ToValidUTF8(strings.TrimRight(string(b), "\x00"), ""))
+}
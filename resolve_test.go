@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNameResolverForget(t *testing.T) {
+	r := newNameResolver(nil, 1, time.Hour, time.Second)
+	r.names["00:11:22:33:44:01"] = "Speaker"
+	r.names["00:11:22:33:44:02"] = "Watch"
+	r.next["00:11:22:33:44:01"] = time.Now().Add(time.Hour)
+	r.next["00:11:22:33:44:03"] = time.Now().Add(-time.Second)
+
+	r.Forget([]string{"00:11:22:33:44:01"})
+	if _, ok := r.Lookup("00:11:22:33:44:01"); ok {
+		t.Error("evicted device's name kept")
+	}
+	if name, ok := r.Lookup("00:11:22:33:44:02"); !ok || name != "Watch" {
+		t.Errorf("Lookup = %q, %v, want the name of a device still tracked", name, ok)
+	}
+	// A cooldown still running is kept even for an evicted device, so it
+	// isn't connected to again at once when it turns up.
+	if _, ok := r.next["00:11:22:33:44:01"]; !ok {
+		t.Error("running cooldown dropped")
+	}
+	if _, ok := r.next["00:11:22:33:44:03"]; ok {
+		t.Error("finished cooldown kept")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Scanner reports the BLE advertisements heard on one adapter. leScanner is
+// the BlueZ one; fakeScanner plays a script.
+type Scanner interface {
+	// ID names the adapter, e.g. "hci0".
+	ID() string
+	// Scan calls callback with every advertisement until ctx is cancelled,
+	// Stop is called or scanning fails.
+	Scan(ctx context.Context, callback func(Advertisement)) error
+	// Stop ends a running Scan.
+	Stop() error
+	Close() error
+}
+
+// Advertisement is one advertisement as a Scanner reports it, free of the
+// D-Bus details of where it came from.
+type Advertisement struct {
+	Adapter          string
+	Address          string // e.g. "AA:BB:CC:DD:EE:FF"
+	Random           bool   // a random rather than public address
+	Name             string
+	RSSI             int16
+	ManufacturerData []bluetooth.ManufacturerDataElement
+	ServiceUUIDs     []bluetooth.UUID
+	ServiceData      []bluetooth.ServiceDataElement
+	Timestamp        time.Time // system time it was received
+}
+
+// Payload returns the advertised fields in the form the beacon and tracker
+// parsers take.
+func (a Advertisement) Payload() bluetooth.AdvertisementPayload {
+	return &advertisement{name: a.Name, uuids: a.ServiceUUIDs, mfgr: a.ManufacturerData, service: a.ServiceData}
+}
+
+// AddressType classifies the address, see leAddressType.
+func (a Advertisement) AddressType() string {
+	var msb uint64
+	if len(a.Address) >= 2 {
+		msb, _ = strconv.ParseUint(a.Address[:2], 16, 8)
+	}
+	return leAddressType(a.Random, byte(msb))
+}
+
+// advertisement is the decoded advertisement BlueZ exposes for a device.
+type advertisement struct {
+	name    string
+	uuids   []bluetooth.UUID
+	mfgr    []bluetooth.ManufacturerDataElement
+	service []bluetooth.ServiceDataElement
+}
+
+func (a *advertisement) LocalName() string { return a.name }
+
+func (a *advertisement) HasServiceUUID(uuid bluetooth.UUID) bool {
+	for _, u := range a.uuids {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *advertisement) ServiceUUIDs() []bluetooth.UUID { return a.uuids }
+
+// Bytes returns nil: BlueZ doesn't pass on the raw advertisement.
+func (a *advertisement) Bytes() []byte { return nil }
+
+func (a *advertisement) ManufacturerData() []bluetooth.ManufacturerDataElement { return a.mfgr }
+
+func (a *advertisement) ServiceData() []bluetooth.ServiceDataElement { return a.service }
+
+// fakeAdvertisement is one step of a fakeScanner script.
+type fakeAdvertisement struct {
+	After         time.Duration // wait this long after the previous step
+	Advertisement Advertisement
+}
+
+// fakeScanner is a Scanner that needs no radio: Scan plays its script, each
+// advertisement stamped with the adapter and the time it is sent, then waits
+// to be stopped. Tests drive the scan pipeline with it.
+type fakeScanner struct {
+	id     string
+	script []fakeAdvertisement
+
+	mu     sync.Mutex
+	cancel chan struct{} // closed by Stop; nil when not scanning
+}
+
+func (f *fakeScanner) ID() string { return f.id }
+
+func (f *fakeScanner) Scan(ctx context.Context, callback func(Advertisement)) error {
+	f.mu.Lock()
+	if f.cancel != nil {
+		f.mu.Unlock()
+		return errors.New("already scanning")
+	}
+	cancel := make(chan struct{})
+	f.cancel = cancel
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		if f.cancel == cancel {
+			f.cancel = nil
+		}
+		f.mu.Unlock()
+	}()
+
+	for _, step := range f.script {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-cancel:
+			return nil
+		case <-time.After(step.After):
+		}
+		adv := step.Advertisement
+		adv.Adapter = f.id
+		adv.Timestamp = time.Now()
+		callback(adv)
+	}
+	select {
+	case <-ctx.Done():
+	case <-cancel:
+	}
+	return nil
+}
+
+func (f *fakeScanner) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancel == nil {
+		return errors.New("not scanning")
+	}
+	close(f.cancel)
+	f.cancel = nil
+	return nil
+}
+
+func (f *fakeScanner) Close() error { return nil }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// maxServiceTags caps how many services are listed in the capabilities
+// string; the rest are summarized as "+N".
+const maxServiceTags = 4
+
+// serviceNames names common 16-bit service UUIDs from the Bluetooth SIG
+// assigned numbers, plus member UUIDs that show up often in the wild.
+var serviceNames = map[uint16]string{
+	0x1800: "Generic Access",
+	0x1801: "Generic Attribute",
+	0x1802: "Immediate Alert",
+	0x1803: "Link Loss",
+	0x1804: "Tx Power",
+	0x1805: "Current Time",
+	0x1809: "Health Thermometer",
+	0x180A: "Device Information",
+	0x180D: "Heart Rate",
+	0x180F: "Battery",
+	0x1810: "Blood Pressure",
+	0x1812: "HID",
+	0x1814: "Running Speed",
+	0x1816: "Cycling Speed",
+	0x1818: "Cycling Power",
+	0x1819: "Location",
+	0x181A: "Environmental Sensing",
+	0x181C: "User Data",
+	0x181D: "Weight Scale",
+	0x1822: "Pulse Oximeter",
+	0x1826: "Fitness Machine",
+	0x183B: "Binary Sensor",
+	0x1844: "Volume Control",
+	0x184E: "Audio Stream Control",
+	0x1853: "Common Audio",
+	0xFD6F: "Exposure Notification",
+	0xFE2C: "Google Fast Pair",
+	0xFE9F: "Google",
+	0xFEAA: "Eddystone",
+	0xFEED: "Tile",
+	0xFD5A: "Samsung SmartTag",
+	0xFE07: "Sonos",
+	0xFE95: "Xiaomi",
+}
+
+// serviceTag returns a capabilities suffix listing the advertised services,
+// e.g. "[Battery,Heart Rate,0xFE50,+1]". Only 16-bit UUIDs are listed; 128-bit
+// vendor UUIDs and anything past maxServiceTags are just counted.
+func serviceTag(uuids []bluetooth.UUID) string {
+	if len(uuids) == 0 {
+		return ""
+	}
+
+	var names []string
+	other := 0
+	for _, uuid := range uuids {
+		if !uuid.Is16Bit() || len(names) == maxServiceTags {
+			other++
+			continue
+		}
+		short := uuid.Get16Bit()
+		if name, ok := serviceNames[short]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("0x%04X", short))
+		}
+	}
+	if other > 0 {
+		names = append(names, fmt.Sprintf("+%d", other))
+	}
+	return "[" + strings.Join(names, ",") + "]"
+}
+
+// exposureNotificationUUID is the Google/Apple Exposure Notification service.
+const exposureNotificationUUID = 0xFD6F
+
+// isExposureNotification reports whether the advertisement is an Exposure
+// Notification beacon, which phones send with a rotating identifier.
+func isExposureNotification(payload bluetooth.AdvertisementPayload) bool {
+	uuid := bluetooth.New16BitUUID(exposureNotificationUUID)
+	if payload.HasServiceUUID(uuid) {
+		return true
+	}
+	for _, sd := range payload.ServiceData() {
+		if sd.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// addressSet is a set of addresses that the scan goroutines of several
+// adapters can add to at once.
+type addressSet struct {
+	mu    sync.Mutex
+	addrs map[string]struct{}
+}
+
+func (s *addressSet) Add(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addrs == nil {
+		s.addrs = make(map[string]struct{})
+	}
+	s.addrs[addr] = struct{}{}
+}
+
+// Len returns the number of addresses in the set.
+func (s *addressSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.addrs)
+}
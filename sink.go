@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Sink receives logged sightings.
+type Sink interface {
+	Write(Sighting) error
+	Close() error
+}
+
+// flusher is a Sink that buffers writes and can push them out on request.
+type flusher interface {
+	Flush() error
+}
+
+// sinkBufferSize is how many sightings may queue up for a single sink before
+// new ones are dropped. It is sized to ride out bursts in crowded places.
+const sinkBufferSize = 1024
+
+// teeSink fans sightings out to several sinks. Each sink is drained by its own
+// goroutine from a bounded buffer, so a slow sink stalls neither the scan
+// callback nor the other sinks. Sightings that don't fit are dropped and
+// counted.
+type teeSink struct {
+	outputs []*sinkOutput
+}
+
+type sinkOutput struct {
+	name    string
+	sink    Sink
+	ch      chan Sighting
+	dropped atomic.Uint64
+	flush   chan chan error
+	done    chan struct{}
+}
+
+func newTeeSink() *teeSink {
+	return &teeSink{}
+}
+
+// Add registers a sink. All sinks must be added before the first Write.
+func (t *teeSink) Add(name string, sink Sink) {
+	out := &sinkOutput{
+		name:  name,
+		sink:  sink,
+		ch:    make(chan Sighting, sinkBufferSize),
+		flush: make(chan chan error),
+		done:  make(chan struct{}),
+	}
+	t.outputs = append(t.outputs, out)
+	go out.run()
+}
+
+func (o *sinkOutput) run() {
+	defer close(o.done)
+	for {
+		select {
+		case s, ok := <-o.ch:
+			if !ok {
+				return
+			}
+			o.write(s)
+		case reply := <-o.flush:
+			// Write what was queued before the request first.
+			for range len(o.ch) {
+				if s, ok := <-o.ch; ok {
+					o.write(s)
+				}
+			}
+			var err error
+			if f, ok := o.sink.(flusher); ok {
+				err = f.Flush()
+			}
+			reply <- err
+		}
+	}
+}
+
+func (o *sinkOutput) write(s Sighting) {
+	if err := o.sink.Write(s); err != nil {
+		logWarn("failed to write to %s: %v", o.name, err)
+	}
+}
+
+// Write queues s for every sink without blocking.
+func (t *teeSink) Write(s Sighting) error {
+	for _, out := range t.outputs {
+		select {
+		case out.ch <- s:
+		default:
+			out.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of sightings dropped across all sinks.
+func (t *teeSink) Dropped() uint64 {
+	var n uint64
+	for _, out := range t.outputs {
+		n += out.dropped.Load()
+	}
+	return n
+}
+
+// Flush writes out every sink's buffer, including data a sink buffers
+// itself. Sinks already closed are skipped.
+func (t *teeSink) Flush() error {
+	var errs []error
+	for _, out := range t.outputs {
+		if err := out.flushQueue(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", out.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FlushSink is Flush for sink alone, so that everything queued for it so
+// far has been written when it returns.
+func (t *teeSink) FlushSink(sink Sink) error {
+	for _, out := range t.outputs {
+		if out.sink == sink {
+			return out.flushQueue()
+		}
+	}
+	return nil
+}
+
+// flushQueue has the output's goroutine write its queue and flush the sink,
+// unless it has finished.
+func (o *sinkOutput) flushQueue() error {
+	reply := make(chan error)
+	select {
+	case o.flush <- reply:
+	case <-o.done:
+		return nil
+	}
+	return <-reply
+}
+
+// Close drains every sink's buffer and then closes the sinks.
+func (t *teeSink) Close() error {
+	var errs []error
+	for _, out := range t.outputs {
+		close(out.ch)
+	}
+	for _, out := range t.outputs {
+		<-out.done
+		if n := out.dropped.Load(); n > 0 {
+			logWarn("%s dropped %d sightings because it fell behind", out.name, n)
+		}
+		if err := out.sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", out.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/stratoberry/go-gpsd"
+)
+
+// skyMonitor tracks satellite use and dilution of precision from gpsd's SKY
+// reports, warning when the constellation is too poor for useful positions,
+// typically because of antenna placement.
+type skyMonitor struct {
+	minSats int
+	maxHDOP float64
+
+	mu       sync.Mutex
+	used     int
+	visible  int
+	hdop     float64
+	vdop     float64
+	degraded bool
+}
+
+// Update folds in a SKY report. gpsd sends some SKY reports with only the
+// DOPs and no satellite list, so a missing list keeps the previous counts.
+func (m *skyMonitor) Update(r *gpsd.SKYReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(r.Satellites) > 0 {
+		m.visible = len(r.Satellites)
+		m.used = 0
+		for _, sat := range r.Satellites {
+			if sat.Used {
+				m.used++
+			}
+		}
+	}
+	if r.Hdop > 0 {
+		m.hdop = r.Hdop
+	}
+	if r.Vdop > 0 {
+		m.vdop = r.Vdop
+	}
+
+	degraded := (m.minSats > 0 && m.visible > 0 && m.used < m.minSats) ||
+		(m.maxHDOP > 0 && m.hdop > m.maxHDOP)
+	switch {
+	case degraded && !m.degraded:
+		logWarn("poor GPS reception (%s); check the antenna", m.summary())
+	case !degraded && m.degraded:
+		logInfo("GPS reception recovered (%s)", m.summary())
+	}
+	m.degraded = degraded
+}
+
+// String describes the current constellation, e.g. "7/12 satellites,
+// HDOP 1.2, VDOP 1.8".
+func (m *skyMonitor) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.summary()
+}
+
+// Counts returns the satellites used and visible and the HDOP.
+func (m *skyMonitor) Counts() (used, visible int, hdop float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used, m.visible, m.hdop
+}
+
+// summary is String with m.mu held.
+func (m *skyMonitor) summary() string {
+	return fmt.Sprintf("%d/%d satellites, HDOP %.1f, VDOP %.1f", m.used, m.visible, m.hdop, m.vdop)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rssiEMAReset is how long a device may go unseen before its smoothed RSSI
+// starts over from the next raw reading.
+const rssiEMAReset = 5 * time.Minute
+
+// rssiSmoother keeps an exponentially weighted moving average of RSSI per
+// device, taking the edge off the ±10 dB jitter between advertisements.
+type rssiSmoother struct {
+	alpha float64 // weight of the newest reading
+
+	mu    sync.Mutex
+	state map[string]emaState
+}
+
+type emaState struct {
+	value float64
+	at    time.Time
+}
+
+func newRSSISmoother(alpha float64) *rssiSmoother {
+	return &rssiSmoother{alpha: alpha, state: make(map[string]emaState)}
+}
+
+// Add folds a reading into addr's average and returns the new average.
+func (r *rssiSmoother) Add(addr string, rssi int16) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	st, ok := r.state[addr]
+	if !ok || now.Sub(st.at) > rssiEMAReset {
+		st.value = float64(rssi)
+	} else {
+		st.value += r.alpha * (float64(rssi) - st.value)
+	}
+	st.at = now
+	r.state[addr] = st
+	return st.value
+}
+
+// Prune forgets devices unseen for longer than rssiEMAReset, whose averages
+// would start over anyway.
+func (r *rssiSmoother) Prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for addr, st := range r.state {
+		if now.Sub(st.at) > rssiEMAReset {
+			delete(r.state, addr)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/ncruces/go-sqlite3/driver"
+)
+
+// sqliteFlushInterval is how often buffered sightings are committed. Batching
+// keeps writes to the Pager's flash storage down.
+const sqliteFlushInterval = 5 * time.Second
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sightings (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	mac          TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	capabilities TEXT NOT NULL,
+	rssi         INTEGER NOT NULL,
+	rssi_smoothed REAL,
+	lat          REAL NOT NULL,
+	lon          REAL NOT NULL,
+	alt          REAL NOT NULL,
+	accuracy     REAL NOT NULL,
+	distance     REAL,
+	first_seen   TEXT NOT NULL,
+	last_seen    TEXT NOT NULL,
+	mfgr_id      TEXT NOT NULL,
+	mfgr_ids     TEXT NOT NULL DEFAULT '',
+	mfgr_name    TEXT NOT NULL DEFAULT '',
+	type         TEXT NOT NULL,
+	adapter      TEXT NOT NULL DEFAULT '',
+	time_source  TEXT NOT NULL DEFAULT '',
+	speed        REAL,
+	course       REAL,
+	connectable  INTEGER NOT NULL DEFAULT 0,
+	adv_flags    TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS sightings_mac ON sightings (mac);
+CREATE TABLE IF NOT EXISTS devices (
+	mac           TEXT PRIMARY KEY,
+	name          TEXT NOT NULL,
+	capabilities  TEXT NOT NULL,
+	first_seen    TEXT NOT NULL,
+	last_seen     TEXT NOT NULL,
+	mfgr_id       TEXT NOT NULL,
+	mfgr_ids      TEXT NOT NULL DEFAULT '',
+	mfgr_name     TEXT NOT NULL DEFAULT '',
+	type          TEXT NOT NULL,
+	best_rssi     INTEGER NOT NULL,
+	best_lat      REAL NOT NULL,
+	best_lon      REAL NOT NULL,
+	best_alt      REAL NOT NULL,
+	best_accuracy REAL NOT NULL
+);
+`
+
+const sqliteInsertSighting = `
+INSERT INTO sightings (mac, name, capabilities, rssi, rssi_smoothed, lat, lon, alt, accuracy, distance,
+	first_seen, last_seen, mfgr_id, mfgr_ids, mfgr_name, type, adapter, time_source, speed, course,
+	connectable, adv_flags)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// The devices row keeps the earliest first_seen across sessions and the
+// position of the strongest observation.
+const sqliteUpsertDevice = `
+INSERT INTO devices (mac, name, capabilities, first_seen, last_seen, mfgr_id, mfgr_ids, mfgr_name, type,
+	best_rssi, best_lat, best_lon, best_alt, best_accuracy)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (mac) DO UPDATE SET
+	name          = CASE WHEN excluded.name != '' THEN excluded.name ELSE devices.name END,
+	capabilities  = excluded.capabilities,
+	first_seen    = min(devices.first_seen, excluded.first_seen),
+	last_seen     = max(devices.last_seen, excluded.last_seen),
+	mfgr_id       = CASE WHEN excluded.mfgr_id != '' THEN excluded.mfgr_id ELSE devices.mfgr_id END,
+	mfgr_ids      = CASE WHEN excluded.mfgr_ids != '' THEN excluded.mfgr_ids ELSE devices.mfgr_ids END,
+	mfgr_name     = CASE WHEN excluded.mfgr_name != '' THEN excluded.mfgr_name ELSE devices.mfgr_name END,
+	type          = excluded.type,
+	best_lat      = CASE WHEN excluded.best_rssi > devices.best_rssi THEN excluded.best_lat ELSE devices.best_lat END,
+	best_lon      = CASE WHEN excluded.best_rssi > devices.best_rssi THEN excluded.best_lon ELSE devices.best_lon END,
+	best_alt      = CASE WHEN excluded.best_rssi > devices.best_rssi THEN excluded.best_alt ELSE devices.best_alt END,
+	best_accuracy = CASE WHEN excluded.best_rssi > devices.best_rssi THEN excluded.best_accuracy ELSE devices.best_accuracy END,
+	best_rssi     = max(devices.best_rssi, excluded.best_rssi)`
+
+// sqliteWriter buffers sightings in memory and commits them to a SQLite
+// database in one transaction per flush interval.
+type sqliteWriter struct {
+	db      *sql.DB
+	flushMu sync.Mutex // one commit at a time
+
+	mu      sync.Mutex
+	pending []Sighting
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newSQLiteWriter(path string) (*sqliteWriter, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate schema: %w", err)
+	}
+
+	w := &sqliteWriter{db: db, done: make(chan struct{})}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// sqliteAddedColumns lists columns added to the schema after its first
+// release. Databases created before then get them on open.
+var sqliteAddedColumns = []struct{ table, column, decl string }{
+	{"sightings", "mfgr_name", "TEXT NOT NULL DEFAULT ''"},
+	{"devices", "mfgr_name", "TEXT NOT NULL DEFAULT ''"},
+	{"sightings", "mfgr_ids", "TEXT NOT NULL DEFAULT ''"},
+	{"devices", "mfgr_ids", "TEXT NOT NULL DEFAULT ''"},
+	{"sightings", "distance", "REAL"},
+	{"sightings", "rssi_smoothed", "REAL"},
+	{"sightings", "adapter", "TEXT NOT NULL DEFAULT ''"},
+	{"sightings", "time_source", "TEXT NOT NULL DEFAULT ''"},
+	{"sightings", "speed", "REAL"},
+	{"sightings", "course", "REAL"},
+	{"sightings", "connectable", "INTEGER NOT NULL DEFAULT 0"},
+	{"sightings", "adv_flags", "TEXT NOT NULL DEFAULT ''"},
+}
+
+func migrateSQLite(db *sql.DB) error {
+	for _, c := range sqliteAddedColumns {
+		var n int
+		err := db.QueryRow("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.decl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *sqliteWriter) Write(s Sighting) error {
+	w.mu.Lock()
+	w.pending = append(w.pending, s)
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *sqliteWriter) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(sqliteFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.flush(); err != nil {
+				logWarn("failed to write SQLite batch: %v", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Flush commits pending sightings now rather than at the next interval.
+func (w *sqliteWriter) Flush() error {
+	return w.flush()
+}
+
+// flush commits all pending sightings in a single transaction. On failure the
+// batch is kept so it is retried on the next flush.
+func (w *sqliteWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := w.commit(batch); err != nil {
+		w.mu.Lock()
+		w.pending = append(batch, w.pending...)
+		w.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (w *sqliteWriter) commit(batch []Sighting) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(sqliteInsertSighting)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	upsert, err := tx.Prepare(sqliteUpsertDevice)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	for _, s := range batch {
+		// Multiple manufacturers are stored as "76,6" and "Apple, Inc.; Microsoft".
+		ids := make([]string, len(s.MfgrIDs))
+		for i, id := range s.MfgrIDs {
+			ids[i] = strconv.Itoa(int(id))
+		}
+		mfgrIDs := strings.Join(ids, ",")
+		mfgrNames := strings.Join(s.MfgrNames, "; ")
+
+		firstSeen := s.FirstSeen.Format("2006-01-02 15:04:05")
+		lastSeen := s.Timestamp.Format("2006-01-02 15:04:05")
+		loc := s.Location
+
+		// Unknown distances are stored as NULL.
+		var distance any
+		if s.Distance > 0 {
+			distance = s.Distance
+		}
+
+		_, err := insert.Exec(s.Address, s.Name, s.Capabilities, s.RSSI, s.SmoothedRSSI,
+			loc.Latitude, loc.Longitude, loc.Altitude, loc.Error, distance,
+			firstSeen, lastSeen, s.MfgrID, mfgrIDs, mfgrNames, s.Type, s.Adapter, timeSource(s), loc.Speed, loc.Track,
+			s.Connectable, s.AdvFlags)
+		if err != nil {
+			return err
+		}
+
+		_, err = upsert.Exec(s.Address, s.Name, s.Capabilities, firstSeen, lastSeen,
+			s.MfgrID, mfgrIDs, mfgrNames, s.Type, s.RSSI, loc.Latitude, loc.Longitude, loc.Altitude, loc.Error)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close commits anything still buffered and closes the database.
+func (w *sqliteWriter) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	err := w.flush()
+	if cerr := w.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
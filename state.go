@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// stateFileName is where the device map is kept between runs, in the
+	// output directory.
+	stateFileName = "wigle-bluetooth-state.json"
+	// stateSaveInterval is how often the device map is saved while running.
+	stateSaveInterval = 5 * time.Minute
+)
+
+// deviceStateFile is the saved device map. It lets a restarted process,
+// e.g. after a crash or a watchdog reboot, keep the FirstSeen of devices it
+// had already seen.
+type deviceStateFile struct {
+	Saved   time.Time     `json:"saved"`
+	Devices []savedDevice `json:"devices"`
+}
+
+// savedDevice is one device in the state file. The position is that of the
+// strongest sighting.
+type savedDevice struct {
+	MAC       string    `json:"mac"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	BestRSSI  int16     `json:"best_rssi,omitempty"`
+	Lat       float64   `json:"lat,omitempty"`
+	Lon       float64   `json:"lon,omitempty"`
+	Alt       float64   `json:"alt,omitempty"`
+}
+
+// loadDeviceState fills the devices map from the state file, skipping
+// devices unseen for longer than retention (0 keeps all). A missing file
+// loads nothing.
+func loadDeviceState(path string, retention time.Duration) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var file deviceStateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	loaded := 0
+	for _, d := range file.Devices {
+		if d.MAC == "" || retention > 0 && now.Sub(d.LastSeen) > retention {
+			continue
+		}
+		dev := &deviceState{FirstSeen: d.FirstSeen, LastSeen: d.LastSeen, Written: make(map[string]writeMark)}
+		if d.BestRSSI != 0 {
+			dev.BestRSSI = d.BestRSSI
+			dev.Best = LocationData{Fix: true, Latitude: d.Lat, Longitude: d.Lon, Altitude: d.Alt}
+		}
+		devices[d.MAC] = dev
+		loaded++
+	}
+	return loaded, nil
+}
+
+// saveDeviceState writes the devices map to the state file. The file is
+// replaced in one go, so a crash mid-save leaves the previous one.
+func saveDeviceState(path string) error {
+	file := deviceStateFile{Saved: time.Now()}
+	devicesMu.Lock()
+	file.Devices = make([]savedDevice, 0, len(devices))
+	for addr, d := range devices {
+		saved := savedDevice{MAC: addr, FirstSeen: d.FirstSeen, LastSeen: d.lastActive()}
+		if d.Best.Fix {
+			saved.BestRSSI = d.BestRSSI
+			saved.Lat, saved.Lon, saved.Alt = d.Best.Latitude, d.Best.Longitude, d.Best.Altitude
+		}
+		file.Devices = append(file.Devices, saved)
+	}
+	devicesMu.Unlock()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// runStateSaver saves the device map every stateSaveInterval until ctx is
+// cancelled. The final save at shutdown is up to the caller.
+func runStateSaver(ctx context.Context, path string) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := saveDeviceState(path); err != nil {
+				logWarn("failed to save device state: %v", err)
+			}
+		}
+	}
+}
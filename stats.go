@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// statsTopDevices is how many of the most seen devices stats lists.
+const statsTopDevices = 20
+
+// captureStats is what the stats subcommand reports over a set of captures.
+type captureStats struct {
+	Files          int           `json:"files"`
+	Rows           int           `json:"rows"`
+	Skipped        int           `json:"rows_skipped"`
+	Devices        int           `json:"unique_devices"`
+	ByType         []statsCount  `json:"by_type"`
+	ByLegend       []statsCount  `json:"by_legend"`
+	ByManufacturer []statsCount  `json:"by_manufacturer"`
+	Top            []statsDevice `json:"top_devices"`
+	FirstSeen      *time.Time    `json:"first_seen"` // nil without rows
+	LastSeen       *time.Time    `json:"last_seen"`
+	Bounds         *statsBounds  `json:"bounds"`
+}
+
+// statsCount is the number of unique devices in one group.
+type statsCount struct {
+	Name    string `json:"name"`
+	Devices int    `json:"devices"`
+}
+
+type statsDevice struct {
+	MAC          string `json:"mac"`
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Legend       string `json:"legend"`
+	Manufacturer string `json:"manufacturer"`
+	Rows         int    `json:"rows"`
+}
+
+// statsBounds is the bounding box of the rows' coordinates.
+type statsBounds struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+// recordLegend returns the deviceTypeLegend name of a Bluetooth row: the one
+// leading its capabilities, or failing that the one for the class in its
+// Frequency column. WiFi rows have none.
+func recordLegend(r wigle.Record) string {
+	if r.Type == wigle.TypeWiFi {
+		return ""
+	}
+	legend, _, _ := strings.Cut(r.AuthMode, "[")
+	legend = strings.TrimSpace(legend)
+	if _, ok := legendClasses()[legend]; ok {
+		return legend
+	}
+	return deviceTypeLegend(uint32(r.Frequency) & 0x1FFC)
+}
+
+// recordManufacturer labels the MfgrId column with the company name.
+func recordManufacturer(r wigle.Record) string {
+	id, err := strconv.ParseUint(r.MfgrID, 10, 16)
+	if err != nil {
+		return r.MfgrID
+	}
+	if name := companyName(uint16(id)); name != r.MfgrID {
+		return name + " (" + r.MfgrID + ")"
+	}
+	return r.MfgrID
+}
+
+// statsCollector gathers captureStats one row at a time, holding one entry
+// per device.
+type statsCollector struct {
+	stats   captureStats
+	devices map[string]*statsDevice // keyed by type and address
+	first   time.Time
+	last    time.Time
+	bounds  statsBounds
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		devices: make(map[string]*statsDevice),
+		bounds:  statsBounds{South: math.Inf(1), West: math.Inf(1), North: math.Inf(-1), East: math.Inf(-1)},
+	}
+}
+
+func (c *statsCollector) add(r wigle.Record) {
+	c.stats.Rows++
+
+	key := r.Type + " " + strings.ToUpper(r.MAC)
+	d, ok := c.devices[key]
+	if !ok {
+		d = &statsDevice{MAC: r.MAC, Type: r.Type}
+		c.devices[key] = d
+	}
+	d.Rows++
+	if r.SSID != "" {
+		d.Name = r.SSID
+	}
+	if legend := recordLegend(r); legend != "" {
+		d.Legend = legend
+	}
+	if r.MfgrID != "" {
+		d.Manufacturer = recordManufacturer(r)
+	}
+
+	if !r.FirstSeen.IsZero() {
+		if c.first.IsZero() || r.FirstSeen.Before(c.first) {
+			c.first = r.FirstSeen
+		}
+		if r.FirstSeen.After(c.last) {
+			c.last = r.FirstSeen
+		}
+	}
+	c.bounds.South = min(c.bounds.South, r.Latitude)
+	c.bounds.North = max(c.bounds.North, r.Latitude)
+	c.bounds.West = min(c.bounds.West, r.Longitude)
+	c.bounds.East = max(c.bounds.East, r.Longitude)
+}
+
+// addCapture streams the rows of a WiGLE CSV file into the stats. Rows that
+// can't be read are reported and skipped.
+func (c *statsCollector) addCapture(path string) error {
+	f, err := openCapture(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.stats.Files++
+	r := wigle.NewReader(f)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var re *wigle.RowError
+		if errors.As(err, &re) {
+			fmt.Fprintf(os.Stderr, "stats: %s:%d: skipped, %v\n", path, re.Line, re.Err)
+			c.stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.add(rec)
+	}
+}
+
+// result finishes the stats.
+func (c *statsCollector) result() captureStats {
+	s := c.stats
+	s.Devices = len(c.devices)
+
+	byType := make(map[string]int)
+	byLegend := make(map[string]int)
+	byMfgr := make(map[string]int)
+	top := make([]statsDevice, 0, len(c.devices))
+	for _, d := range c.devices {
+		byType[d.Type]++
+		if d.Type != wigle.TypeWiFi {
+			byLegend[d.Legend]++
+		}
+		if d.Manufacturer != "" {
+			byMfgr[d.Manufacturer]++
+		}
+		top = append(top, *d)
+	}
+	s.ByType = sortedCounts(byType)
+	s.ByLegend = sortedCounts(byLegend)
+	s.ByManufacturer = sortedCounts(byMfgr)
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Rows != top[j].Rows {
+			return top[i].Rows > top[j].Rows
+		}
+		return top[i].MAC < top[j].MAC
+	})
+	s.Top = top[:min(len(top), statsTopDevices)]
+
+	if !c.first.IsZero() {
+		s.FirstSeen, s.LastSeen = &c.first, &c.last
+	}
+	if s.Rows > 0 {
+		s.Bounds = &c.bounds
+	}
+	return s
+}
+
+// sortedCounts lists the groups, largest first.
+func sortedCounts(groups map[string]int) []statsCount {
+	counts := make([]statsCount, 0, len(groups))
+	for name, n := range groups {
+		counts = append(counts, statsCount{name, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Devices != counts[j].Devices {
+			return counts[i].Devices > counts[j].Devices
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+// printStats prints the stats as tables.
+func printStats(s captureStats) {
+	fmt.Printf("Files: %d, rows: %d", s.Files, s.Rows)
+	if s.Skipped > 0 {
+		fmt.Printf(" (%d skipped)", s.Skipped)
+	}
+	fmt.Printf(", unique devices: %d\n", s.Devices)
+	if s.FirstSeen != nil {
+		fmt.Printf("Time range:   %s to %s UTC (%s)\n", s.FirstSeen.Format(wigle.TimeLayout),
+			s.LastSeen.Format(wigle.TimeLayout), s.LastSeen.Sub(*s.FirstSeen).Round(time.Second))
+	}
+	if b := s.Bounds; b != nil {
+		fmt.Printf("Bounding box: %.6f,%.6f to %.6f,%.6f\n", b.South, b.West, b.North, b.East)
+	}
+
+	printCounts := func(title string, counts []statsCount) {
+		if len(counts) == 0 {
+			return
+		}
+		fmt.Printf("\n%-40s  %7s\n", title, "DEVICES")
+		for _, c := range counts {
+			name := c.Name
+			if name == "" {
+				name = "(none)"
+			}
+			fmt.Printf("%-40s  %7d\n", name, c.Devices)
+		}
+	}
+	printCounts("TYPE", s.ByType)
+	printCounts("LEGEND", s.ByLegend)
+	printCounts("MANUFACTURER", s.ByManufacturer)
+
+	if len(s.Top) == 0 {
+		return
+	}
+	fmt.Printf("\n%-17s  %-4s  %-16s  %-20s  %7s\n", "MAC", "TYPE", "LEGEND", "NAME", "ROWS")
+	for _, d := range s.Top {
+		name := d.Name
+		if r := []rune(name); len(r) > 20 {
+			name = string(r[:19]) + "…"
+		}
+		fmt.Printf("%-17s  %-4s  %-16s  %-20s  %7d\n", d.MAC, d.Type, d.Legend, name, d.Rows)
+	}
+}
+
+// runStats implements the stats subcommand: it summarizes the devices in
+// one or more captures.
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the stats as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s stats [-json] FILE...\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c := newStatsCollector()
+	for _, path := range fs.Args() {
+		if err := c.addCapture(path); err != nil {
+			fmt.Fprintf(os.Stderr, "stats: %s: %v\n", path, err)
+			return 1
+		}
+	}
+	s := c.result()
+
+	if *asJSON {
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "stats:", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	printStats(s)
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// statusSnapshot is what the periodic status line reports.
+type statusSnapshot struct {
+	Devices   int
+	Rows      uint64
+	Sightings uint64 // every sighting recorded, written or not
+	NoFix     uint64
+	Fix       bool
+	Accuracy  float64
+	// Satellites used and visible and HDOP, as sky.Counts returns them;
+	// all zero until gpsd sends a SKY report.
+	SatellitesUsed    int
+	SatellitesVisible int
+	HDOP              float64
+	File              string // current output file, if any
+	Free              int64  // bytes free in the output directory, -1 if unknown
+}
+
+// gps describes the fix and the constellation, e.g. "GPS ±4 m, 7/12
+// satellites, HDOP 1.2".
+func (s statusSnapshot) gps() string {
+	gps := "GPS no fix"
+	if s.Fix {
+		gps = fmt.Sprintf("GPS ±%.0f m", s.Accuracy)
+	}
+	if s.SatellitesVisible > 0 {
+		gps += fmt.Sprintf(", %d/%d satellites", s.SatellitesUsed, s.SatellitesVisible)
+	}
+	if s.HDOP > 0 {
+		gps += fmt.Sprintf(", HDOP %.1f", s.HDOP)
+	}
+	return gps
+}
+
+// runStatusLine prints a one-line summary every interval until ctx is
+// cancelled, e.g.
+//
+//	Status: up 1h2m0s, 153 devices, 2041 rows, 37.5 sightings/min, 12 skipped without a fix, GPS ±4 m, 7/12 satellites, HDOP 1.2, wigle-bluetooth-2025-01-01T104918Z.csv 1.2MB, 812.4MB free
+func runStatusLine(ctx context.Context, interval time.Duration, snapshot func() statusSnapshot) {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastSightings uint64
+	last := start
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s := snapshot()
+			rate := float64(s.Sightings-lastSightings) / now.Sub(last).Minutes()
+			lastSightings, last = s.Sightings, now
+
+			parts := []string{
+				"up " + now.Sub(start).Round(time.Second).String(),
+				fmt.Sprintf("%d devices", s.Devices),
+				fmt.Sprintf("%d rows", s.Rows),
+				fmt.Sprintf("%.1f sightings/min", rate),
+				fmt.Sprintf("%d skipped without a fix", s.NoFix),
+				s.gps(),
+			}
+			if s.File != "" {
+				file := filepath.Base(s.File)
+				if info, err := os.Stat(s.File); err == nil {
+					file += " " + formatSize(info.Size())
+				}
+				parts = append(parts, file)
+			}
+			if s.Free >= 0 {
+				parts = append(parts, formatSize(s.Free)+" free")
+			}
+			logInfo("Status: %s", strings.Join(parts, ", "))
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestStatusGPS(t *testing.T) {
+	for _, tt := range []struct {
+		s    statusSnapshot
+		want string
+	}{
+		{statusSnapshot{}, "GPS no fix"},
+		{statusSnapshot{Fix: true, Accuracy: 4.4}, "GPS ±4 m"},
+		{statusSnapshot{Fix: true, Accuracy: 4, SatellitesUsed: 7, SatellitesVisible: 12, HDOP: 1.23},
+			"GPS ±4 m, 7/12 satellites, HDOP 1.2"},
+		{statusSnapshot{SatellitesVisible: 5, HDOP: 99.9}, "GPS no fix, 0/5 satellites, HDOP 99.9"},
+		// gpsd sends some SKY reports with only the DOPs.
+		{statusSnapshot{Fix: true, Accuracy: 8, HDOP: 1.6}, "GPS ±8 m, HDOP 1.6"},
+	} {
+		if got := tt.s.gps(); got != tt.want {
+			t.Errorf("gps() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// stopper ends the session through the usual graceful shutdown and keeps the
+// reason, for the log and the summary. Only the first reason given counts.
+type stopper struct {
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	reason string
+}
+
+// Stop records reason, unless a reason was given already, and starts the
+// shutdown.
+func (s *stopper) Stop(reason string) {
+	s.mu.Lock()
+	if s.reason == "" {
+		s.reason = reason
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// Reason returns why the session stopped, or "" if Stop wasn't called.
+func (s *stopper) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// deviceSummary is one device's line in the end-of-session summary.
+type deviceSummary struct {
+	MAC       string    `json:"mac"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Legend    string    `json:"legend"`
+	Sightings int       `json:"sightings"`
+	MinRSSI   int16     `json:"min_rssi"`
+	MaxRSSI   int16     `json:"max_rssi"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// summarizeDevices lists every device logged, most sighted first.
+func summarizeDevices() []deviceSummary {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+
+	summary := make([]deviceSummary, 0, len(devices))
+	for addr, d := range devices {
+		// Devices only ever seen outside the geofence were never logged.
+		if d.Sightings == 0 {
+			continue
+		}
+		summary = append(summary, deviceSummary{
+			MAC:       addr,
+			Name:      d.Name,
+			Type:      d.Type,
+			Legend:    deviceTypeLegend(d.Class & 0x1FFC),
+			Sightings: d.Sightings,
+			MinRSSI:   d.MinRSSI,
+			MaxRSSI:   d.MaxRSSI,
+			FirstSeen: d.FirstSeen,
+			LastSeen:  d.LastSeen,
+		})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Sightings != summary[j].Sightings {
+			return summary[i].Sightings > summary[j].Sightings
+		}
+		return summary[i].MAC < summary[j].MAC
+	})
+	return summary
+}
+
+// namedDevices counts the devices of the given type, and those of them that
+// had a name.
+func namedDevices(summary []deviceSummary, typ string) (named, total int) {
+	for _, d := range summary {
+		if d.Type != typ {
+			continue
+		}
+		total++
+		if d.Name != "" {
+			named++
+		}
+	}
+	return named, total
+}
+
+// printDeviceSummary prints the top devices as a table.
+func printDeviceSummary(summary []deviceSummary, top int) {
+	if len(summary) == 0 {
+		return
+	}
+	fmt.Printf("%-17s  %-4s  %-16s  %-20s  %9s  %s\n", "MAC", "TYPE", "LEGEND", "NAME", "SIGHTINGS", "RSSI")
+	for i, d := range summary {
+		if i == top {
+			fmt.Printf("... and %d more\n", len(summary)-top)
+			break
+		}
+		name := d.Name
+		if r := []rune(name); len(r) > 20 {
+			name = string(r[:19]) + "…"
+		}
+		fmt.Printf("%-17s  %-4s  %-16s  %-20s  %9d  %d..%d dBm\n",
+			d.MAC, d.Type, d.Legend, name, d.Sightings, d.MinRSSI, d.MaxRSSI)
+	}
+}
+
+// sessionSummary is the JSON summary written at the end of a session.
+type sessionSummary struct {
+	StopReason string          `json:"stop_reason"`
+	Started    time.Time       `json:"started"`
+	Duration   float64         `json:"duration_seconds"`
+	Rows       uint64          `json:"rows_written"`
+	Devices    []deviceSummary `json:"devices"`
+}
+
+// writeDeviceSummary saves the full summary as JSON.
+func writeDeviceSummary(path string, summary sessionSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// trackPointWriter is a file the route is written to.
+type trackPointWriter interface {
+	AddTrackPoint(loc LocationData, t time.Time) error
+	Close() error
+}
+
+// trackLog records the route driven, independently of any sightings, so
+// coverage can be checked afterwards. Points closer than minDistance to the
+// previous one are dropped, which keeps GPS jitter while parked out of both
+// the file and the distance total.
+type trackLog struct {
+	path        string
+	minDistance float64
+	privacy     *privacyFilter // if set, points are written anonymised
+
+	mu       sync.Mutex
+	w        trackPointWriter
+	last     LocationData
+	points   int
+	distance float64
+	start    time.Time
+	end      time.Time
+}
+
+// newTrackLog creates base + "-track.gpx" or base + "-track.csv".
+func newTrackLog(base, format string, minDistance float64) (*trackLog, error) {
+	t := &trackLog{path: base + "-track." + format, minDistance: minDistance}
+	var err error
+	switch format {
+	case "gpx":
+		t.w, err = newGPXWriter(t.path)
+	case "csv":
+		t.w, err = newTrackCSV(t.path)
+	default:
+		err = fmt.Errorf("unknown track format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Path returns the track file's path.
+func (t *trackLog) Path() string {
+	return t.path
+}
+
+// Add records a fix taken at time at.
+func (t *trackLog) Add(loc LocationData, at time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.w == nil { // closed
+		return nil
+	}
+	if t.points == 0 {
+		t.start = at
+	}
+	t.end = at
+	if t.points > 0 {
+		d := geoDistance(t.last.Latitude, t.last.Longitude, loc.Latitude, loc.Longitude)
+		if d < t.minDistance {
+			return nil
+		}
+		t.distance += d
+	}
+	t.last = loc
+	t.points++
+	if t.privacy != nil {
+		loc = t.privacy.Location(loc)
+	}
+	return t.w.AddTrackPoint(loc, at)
+}
+
+// Summary describes the route for the end-of-session report.
+func (t *trackLog) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return fmt.Sprintf("Route: %.2f km in %s, %d track points",
+		t.distance/1000, t.end.Sub(t.start).Round(time.Second), t.points)
+}
+
+func (t *trackLog) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.w
+	t.w = nil
+	return w.Close()
+}
+
+// trackCSV writes track points as CSV, one row per point.
+type trackCSV struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func newTrackCSV(path string) (*trackCSV, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &trackCSV{f: f, w: csv.NewWriter(f)}
+	t.w.Write([]string{"Time", "Latitude", "Longitude", "Altitude", "Accuracy", "Speed", "Course"})
+	t.w.Flush()
+	if err := t.w.Error(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *trackCSV) AddTrackPoint(loc LocationData, at time.Time) error {
+	t.w.Write([]string{
+		at.UTC().Format(time.RFC3339),
+		strconv.FormatFloat(loc.Latitude, 'f', 6, 64),
+		strconv.FormatFloat(loc.Longitude, 'f', 6, 64),
+		strconv.FormatFloat(loc.Altitude, 'f', 1, 64),
+		strconv.FormatFloat(loc.Error, 'f', 1, 64),
+		strconv.FormatFloat(loc.Speed, 'f', 2, 64),
+		strconv.FormatFloat(loc.Track, 'f', 1, 64),
+	})
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *trackCSV) Close() error {
+	t.w.Flush()
+	err := t.w.Error()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Apple Continuity message type used by Find My (offline finding)
+// accessories such as AirTags.
+const appleFindMyType = 0x12
+
+// findMy is a decoded Find My advertisement.
+type findMy struct {
+	// Separated is set when the accessory hasn't been near its owner
+	// recently. Only then does it broadcast its full public key, and the
+	// "maintained" status bit is clear.
+	Separated bool
+	Battery   string
+}
+
+var findMyBattery = []string{"full", "medium", "low", "critical"}
+
+// parseFindMy looks for a Find My message in Apple manufacturer data. The
+// MAC of these accessories rotates, so detection relies on the payload alone.
+func parseFindMy(data []byte) (findMy, bool) {
+	// Apple manufacturer data is a sequence of type, length, value messages.
+	for len(data) >= 2 {
+		typ, n := data[0], int(data[1])
+		if len(data) < 2+n {
+			return findMy{}, false
+		}
+		value := data[2 : 2+n]
+		data = data[2+n:]
+
+		if typ != appleFindMyType || (n != 0x19 && n != 0x02) {
+			continue
+		}
+		status := value[0]
+		return findMy{
+			Separated: n == 0x19 && status&0x04 == 0,
+			Battery:   findMyBattery[status>>6],
+		}, true
+	}
+	return findMy{}, false
+}
+
+// trackerSignature identifies a commercial tracker by an advertised 16-bit
+// service UUID (in the UUID list or as service data) or by a manufacturer
+// data prefix.
+type trackerSignature struct {
+	Name        string
+	ServiceUUID uint16
+	CompanyID   uint16
+	Prefix      []byte // manufacturer data prefix; requires CompanyID
+}
+
+// trackerSignatures lists the trackers recognized besides Find My, which
+// needs its own decoder. Adding a tracker is a matter of adding a row.
+var trackerSignatures = []trackerSignature{
+	{Name: "Tile", ServiceUUID: 0xFEED},
+	{Name: "Tile", ServiceUUID: 0xFEEC},
+	{Name: "Samsung SmartTag", ServiceUUID: 0xFD5A},
+	// Before it is set up, a SmartTag advertises Samsung manufacturer data
+	// instead of the FD5A service.
+	{Name: "Samsung SmartTag", CompanyID: 0x0075, Prefix: []byte{0x42, 0x09, 0x81}},
+	{Name: "Chipolo", ServiceUUID: 0xFE33},
+	{Name: "Chipolo", ServiceUUID: 0xFE65},
+}
+
+// matchTracker returns the name of the first tracker whose signature matches
+// the advertisement.
+func matchTracker(payload bluetooth.AdvertisementPayload) (string, bool) {
+	for _, sig := range trackerSignatures {
+		if sig.ServiceUUID != 0 {
+			uuid := bluetooth.New16BitUUID(sig.ServiceUUID)
+			if payload.HasServiceUUID(uuid) {
+				return sig.Name, true
+			}
+			for _, sd := range payload.ServiceData() {
+				if sd.UUID == uuid {
+					return sig.Name, true
+				}
+			}
+		}
+		if sig.CompanyID != 0 {
+			for _, md := range payload.ManufacturerData() {
+				if md.CompanyID == sig.CompanyID && bytes.HasPrefix(md.Data, sig.Prefix) {
+					return sig.Name, true
+				}
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"tinygo.org/x/bluetooth"
+)
+
+func TestMatchTracker(t *testing.T) {
+	uuid := bluetooth.New16BitUUID
+	mfgr := func(id uint16, data ...byte) []bluetooth.ManufacturerDataElement {
+		return []bluetooth.ManufacturerDataElement{{CompanyID: id, Data: data}}
+	}
+	for _, tt := range []struct {
+		name string
+		adv  Advertisement
+		want string
+	}{
+		{"Tile UUID", Advertisement{ServiceUUIDs: []bluetooth.UUID{uuid(0xFEED)}}, "Tile"},
+		{"Tile service data", Advertisement{ServiceData: []bluetooth.ServiceDataElement{{UUID: uuid(0xFEEC), Data: []byte{1}}}}, "Tile"},
+		{"SmartTag UUID", Advertisement{ServiceUUIDs: []bluetooth.UUID{uuid(0x180F), uuid(0xFD5A)}}, "Samsung SmartTag"},
+		{"SmartTag prefix", Advertisement{ManufacturerData: mfgr(0x0075, 0x42, 0x09, 0x81, 0x02, 0x14)}, "Samsung SmartTag"},
+		{"Chipolo", Advertisement{ServiceUUIDs: []bluetooth.UUID{uuid(0xFE33)}}, "Chipolo"},
+
+		{"other Samsung data", Advertisement{ManufacturerData: mfgr(0x0075, 0x42, 0x04, 0x01)}, ""},
+		{"prefix cut short", Advertisement{ManufacturerData: mfgr(0x0075, 0x42, 0x09)}, ""},
+		{"prefix under another company", Advertisement{ManufacturerData: mfgr(0x004C, 0x42, 0x09, 0x81)}, ""},
+		{"other UUID", Advertisement{ServiceUUIDs: []bluetooth.UUID{uuid(0x180F)}}, ""},
+		{"nothing advertised", Advertisement{}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchTracker(tt.adv.Payload())
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("matchTracker = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
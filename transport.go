@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// transportWindow is how long after the kernel heard a device a D-Bus result
+// for it is put down to that transport. BlueZ passes results on within
+// milliseconds.
+const transportWindow = 2 * time.Second
+
+// transportTracker remembers which transport the kernel last heard each
+// device on. BlueZ keeps a single Device1 for a dual-mode device, so with
+// BR/EDR discovery running the BLE and classic scanners both see each new
+// RSSI, whether it came from an advertisement or an inquiry result. The
+// kernel's Device Found events tell the two apart.
+type transportTracker struct {
+	mu    sync.Mutex
+	heard map[string]heardOn // by address
+}
+
+type heardOn struct {
+	at time.Time
+	le bool
+}
+
+func newTransportTracker() *transportTracker {
+	return &transportTracker{heard: make(map[string]heardOn)}
+}
+
+// Run records the kernel's Device Found events until ctx is cancelled.
+func (t *transportTracker) Run(ctx context.Context) error {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.prune()
+			}
+		}
+	}()
+	return watchDeviceFound(ctx, t.Heard)
+}
+
+// Heard records that the kernel has just heard addr, over LE or BR/EDR.
+func (t *transportTracker) Heard(addr string, le bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.heard[addr] = heardOn{at: time.Now(), le: le}
+}
+
+// Last returns whether the device was last heard over LE rather than BR/EDR.
+// ok is false if it wasn't heard within transportWindow, or the tracker is
+// nil.
+func (t *transportTracker) Last(addr string) (le, ok bool) {
+	if t == nil {
+		return false, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.heard[addr]
+	if !ok || time.Since(h.at) > transportWindow {
+		return false, false
+	}
+	return h.le, true
+}
+
+// prune forgets devices not heard within transportWindow.
+func (t *transportTracker) prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, h := range t.heard {
+		if time.Since(h.at) > transportWindow {
+			delete(t.heard, addr)
+		}
+	}
+}
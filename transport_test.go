@@ -0,0 +1,166 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+func TestTransportTracker(t *testing.T) {
+	var none *transportTracker
+	if _, ok := none.Last("00:11:22:33:44:55"); ok {
+		t.Error("nil tracker knows a transport")
+	}
+
+	tr := newTransportTracker()
+	if _, ok := tr.Last("00:11:22:33:44:55"); ok {
+		t.Error("transport known for a device never heard")
+	}
+	tr.Heard("00:11:22:33:44:55", true)
+	if le, ok := tr.Last("00:11:22:33:44:55"); !ok || !le {
+		t.Errorf("Last = %v, %v after an advertisement, want LE", le, ok)
+	}
+	tr.Heard("00:11:22:33:44:55", false)
+	if le, ok := tr.Last("00:11:22:33:44:55"); !ok || le {
+		t.Errorf("Last = %v, %v after an inquiry result, want BR/EDR", le, ok)
+	}
+
+	tr.heard["00:11:22:33:44:66"] = heardOn{at: time.Now().Add(-2 * transportWindow), le: true}
+	if _, ok := tr.Last("00:11:22:33:44:66"); ok {
+		t.Error("transport still known after transportWindow")
+	}
+	tr.prune()
+	if _, ok := tr.heard["00:11:22:33:44:66"]; ok {
+		t.Error("prune kept a device heard before transportWindow")
+	}
+	if _, ok := tr.heard["00:11:22:33:44:55"]; !ok {
+		t.Error("prune dropped a device just heard")
+	}
+}
+
+// transportDevice is a device as BlueZ presents it to the scan callback.
+type transportDevice struct {
+	addr  string
+	class uint32 // Class property, 0 if none
+	known bool   // its properties are cached
+	mfgr  bool   // advertises manufacturer data
+}
+
+func (d transportDevice) advertisement() Advertisement {
+	adv := Advertisement{Adapter: "hci0", Address: d.addr, RSSI: -60}
+	if d.mfgr {
+		adv.ManufacturerData = []bluetooth.ManufacturerDataElement{{CompanyID: 0x0075, Data: []byte{0x42, 0x04}}}
+	}
+	return adv
+}
+
+func (d transportDevice) addProps(p *pipeline) {
+	if !d.known {
+		return
+	}
+	props := map[string]dbus.Variant{"Address": dbus.MakeVariant(d.addr)}
+	if d.class != 0 {
+		props["Class"] = dbus.MakeVariant(d.class)
+	}
+	p.props.Update("hci0", props)
+}
+
+var (
+	leBeacon = transportDevice{addr: "C0:11:22:33:44:01", known: true, mfgr: true}
+	// A car stereo is found by inquiry only; BlueZ hands its results to the
+	// LE callback too, with no advertising data.
+	carStereo = transportDevice{addr: "00:11:22:33:44:02", class: 0x240408, known: true}
+	dualPhone = transportDevice{addr: "00:11:22:33:44:03", class: 0x5A020C, known: true, mfgr: true}
+	lookingUp = transportDevice{addr: "00:11:22:33:44:04"}
+)
+
+// rowTypes returns the Type of each row written.
+func rowTypes(rows []Sighting) []string {
+	var types []string
+	for _, s := range rows {
+		types = append(types, s.Type)
+	}
+	return types
+}
+
+// TestTransportRows checks which rows the BLE and classic scanners write for
+// each kind of device when the kernel says which transport it heard.
+func TestTransportRows(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		device transportDevice
+		heard  []bool // transports in the order the kernel heard them, true for LE
+		want   []string
+	}{
+		{"LE-only beacon", []string{"--classic"}, leBeacon, []bool{true}, []string{"BLE"}},
+		{"classic-only car stereo", []string{"--classic"}, carStereo, []bool{false}, []string{"BT"}},
+		{"dual-mode phone", []string{"--classic"}, dualPhone, []bool{true, false}, []string{"BLE", "BT"}},
+		{"dual-mode phone, inquiry first", []string{"--classic"}, dualPhone, []bool{false, true}, []string{"BT", "BLE"}},
+		// Without --classic the BLE callback logs inquiry results itself.
+		{"car stereo, --scan-transport auto", []string{"--scan-transport", "auto"}, carStereo, []bool{false}, []string{"BT"}},
+		{"dual-mode phone, --scan-transport auto", []string{"--scan-transport", "auto"}, dualPhone, []bool{true, false}, []string{"BLE", "BT"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.args...)
+			loc := &mockLocation{}
+			loc.Set(testFix(1, 2))
+			p, sink := newTestPipeline(t, cfg, loc)
+			p.transports = newTransportTracker()
+			tt.device.addProps(p)
+
+			for _, le := range tt.heard {
+				// BlueZ reports every new RSSI through Device1, which both
+				// callbacks follow; only classic discovery runs the second.
+				p.transports.Heard(tt.device.addr, le)
+				p.advertisement(tt.device.advertisement())
+				if cfg.Classic {
+					p.inquiryResult("hci0", tt.device.addr, "", tt.device.class, -60)
+				}
+			}
+			if got := rowTypes(sink.rows()); !slices.Equal(got, tt.want) {
+				t.Errorf("rows %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTransportGuess covers the BLE callback's guess when the kernel hasn't
+// said which transport a result came in on.
+func TestTransportGuess(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		classic bool
+		device  transportDevice
+		want    []string
+	}{
+		{"advertising data", true, leBeacon, []string{"BLE"}},
+		{"advertising data and a class", true, dualPhone, []string{"BLE"}},
+		{"class but no advertising data", true, carStereo, nil},
+		{"class still being looked up", true, lookingUp, nil},
+		{"no class and no advertising data", true, transportDevice{addr: "C0:11:22:33:44:06", known: true}, []string{"BLE"}},
+		// Without --classic, BR/EDR discovery isn't running, so everything
+		// is an advertisement.
+		{"no --classic, class", false, carStereo, []string{"BLE"}},
+		{"no --classic, looked up", false, lookingUp, []string{"BLE"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.classic {
+				args = append(args, "--classic")
+			}
+			loc := &mockLocation{}
+			loc.Set(testFix(1, 2))
+			p, sink := newTestPipeline(t, testConfig(t, args...), loc)
+			tt.device.addProps(p)
+
+			p.advertisement(tt.device.advertisement())
+			if got := rowTypes(sink.rows()); !slices.Equal(got, tt.want) {
+				t.Errorf("rows %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
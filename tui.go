@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tuiLogLines is how many lines of ordinary output the TUI keeps, of which
+// the last few are shown below the table.
+const tuiLogLines = 200
+
+// tuiDevice is one row of the TUI's device table.
+type tuiDevice struct {
+	MAC      string
+	Name     string
+	Type     string
+	RSSI     int16
+	Count    int
+	LastSeen time.Time
+}
+
+// tui is the --tui full-screen display: a status line, a table of devices
+// and the most recent lines of the ordinary output, redrawn every second.
+// While it runs, os.Stdout is a pipe whose lines the TUI shows, so the rest
+// of the program prints as usual.
+type tui struct {
+	devices func() []tuiDevice
+	status  func() string
+	pause   func() // toggles scanning
+	quit    func()
+
+	term     *os.File // the real stdout
+	oldState *unix.Termios
+	pipe     *os.File
+	logDone  chan struct{}
+	redraw   chan struct{}
+
+	mu       sync.Mutex
+	logs     []string
+	byRSSI   bool   // sort by RSSI rather than by last seen
+	selected string // MAC of the highlighted row
+	followed string // MAC pinned to the top of the table
+	best     int16  // strongest RSSI of the followed device so far
+}
+
+// Start switches the terminal to the TUI. It fails if stdin isn't a
+// terminal.
+func (t *tui) Start() error {
+	fd := int(os.Stdin.Fd())
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("stdin is not a terminal: %w", err)
+	}
+	raw := *old
+	// Keys arrive one at a time and aren't echoed; Ctrl-C still stops
+	// the program through SIGINT.
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return err
+	}
+	t.oldState = old
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		unix.IoctlSetTermios(fd, unix.TCSETS, old)
+		return err
+	}
+	t.term, t.pipe = os.Stdout, w
+	os.Stdout = w
+	t.logDone = make(chan struct{})
+	t.redraw = make(chan struct{}, 1)
+	go t.readLogs(r)
+
+	// Alternate screen, cursor hidden.
+	fmt.Fprint(t.term, "\033[?1049h\033[?25l")
+	return nil
+}
+
+// Stop restores the terminal and stdout, then prints the output captured
+// while the TUI was up so it stays in the scrollback.
+func (t *tui) Stop() {
+	os.Stdout = t.term
+	t.pipe.Close()
+	<-t.logDone
+	fmt.Fprint(t.term, "\033[?25h\033[?1049l")
+	unix.IoctlSetTermios(int(os.Stdin.Fd()), unix.TCSETS, t.oldState)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range t.logs {
+		fmt.Println(line)
+	}
+}
+
+func (t *tui) readLogs(r *os.File) {
+	defer close(t.logDone)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		t.mu.Lock()
+		t.logs = append(t.logs, sc.Text())
+		if len(t.logs) > tuiLogLines {
+			t.logs = t.logs[len(t.logs)-tuiLogLines:]
+		}
+		t.mu.Unlock()
+		t.requestRedraw()
+	}
+}
+
+func (t *tui) requestRedraw() {
+	select {
+	case t.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// Run redraws the screen and handles keys until ctx is cancelled.
+func (t *tui) Run(ctx context.Context) {
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case keys <- string(buf[:n]):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		t.draw()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-t.redraw:
+		case key := <-keys:
+			t.handleKey(key)
+		}
+	}
+}
+
+func (t *tui) handleKey(key string) {
+	switch key {
+	case "q", "Q":
+		t.quit()
+	case "p", "P":
+		t.pause()
+	case "s", "S":
+		t.mu.Lock()
+		t.byRSSI = !t.byRSSI
+		t.mu.Unlock()
+	case "f", "F":
+		t.mu.Lock()
+		if t.followed == t.selected {
+			t.followed = ""
+		} else {
+			t.followed, t.best = t.selected, -128
+		}
+		t.mu.Unlock()
+	case "k", "\033[A":
+		t.move(-1)
+	case "j", "\033[B":
+		t.move(1)
+	}
+}
+
+// move shifts the highlight by delta rows.
+func (t *tui) move(delta int) {
+	rows := t.sorted()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(rows) == 0 {
+		return
+	}
+	i := slices.IndexFunc(rows, func(d tuiDevice) bool { return d.MAC == t.selected })
+	i = min(max(i+delta, 0), len(rows)-1)
+	t.selected = rows[i].MAC
+}
+
+// sorted returns the device table in display order, the followed device
+// first.
+func (t *tui) sorted() []tuiDevice {
+	rows := t.devices()
+	t.mu.Lock()
+	byRSSI, followed := t.byRSSI, t.followed
+	t.mu.Unlock()
+	slices.SortFunc(rows, func(a, b tuiDevice) int {
+		if (a.MAC == followed) != (b.MAC == followed) {
+			if a.MAC == followed {
+				return -1
+			}
+			return 1
+		}
+		if byRSSI {
+			if c := cmp.Compare(b.RSSI, a.RSSI); c != 0 {
+				return c
+			}
+		}
+		if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+			return c
+		}
+		return strings.Compare(a.MAC, b.MAC)
+	})
+	return rows
+}
+
+func (t *tui) draw() {
+	width, height := 80, 24
+	if ws, err := unix.IoctlGetWinsize(int(t.term.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 {
+		width, height = int(ws.Col), int(ws.Row)
+	}
+	rows := t.sorted()
+	status := t.status()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.selected == "" && len(rows) > 0 {
+		t.selected = rows[0].MAC
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	line := func(s string, highlight bool) {
+		if r := []rune(s); len(r) > width {
+			s = string(r[:width])
+		}
+		if highlight {
+			s = "\033[7m" + s + "\033[0m"
+		}
+		b.WriteString(s + "\033[K\r\n")
+	}
+
+	sortName := "last seen"
+	if t.byRSSI {
+		sortName = "RSSI"
+	}
+	line(status, false)
+	if t.followed != "" {
+		i := slices.IndexFunc(rows, func(d tuiDevice) bool { return d.MAC == t.followed })
+		if i >= 0 {
+			d := rows[i]
+			if d.RSSI >= t.best+followCueDB {
+				if t.best > -128 {
+					b.WriteString("\a")
+				}
+				t.best = d.RSSI
+			}
+			line(fmt.Sprintf("Following %s: RSSI %d dBm, best %d dBm, seen %s ago",
+				d.MAC, d.RSSI, t.best, time.Since(d.LastSeen).Round(time.Second)), false)
+		}
+	} else {
+		line(fmt.Sprintf("%d devices, sorted by %s", len(rows), sortName), false)
+	}
+	line(fmt.Sprintf("  %-17s  %-24s  %-4s  %4s  %6s  %6s", "MAC", "NAME", "TYPE", "RSSI", "COUNT", "AGE"), false)
+
+	logN := min(5, len(t.logs), max(height/4, 1))
+	tableN := max(height-4-logN, 0)
+	for i := range tableN {
+		if i >= len(rows) {
+			line("", false)
+			continue
+		}
+		d := rows[i]
+		marker := " "
+		if d.MAC == t.followed {
+			marker = "*"
+		}
+		name := []rune(d.Name)
+		if len(name) > 24 {
+			name = name[:24]
+		}
+		line(fmt.Sprintf("%s %-17s  %-24s  %-4s  %4d  %6d  %6s", marker, d.MAC, string(name), d.Type,
+			d.RSSI, d.Count, time.Since(d.LastSeen).Round(time.Second)), d.MAC == t.selected)
+	}
+	for _, l := range t.logs[len(t.logs)-logN:] {
+		line(l, false)
+	}
+	help := "↑/↓ select  s sort  p pause  f follow  q quit"
+	if r := []rune(help); len(r) > width {
+		help = string(r[:width])
+	}
+	b.WriteString(help + "\033[K\033[J")
+	fmt.Fprint(t.term, b.String())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const wigleUploadURL = "https://api.wigle.net/api/v2/file/upload"
+
+// pendingSuffix marks a capture that still has to be uploaded to WiGLE. The
+// marker is created when the capture starts and removed once WiGLE accepts
+// the file, so anything interrupted or rejected is retried on the next run.
+const pendingSuffix = ".pending"
+
+// wigleUploader posts finished captures to the WiGLE file upload API.
+type wigleUploader struct {
+	apiName  string
+	apiToken string
+	client   *http.Client
+}
+
+func newWigleUploader(apiName, apiToken string) *wigleUploader {
+	return &wigleUploader{
+		apiName:  apiName,
+		apiToken: apiToken,
+		client:   &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// markPending queues path for upload.
+func (u *wigleUploader) markPending(path string) error {
+	return os.WriteFile(path+pendingSuffix, nil, 0644)
+}
+
+// maxLoggedProblems caps how many validation problems are logged for a
+// capture that is held back from upload; `validate` lists them all.
+const maxLoggedProblems = 5
+
+// uploadFinished uploads a capture that will not be written to anymore,
+// leaving it queued if the upload fails. A capture that doesn't validate is
+// held back, since WiGLE would reject it without saying why; it goes on the
+// next run after it has been fixed.
+func (u *wigleUploader) uploadFinished(path string) {
+	_, problems, err := validateCapture(path)
+	if err != nil {
+		logWarn("Not uploading %s to WiGLE, failed to validate it: %v", path, err)
+		return
+	}
+	if len(problems) > 0 {
+		logWarn("Not uploading %s to WiGLE, it has %d problems:", path, len(problems))
+		for _, p := range problems[:min(len(problems), maxLoggedProblems)] {
+			logWarn("  %v", p)
+		}
+		return
+	}
+
+	transID, err := u.upload(path)
+	if err != nil {
+		logWarn("WiGLE upload of %s failed, will retry next run: %v", path, err)
+		return
+	}
+	os.Remove(path + pendingSuffix)
+	logInfo("Uploaded %s to WiGLE, transid %s", path, transID)
+}
+
+// uploadPending retries every queued capture in dir except current, which is
+// still being written.
+func (u *wigleUploader) uploadPending(dir, current string) {
+	markers, err := filepath.Glob(filepath.Join(dir, "*"+pendingSuffix))
+	if err != nil {
+		logWarn("failed to list pending WiGLE uploads: %v", err)
+		return
+	}
+	for _, marker := range markers {
+		path := strings.TrimSuffix(marker, pendingSuffix)
+		if path == current {
+			continue
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			os.Remove(marker)
+			continue
+		}
+		u.uploadFinished(path)
+	}
+}
+
+func (u *wigleUploader) upload(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, wigleUploadURL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(u.apiName, u.apiToken)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Results struct {
+			TransID string `json:"transid"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || !result.Success {
+		return "", fmt.Errorf("%s: %s", resp.Status, result.Message)
+	}
+	return result.Results.TransID, nil
+}
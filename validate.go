@@ -0,0 +1,88 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/craftzman7/wigle-bluetooth-pineapplepager/pkg/wigle"
+)
+
+// openCapture opens a WiGLE CSV file, gunzipping it if its name ends in .gz.
+func openCapture(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{gz, f}, nil
+}
+
+// gzipFile closes both the gzip stream and the file under it.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	return errors.Join(g.Reader.Close(), g.f.Close())
+}
+
+// validateCapture checks a WiGLE CSV file, see openCapture.
+func validateCapture(path string) (int, []wigle.Problem, error) {
+	r, err := openCapture(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer r.Close()
+	return wigle.Validate(r)
+}
+
+// runValidate implements the validate subcommand: it checks each file the
+// way WiGLE would read it and lists every problem.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate FILE...\n", os.Args[0])
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		rows, problems, err := validateCapture(path)
+		for _, p := range problems {
+			fmt.Printf("%s:%d: %s\n", path, p.Line, p.Message)
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "validate: %s: %v\n", path, err)
+			status = 1
+		case len(problems) > 0:
+			fmt.Printf("%s: %d problems in %d rows\n", path, len(problems), rows)
+			status = 1
+		default:
+			fmt.Printf("%s: OK, %d rows\n", path, rows)
+		}
+	}
+	return status
+}
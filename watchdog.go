@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// scanWatchdog restarts the BLE scan when BlueZ wedges and stops delivering
+// results. Quiet periods only count while there is a GPS fix, since without
+// one nothing would be logged anyway. It is a Scanner itself, wrapping the
+// adapter's.
+type scanWatchdog struct {
+	scanner    Scanner
+	timeout    time.Duration
+	powerCycle bool
+	bus        *systemBus
+	hasFix     func() bool
+	duty       *dutyCycle // if set, the scan rests between periods
+	pause      *pauseSwitch
+
+	resting  atomic.Bool  // set while the duty cycle rests or scanning is paused
+	last     atomic.Int64 // unix nanoseconds of the latest result
+	restart  atomic.Bool  // set when the watchdog stopped the scan
+	restarts atomic.Uint64
+}
+
+// Seen notes that a scan result has arrived.
+func (w *scanWatchdog) Seen() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+func (w *scanWatchdog) ID() string { return w.scanner.ID() }
+
+// Stop ends a running Scan for good, rather than restarting it.
+func (w *scanWatchdog) Stop() error { return w.scanner.Stop() }
+
+func (w *scanWatchdog) Close() error { return w.scanner.Close() }
+
+// Restarts returns the number of times the scan has been restarted.
+func (w *scanWatchdog) Restarts() uint64 {
+	return w.restarts.Load()
+}
+
+// Scan runs the scanner, restarting it after each watchdog timeout, until
+// the scan is stopped by someone else or fails. Losing the bus or BlueZ
+// isn't a failure: the scan is retried with backoff until they are back.
+// With a duty cycle, the scan is stopped after each on period and started
+// again after the rest. While scanning is paused the scan is stopped too.
+func (w *scanWatchdog) Scan(ctx context.Context, callback func(Advertisement)) error {
+	w.Seen()
+	if w.timeout > 0 {
+		go w.watch(ctx)
+	}
+
+	var backoff time.Duration
+	for {
+		if w.pause.Paused() {
+			w.resting.Store(true)
+			w.pause.Wait(ctx)
+			w.resting.Store(false)
+			if ctx.Err() != nil {
+				return nil
+			}
+			w.Seen()
+		}
+		scanCtx, endScan := w.pause.Context(ctx)
+		periodCtx, endPeriod := scanCtx, context.CancelFunc(func() {})
+		if w.duty != nil {
+			periodCtx, endPeriod = context.WithTimeout(scanCtx, w.duty.on)
+		}
+		err := w.scanner.Scan(periodCtx, func(adv Advertisement) {
+			w.Seen()
+			callback(adv)
+		})
+		periodOver, paused := periodCtx.Err() != nil, scanCtx.Err() != nil
+		endPeriod()
+		endScan()
+		if ctx.Err() != nil {
+			return err
+		}
+		if err == nil && paused {
+			continue
+		}
+		if err == nil && periodOver && !w.restart.Load() {
+			logInfo("Scan on %s resting for %s", w.scanner.ID(), w.duty.off)
+			w.resting.Store(true)
+			w.duty.Rest(ctx)
+			w.resting.Store(false)
+			if ctx.Err() != nil {
+				return nil
+			}
+			logInfo("Scan on %s resumed for %s", w.scanner.ID(), w.duty.on)
+			w.Seen()
+			continue
+		}
+		if busUnavailable(err) {
+			backoff = min(max(2*backoff, time.Second), dbusMaxBackoff)
+			logWarn("scan on %s interrupted (%v), retrying in %s", w.scanner.ID(), err, backoff)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			w.Seen()
+			continue
+		}
+		backoff = 0
+		if !w.restart.Swap(false) {
+			return err
+		}
+		w.restarts.Add(1)
+		if w.powerCycle {
+			if err := w.cycle(); err != nil {
+				logWarn("failed to power-cycle %s: %v", w.scanner.ID(), err)
+			} else {
+				logInfo("Power-cycled %s", w.scanner.ID())
+			}
+		}
+		w.Seen()
+		logInfo("Scan restarted on %s", w.scanner.ID())
+	}
+}
+
+func (w *scanWatchdog) watch(ctx context.Context) {
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !w.hasFix() || w.resting.Load() {
+			// Start counting again once the fix or the scan is back.
+			w.Seen()
+			continue
+		}
+		quiet := time.Since(time.Unix(0, w.last.Load()))
+		if quiet < w.timeout {
+			continue
+		}
+		logWarn("No scan results on %s for %s, restarting scan", w.scanner.ID(), quiet.Round(time.Second))
+		w.Seen()
+		w.restart.Store(true)
+		if err := w.scanner.Stop(); err != nil {
+			w.restart.Store(false)
+			logWarn("failed to stop scan: %v", err)
+		}
+	}
+}
+
+func (w *scanWatchdog) cycle() error {
+	conn, err := w.bus.Conn()
+	if err != nil {
+		return err
+	}
+	if err := setPowered(conn, w.scanner.ID(), false); err != nil {
+		return err
+	}
+	time.Sleep(time.Second)
+	return setPowered(conn, w.scanner.ID(), true)
+}
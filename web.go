@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed webmap.html
+var webMapPage []byte
+
+const (
+	// webClientBuffer is how many events may queue for one client before
+	// the oldest are dropped for it.
+	webClientBuffer = 256
+	// webPositionInterval is how often the current position is pushed.
+	webPositionInterval = 2 * time.Second
+	// webKeepalive is how often /stream sends a comment so that idle
+	// proxies and clients can tell the connection is still up.
+	webKeepalive = 15 * time.Second
+)
+
+// webServer is the optional --http listener: a live map page, the event
+// stream behind it, and for other programs a plain stream of sightings and a
+// JSON API for the session's state and device table. Everything it serves is
+// read-only; controls need the --http-token.
+type webServer struct {
+	token    string
+	hub      *eventHub
+	started  time.Time
+	position func() (LocationData, bool) // false while there is nothing to show
+	status   func() webStatus            // everything but the GPS position and uptime
+	devices  func() []deviceSummary      // a copy of the device table, privacy applied
+	pause    func()                      // toggles scanning
+	paused   func() bool
+
+	srv *http.Server
+}
+
+// Start listens on addr and serves in the background until Close.
+func (w *webServer) Start(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", w.handleMap)
+	mux.HandleFunc("GET /events", w.handleEvents)
+	mux.HandleFunc("GET /stream", w.handleStream)
+	mux.HandleFunc("GET /status", w.handleStatus)
+	mux.HandleFunc("GET /devices", w.handleDevices)
+	mux.HandleFunc("GET /devices/{mac}", w.handleDevice)
+	mux.HandleFunc("POST /api/pause", w.handlePause)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// Event streams never go idle, so they end with ctx rather than with
+	// a graceful shutdown.
+	w.srv = &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		if err := w.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logWarn("web server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops the listener and drops every connection.
+func (w *webServer) Close() error {
+	return w.srv.Close()
+}
+
+func (w *webServer) handleMap(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write(webMapPage)
+}
+
+// handleEvents streams sightings and the current position as Server-Sent
+// Events.
+func (w *webServer) handleEvents(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events := w.hub.Subscribe()
+	defer w.hub.Unsubscribe(events)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(webPositionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(rw, "event: sighting\ndata: %s\n\n", data)
+		case <-ticker.C:
+			loc, ok := w.position()
+			data, _ := json.Marshal(struct {
+				Fix      bool    `json:"fix"`
+				Lat      float64 `json:"lat,omitempty"`
+				Lon      float64 `json:"lon,omitempty"`
+				Accuracy float64 `json:"accuracy,omitempty"`
+				Paused   bool    `json:"paused"`
+			}{ok && loc.Fix, loc.Latitude, loc.Longitude, loc.Error, w.paused()})
+			fmt.Fprintf(rw, "event: position\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+// handleStream streams every sighting as a Server-Sent Event holding its
+// JSON Lines record, e.g. for "curl -N host:8080/stream".
+func (w *webServer) handleStream(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events := w.hub.Subscribe()
+	defer w.hub.Unsubscribe(events)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(webKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(rw, "data: %s\n\n", data)
+		case <-ticker.C:
+			fmt.Fprint(rw, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// webStatus is the body of GET /status.
+type webStatus struct {
+	GPS struct {
+		Fix               bool    `json:"fix"`
+		Lat               float64 `json:"lat,omitempty"`
+		Lon               float64 `json:"lon,omitempty"`
+		Accuracy          float64 `json:"accuracy,omitempty"`
+		SatellitesUsed    int     `json:"satellites_used"`
+		SatellitesVisible int     `json:"satellites_visible"`
+		HDOP              float64 `json:"hdop"`
+	} `json:"gps"`
+	Scan struct {
+		Paused        bool         `json:"paused"`
+		OutputStopped bool         `json:"output_stopped"` // for lack of disk space
+		Adapters      []webAdapter `json:"adapters"`
+	} `json:"scan"`
+	UniqueDevices int               `json:"unique_devices"`
+	RowsWritten   uint64            `json:"rows_written"`
+	Skipped       map[string]uint64 `json:"skipped"`
+	StreamClients int               `json:"stream_clients"`
+	OutputFile    string            `json:"output_file,omitempty"`
+	FreeBytes     *int64            `json:"free_bytes,omitempty"` // in the output directory
+	Started       time.Time         `json:"started"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+}
+
+// webAdapter is one adapter's scan in GET /status.
+type webAdapter struct {
+	ID       string `json:"id"`
+	State    string `json:"state"` // "scanning" or "resting"
+	Restarts uint64 `json:"restarts"`
+}
+
+func (w *webServer) handleStatus(rw http.ResponseWriter, r *http.Request) {
+	st := w.status()
+	if loc, ok := w.position(); ok {
+		st.GPS.Fix = true
+		st.GPS.Lat, st.GPS.Lon, st.GPS.Accuracy = loc.Latitude, loc.Longitude, loc.Error
+	}
+	st.Scan.Paused = w.paused()
+	st.StreamClients, _, _ = w.hub.Stats()
+	st.Started = w.started
+	st.UptimeSeconds = int64(time.Since(w.started).Seconds())
+	writeJSON(rw, http.StatusOK, st)
+}
+
+// handleDevices lists the devices logged so far, most sighted first.
+// "type" keeps one type (BLE, BT or WIFI) and "min_rssi" keeps devices whose
+// strongest sighting reached it.
+func (w *webServer) handleDevices(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	typ := q.Get("type")
+	minRSSI := math.MinInt
+	if v := q.Get("min_rssi"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(rw, "min_rssi must be a whole number of dBm", http.StatusBadRequest)
+			return
+		}
+		minRSSI = n
+	}
+	list := []deviceSummary{}
+	for _, d := range w.devices() {
+		if (typ == "" || strings.EqualFold(d.Type, typ)) && int(d.MaxRSSI) >= minRSSI {
+			list = append(list, d)
+		}
+	}
+	writeJSON(rw, http.StatusOK, list)
+}
+
+func (w *webServer) handleDevice(rw http.ResponseWriter, r *http.Request) {
+	mac := r.PathValue("mac")
+	for _, d := range w.devices() {
+		if strings.EqualFold(d.MAC, mac) {
+			writeJSON(rw, http.StatusOK, d)
+			return
+		}
+	}
+	http.Error(rw, "no such device", http.StatusNotFound)
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// handlePause toggles scanning. It needs the token.
+func (w *webServer) handlePause(rw http.ResponseWriter, r *http.Request) {
+	if !w.authorized(r) {
+		http.Error(rw, "forbidden: controls need --http-token", http.StatusForbidden)
+		return
+	}
+	w.pause()
+	writeJSON(rw, http.StatusOK, map[string]bool{"paused": w.paused()})
+}
+
+// authorized reports whether r carries the token as a bearer token. Without
+// a token configured nothing is authorized.
+func (w *webServer) authorized(r *http.Request) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return w.token != "" && ok && subtle.ConstantTimeCompare([]byte(given), []byte(w.token)) == 1
+}
+
+// eventHub is a Sink that fans sightings out to web clients as JSON. A slow
+// client only loses its own oldest events; the scan never waits.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	closed  bool
+
+	connections, dropped atomic.Uint64
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: make(map[chan []byte]bool)}
+}
+
+// Subscribe returns a channel of JSON-encoded sightings.
+func (h *eventHub) Subscribe() chan []byte {
+	ch := make(chan []byte, webClientBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+	} else {
+		h.clients[ch] = true
+		h.connections.Add(1)
+	}
+	return ch
+}
+
+func (h *eventHub) Unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, ch)
+}
+
+func (h *eventHub) Write(s Sighting) error {
+	data, err := json.Marshal(newJSONLRecord(s))
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- data:
+			continue
+		default:
+		}
+		// Full: make room by dropping the oldest event. Only Write sends,
+		// under mu, so the send after that can't block.
+		select {
+		case <-ch:
+			h.dropped.Add(1)
+		default:
+		}
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of clients connected now, the number that have
+// connected in all and the number of events dropped for slow clients.
+func (h *eventHub) Stats() (clients int, connections, dropped uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients), h.connections.Load(), h.dropped.Load()
+}
+
+// Close ends every client's stream.
+func (h *eventHub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		close(ch)
+		delete(h.clients, ch)
+	}
+	h.closed = true
+	return nil
+}
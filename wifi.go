@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wifiScanner lists access points with iw. A triggered scan takes the radio
+// off its channel for a few seconds, which fails while the Pineapple's own
+// recon has the interface; the scanner then falls back to the results the
+// kernel already has, which that recon keeps fresh.
+type wifiScanner struct {
+	iface    string
+	interval time.Duration
+	pause    *pauseSwitch
+}
+
+// wifiNetwork is one access point from a scan.
+type wifiNetwork struct {
+	BSSID     string
+	SSID      string
+	Frequency int // MHz
+	Signal    int16
+	AuthMode  string
+}
+
+// Run scans every interval until ctx is cancelled, calling found for every
+// access point. Failures are printed and retried on the next interval. No
+// scans are made while scanning is paused.
+func (w *wifiScanner) Run(ctx context.Context, found func(wifiNetwork)) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var lastErr string
+	for {
+		if !w.pause.Wait(ctx) {
+			return
+		}
+		networks, err := w.Scan(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// A busy or missing interface fails the same way every time.
+			if err.Error() != lastErr {
+				logWarn("WiFi scan on %s failed: %v", w.iface, err)
+			}
+			lastErr = err.Error()
+		} else {
+			lastErr = ""
+		}
+		for _, n := range networks {
+			found(n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan triggers a scan and returns its results, or the cached results if the
+// interface is busy.
+func (w *wifiScanner) Scan(ctx context.Context) ([]wifiNetwork, error) {
+	out, err := w.iw(ctx, "scan")
+	if err != nil {
+		var cached error
+		if out, cached = w.iw(ctx, "scan", "dump"); cached != nil {
+			return nil, err
+		}
+	}
+	return parseIWScan(out), nil
+}
+
+func (w *wifiScanner) iw(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "iw", append([]string{"dev", w.iface}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// parseIWScan parses the output of "iw dev <iface> scan".
+func parseIWScan(out []byte) []wifiNetwork {
+	var networks []wifiNetwork
+	var cur *wifiNetwork
+	var ess, ibss, privacy, wps bool
+	var wpa, rsn *wifiSecurity
+	var section *wifiSecurity
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		cur.AuthMode = wifiAuthMode(wpa, rsn, privacy, ess, ibss, wps)
+		networks = append(networks, *cur)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "BSS "); ok {
+			finish()
+			bssid, _, _ := strings.Cut(rest, "(")
+			cur = &wifiNetwork{BSSID: strings.ToUpper(strings.TrimSpace(bssid))}
+			ess, ibss, privacy, wps = false, false, false, false
+			wpa, rsn, section = nil, nil, nil
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		field := strings.TrimSpace(line)
+		// IE details are indented below their heading as "* Key: value".
+		if item, ok := strings.CutPrefix(field, "* "); ok && section != nil {
+			section.parse(item)
+			continue
+		}
+		key, value, _ := strings.Cut(field, ":")
+		value = strings.TrimSpace(value)
+		section = nil
+		switch key {
+		case "freq":
+			f, _ := strconv.ParseFloat(value, 64)
+			cur.Frequency = int(f)
+		case "signal":
+			dBm, _, _ := strings.Cut(value, " ")
+			s, _ := strconv.ParseFloat(dBm, 64)
+			cur.Signal = int16(s)
+		case "SSID":
+			cur.SSID = value
+		case "capability":
+			ess = strings.Contains(value, "ESS")
+			ibss = strings.Contains(value, "IBSS")
+			privacy = strings.Contains(value, "Privacy")
+		case "WPS":
+			wps = true
+		case "WPA":
+			wpa = &wifiSecurity{}
+			section = wpa
+			if item, ok := strings.CutPrefix(value, "* "); ok {
+				wpa.parse(item)
+			}
+		case "RSN":
+			rsn = &wifiSecurity{}
+			section = rsn
+			if item, ok := strings.CutPrefix(value, "* "); ok {
+				rsn.parse(item)
+			}
+		}
+	}
+	finish()
+	return networks
+}
+
+// wifiSecurity is what a WPA or RSN information element advertises.
+type wifiSecurity struct {
+	ciphers []string
+	auth    []string
+}
+
+func (s *wifiSecurity) parse(item string) {
+	key, value, _ := strings.Cut(item, ":")
+	switch strings.TrimSpace(key) {
+	case "Pairwise ciphers":
+		s.ciphers = strings.Fields(value)
+	case "Authentication suites":
+		// The one suite name with a space in it.
+		s.auth = strings.Fields(strings.ReplaceAll(value, "IEEE 802.1X", "IEEE-802.1X"))
+	}
+}
+
+// wifiAuthMode builds an Android-style capabilities string, which is what
+// WiGLE expects in AuthMode, e.g. "[WPA2-PSK-CCMP][ESS][WPS]".
+func wifiAuthMode(wpa, rsn *wifiSecurity, privacy, ess, ibss, wps bool) string {
+	var b strings.Builder
+	ie := func(proto string, s *wifiSecurity) {
+		auth := "?"
+		if len(s.auth) > 0 {
+			names := make([]string, len(s.auth))
+			for i, a := range s.auth {
+				names[i] = wifiAuthNames[a]
+				if names[i] == "" {
+					names[i] = a
+				}
+			}
+			auth = strings.Join(names, "+")
+		}
+		fmt.Fprintf(&b, "[%s-%s", proto, auth)
+		if len(s.ciphers) > 0 {
+			b.WriteString("-" + strings.Join(s.ciphers, "+"))
+		}
+		b.WriteString("]")
+	}
+	if wpa != nil {
+		ie("WPA", wpa)
+	}
+	if rsn != nil {
+		ie("WPA2", rsn)
+	}
+	if privacy && wpa == nil && rsn == nil {
+		b.WriteString("[WEP]")
+	}
+	if wps {
+		b.WriteString("[WPS]")
+	}
+	if ess {
+		b.WriteString("[ESS]")
+	}
+	if ibss {
+		b.WriteString("[IBSS]")
+	}
+	return b.String()
+}
+
+// wifiAuthNames maps iw's authentication suite names to Android's.
+var wifiAuthNames = map[string]string{
+	"PSK":            "PSK",
+	"IEEE-802.1X":    "EAP",
+	"SAE":            "SAE",
+	"FT/PSK":         "FT/PSK",
+	"FT/SAE":         "FT/SAE",
+	"FT/IEEE-802.1X": "FT/EAP",
+	"OWE":            "OWE",
+	"PSK/SHA-256":    "PSK-SHA256",
+}
+
+// wifiChannel returns the channel number of a frequency in MHz, or 0.
+func wifiChannel(freq int) int {
+	switch {
+	case freq == 2484:
+		return 14
+	case freq >= 2412 && freq <= 2472:
+		return (freq - 2407) / 5
+	case freq >= 5160 && freq <= 5885:
+		return (freq - 5000) / 5
+	case freq >= 5955 && freq <= 7115:
+		return (freq - 5950) / 5
+	}
+	return 0
+}